@@ -0,0 +1,147 @@
+/*
+zbc_asm assembles listings produced by zbc_disasm back into Gamewave bytecode.
+*/
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc"
+	"github.com/namgo/GameWaveFans/pkg/zbc/asm"
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+	"github.com/spf13/pflag"
+)
+
+// flags
+var (
+	outputName string
+	pack       bool
+	verify     bool
+)
+
+func parseFlags() {
+	pflag.StringVarP(&outputName, "output", "o", "", "name of the output file")
+	pflag.BoolVarP(&pack, "pack", "p", false, "pack output to .zbc file, instead of writing unpacked bytecode")
+	pflag.BoolVar(&verify, "verify", false, "check assembled bytecode, and don't write files that would crash the console")
+	pflag.Parse()
+}
+
+func usage() {
+	fmt.Println("Assembles .zbc_asm listings to bytecode used by Gamewave console")
+	fmt.Println("Directories are searched recursively for .zbc_asm files")
+	fmt.Println("Flags:")
+	pflag.PrintDefaults()
+}
+
+func outputExt() string {
+	if pack {
+		return ".zbc"
+	}
+	return ".zbc_unpacked"
+}
+
+func main() {
+	failed := false
+	parseFlags()
+	args := pflag.Args()
+	if len(args) < 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	if outputName != "" && len(args) > 1 {
+		fmt.Println("Output name can only be used with one input file")
+		usage()
+		os.Exit(1)
+	}
+
+	for _, inputName := range args {
+		f, err := os.Stat(inputName)
+		if err != nil {
+			fmt.Printf("Failed to get info about %s: %s\n", inputName, err)
+			failed = true
+			continue
+		}
+		if f.IsDir() {
+			err := filepath.Walk(inputName, getWalkFunc(&failed))
+			if err != nil {
+				fmt.Printf("Failed to assemble dir %s: %s\n", inputName, err)
+				failed = true
+			}
+		} else {
+			if outputName == "" || len(args) > 1 {
+				outputName = strings.TrimSuffix(inputName, filepath.Ext(inputName)) + outputExt()
+			}
+			err := assemble(inputName, outputName)
+			if err != nil {
+				fmt.Printf("Failed to assemble %s: %s\n", inputName, err)
+				failed = true
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func getWalkFunc(failed *bool) filepath.WalkFunc {
+	return func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.ToLower(filepath.Ext(path)) == ".zbc_asm" {
+			output := strings.TrimSuffix(path, filepath.Ext(path)) + outputExt()
+			if err := assemble(path, output); err != nil {
+				fmt.Printf("Failed to assemble %s: %s\n", path, err)
+				*failed = true
+			}
+		}
+		return nil
+	}
+}
+
+func assemble(inputName, outputName string) error {
+	// file deepcode ignore PT: This is CLI tool, this is intended to be traversable
+	file, err := os.Open(inputName)
+	if err != nil {
+		return fmt.Errorf("couldn't open file %s: %s", inputName, err)
+	}
+	c, err := asm.Assemble(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("couldn't assemble %s: %s", inputName, err)
+	}
+
+	if verify {
+		if err := zbc.VerifyChunk(c); err != nil {
+			return fmt.Errorf("bytecode in %s failed verification:\n%s", inputName, err)
+		}
+	}
+
+	bytecode := bytes.Buffer{}
+	if err = chunk.Encode(&bytecode, c); err != nil {
+		return fmt.Errorf("couldn't encode bytecode %s: %s", inputName, err)
+	}
+
+	fmt.Printf("Assembling %s\n", inputName)
+
+	output := bytecode.Bytes()
+	if pack {
+		packed := bytes.Buffer{}
+		if err = zbc.Pack(&packed, output); err != nil {
+			return fmt.Errorf("couldn't pack bytecode %s: %s", inputName, err)
+		}
+		output = packed.Bytes()
+	}
+
+	err = os.WriteFile(outputName, output, 0o644)
+	if err != nil {
+		return fmt.Errorf("couldn't write output file %s: %s", outputName, err)
+	}
+	return nil
+}
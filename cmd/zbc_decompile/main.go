@@ -0,0 +1,132 @@
+/*
+zbc_decompile reconstructs Lua source from Gamewave .zbc bytecode.
+
+Code that couldn't be decompiled is kept in the output as disassembly in comments.
+*/
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc"
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+	"github.com/namgo/GameWaveFans/pkg/zbc/decompile"
+	"github.com/spf13/pflag"
+)
+
+// flags
+var (
+	outputName string
+)
+
+func parseFlags() {
+	pflag.StringVarP(&outputName, "output", "o", "", "name of the output file, - for standard output")
+	pflag.Parse()
+}
+
+func usage() {
+	fmt.Println("Decompiles .zbc bytecode used by Gamewave console to Lua source")
+	fmt.Println("Accepts packed .zbc files and unpacked bytecode, directories are searched recursively for .zbc files")
+	fmt.Println("Flags:")
+	pflag.PrintDefaults()
+}
+
+func main() {
+	failed := false
+	parseFlags()
+	args := pflag.Args()
+	if len(args) < 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	if outputName != "" && len(args) > 1 {
+		fmt.Println("Output name can only be used with one input file")
+		usage()
+		os.Exit(1)
+	}
+
+	for _, inputName := range args {
+		f, err := os.Stat(inputName)
+		if err != nil {
+			fmt.Printf("Failed to get info about %s: %s\n", inputName, err)
+			failed = true
+			continue
+		}
+		if f.IsDir() {
+			err := filepath.Walk(inputName, getWalkFunc(&failed))
+			if err != nil {
+				fmt.Printf("Failed to decompile dir %s: %s\n", inputName, err)
+				failed = true
+			}
+		} else {
+			if outputName == "" || len(args) > 1 {
+				outputName = strings.TrimSuffix(inputName, filepath.Ext(inputName)) + ".lua"
+			}
+			err := decompileFile(inputName, outputName)
+			if err != nil {
+				fmt.Printf("Failed to decompile %s: %s\n", inputName, err)
+				failed = true
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func getWalkFunc(failed *bool) filepath.WalkFunc {
+	return func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.ToLower(filepath.Ext(path)) == ".zbc" {
+			output := strings.TrimSuffix(path, filepath.Ext(path)) + ".lua"
+			if err := decompileFile(path, output); err != nil {
+				fmt.Printf("Failed to decompile %s: %s\n", path, err)
+				*failed = true
+			}
+		}
+		return nil
+	}
+}
+
+func decompileFile(inputName, outputName string) error {
+	// file deepcode ignore PT: This is CLI tool, this is intended to be traversable
+	file, err := os.Open(inputName)
+	if err != nil {
+		return fmt.Errorf("couldn't open file %s: %s", inputName, err)
+	}
+	bytecode, err := zbc.ReadBytecode(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("couldn't read bytecode from %s: %s", inputName, err)
+	}
+
+	c, err := chunk.Decode(bytes.NewReader(bytecode))
+	if err != nil {
+		return fmt.Errorf("couldn't parse bytecode from %s: %s", inputName, err)
+	}
+
+	source := bytes.Buffer{}
+	if err = decompile.Decompile(&source, c, filepath.Base(inputName)); err != nil {
+		return fmt.Errorf("couldn't decompile %s: %s", inputName, err)
+	}
+
+	if outputName == "-" {
+		_, err = os.Stdout.Write(source.Bytes())
+		return err
+	}
+
+	fmt.Printf("Decompiling %s\n", inputName)
+	err = os.WriteFile(outputName, source.Bytes(), 0o644)
+	if err != nil {
+		return fmt.Errorf("couldn't write output file %s: %s", outputName, err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+/*
+zbc_disasm prints Gamewave .zbc bytecode as a text listing.
+
+Listings can be edited and assembled back with zbc_asm.
+*/
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc"
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+	"github.com/namgo/GameWaveFans/pkg/zbc/disasm"
+	"github.com/spf13/pflag"
+)
+
+// flags
+var (
+	outputName string
+)
+
+func parseFlags() {
+	pflag.StringVarP(&outputName, "output", "o", "", "name of the output file, - for standard output")
+	pflag.Parse()
+}
+
+func usage() {
+	fmt.Println("Disassembles .zbc bytecode used by Gamewave console")
+	fmt.Println("Accepts packed .zbc files and unpacked bytecode, directories are searched recursively for .zbc files")
+	fmt.Println("Flags:")
+	pflag.PrintDefaults()
+}
+
+func main() {
+	failed := false
+	parseFlags()
+	args := pflag.Args()
+	if len(args) < 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	if outputName != "" && len(args) > 1 {
+		fmt.Println("Output name can only be used with one input file")
+		usage()
+		os.Exit(1)
+	}
+
+	for _, inputName := range args {
+		f, err := os.Stat(inputName)
+		if err != nil {
+			fmt.Printf("Failed to get info about %s: %s\n", inputName, err)
+			failed = true
+			continue
+		}
+		if f.IsDir() {
+			err := filepath.Walk(inputName, getWalkFunc(&failed))
+			if err != nil {
+				fmt.Printf("Failed to disassemble dir %s: %s\n", inputName, err)
+				failed = true
+			}
+		} else {
+			if outputName == "" || len(args) > 1 {
+				outputName = strings.TrimSuffix(inputName, filepath.Ext(inputName)) + ".zbc_asm"
+			}
+			err := disassemble(inputName, outputName)
+			if err != nil {
+				fmt.Printf("Failed to disassemble %s: %s\n", inputName, err)
+				failed = true
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func getWalkFunc(failed *bool) filepath.WalkFunc {
+	return func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.ToLower(filepath.Ext(path)) == ".zbc" {
+			output := strings.TrimSuffix(path, filepath.Ext(path)) + ".zbc_asm"
+			if err := disassemble(path, output); err != nil {
+				fmt.Printf("Failed to disassemble %s: %s\n", path, err)
+				*failed = true
+			}
+		}
+		return nil
+	}
+}
+
+func disassemble(inputName, outputName string) error {
+	// file deepcode ignore PT: This is CLI tool, this is intended to be traversable
+	file, err := os.Open(inputName)
+	if err != nil {
+		return fmt.Errorf("couldn't open file %s: %s", inputName, err)
+	}
+	bytecode, err := zbc.ReadBytecode(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("couldn't read bytecode from %s: %s", inputName, err)
+	}
+
+	c, err := chunk.Decode(bytes.NewReader(bytecode))
+	if err != nil {
+		return fmt.Errorf("couldn't parse bytecode from %s: %s", inputName, err)
+	}
+
+	listing := bytes.Buffer{}
+	fmt.Fprintf(&listing, "; disassembled from %s\n", filepath.Base(inputName))
+	if err = disasm.Disassemble(&listing, c); err != nil {
+		return fmt.Errorf("couldn't disassemble %s: %s", inputName, err)
+	}
+
+	if outputName == "-" {
+		_, err = os.Stdout.Write(listing.Bytes())
+		return err
+	}
+
+	fmt.Printf("Disassembling %s\n", inputName)
+	err = os.WriteFile(outputName, listing.Bytes(), 0o644)
+	if err != nil {
+		return fmt.Errorf("couldn't write output file %s: %s", outputName, err)
+	}
+	return nil
+}
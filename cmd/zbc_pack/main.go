@@ -0,0 +1,137 @@
+/*
+zbc_pack packs Lua bytecode into Gamewave .zbc files
+*/
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/iafan/cwalk"
+	"github.com/namgo/GameWaveFans/pkg/zbc"
+	"github.com/spf13/pflag"
+)
+
+// flags
+var (
+	outputName string
+	verify     bool
+)
+
+func parseFlags() {
+	pflag.StringVarP(&outputName, "output", "o", "", "name of the output file")
+	pflag.BoolVar(&verify, "verify", false, "check bytecode before packing, and don't pack files that would crash the console")
+	pflag.Parse()
+}
+
+func usage() {
+	fmt.Println("Packs bytecode to .zbc format used by Gamewave console")
+	fmt.Println("Directories are searched recursively for .zbc_unpacked files")
+	fmt.Println("Flags:")
+	pflag.PrintDefaults()
+}
+
+func main() {
+	failed := false
+	parseFlags()
+	args := pflag.Args()
+	if len(args) < 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	if outputName != "" && len(args) > 1 {
+		fmt.Println("Output name can only be used with one input file")
+		usage()
+		os.Exit(1)
+	}
+
+	for _, inputName := range args {
+		f, err := os.Stat(inputName)
+		if err != nil {
+			fmt.Printf("Failed to get info about %s: %s\n", inputName, err)
+			failed = true
+			continue
+		}
+		if f.IsDir() {
+			walkFunc := getWalkFunc(inputName)
+			err := cwalk.Walk(inputName, walkFunc)
+			if err != nil {
+				fmt.Printf("Failed to pack dir %s: %s\n", inputName, err)
+				failed = true
+			}
+		} else {
+			if outputName == "" || len(args) > 1 {
+				outputName = strings.TrimSuffix(inputName, filepath.Ext(inputName)) + ".zbc"
+			}
+			err := packBytecode(inputName, outputName)
+			if err != nil {
+				fmt.Printf("Failed to pack %s: %s\n", inputName, err)
+				failed = true
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func getWalkFunc(basePath string) filepath.WalkFunc {
+	return func(path string, info fs.FileInfo, _ error) error {
+		if !info.IsDir() {
+			if strings.ToLower(filepath.Ext(path)) == ".zbc_unpacked" {
+				output := filepath.Join(basePath, strings.TrimSuffix(path, filepath.Ext(path))+".zbc")
+				return packBytecode(filepath.Join(basePath, path), output)
+			}
+		}
+		return nil
+	}
+}
+
+func packBytecode(inputName, outputName string) error {
+	// file deepcode ignore PT: This is CLI tool, this is intended to be traversable
+	bytecode, err := os.ReadFile(inputName)
+	if err != nil {
+		return fmt.Errorf("couldn't read file %s: %s", inputName, err)
+	}
+
+	packed, err := zbc.IsPacked(bytes.NewReader(bytecode))
+	if err != nil {
+		return fmt.Errorf("couldn't read header of %s: %s", inputName, err)
+	}
+	if packed {
+		// file is already packed, skip it
+		fmt.Printf("Skipping  %s: is already packed\n", inputName)
+		return nil
+	}
+
+	if verify {
+		if err := zbc.Verify(bytecode); err != nil {
+			return fmt.Errorf("bytecode in %s failed verification:\n%s", inputName, err)
+		}
+	}
+
+	fmt.Printf("Packing %s\n", inputName)
+
+	outputFile, err := os.Create(outputName)
+	if err != nil {
+		return fmt.Errorf("couldn't create output file %s: %s", outputName, err)
+	}
+
+	err = zbc.Pack(outputFile, bytecode)
+	if err != nil {
+		outputFile.Close()
+		return fmt.Errorf("couldn't pack output file %s: %s", outputName, err)
+	}
+
+	err = outputFile.Close()
+	if err != nil {
+		return fmt.Errorf("couldn't close output file %s: %s", outputName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+/*
+zbc_strings lists string constants of Gamewave .zbc bytecode, exports them to PO or JSON files
+for translation, and imports translated strings back.
+*/
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc"
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+	"github.com/namgo/GameWaveFans/pkg/zbc/translate"
+	"github.com/spf13/pflag"
+)
+
+// flags
+var (
+	exportName string
+	importName string
+	outputName string
+	all        bool
+)
+
+func parseFlags() {
+	pflag.StringVarP(&exportName, "export", "e", "", "export strings to a .po or .json file, - for PO on standard output")
+	pflag.StringVarP(&importName, "import", "i", "", "import translated strings from a .po or .json file")
+	pflag.StringVarP(&outputName, "output", "o", "", "name of the output file for --import, by default the input file is overwritten")
+	pflag.BoolVarP(&all, "all", "a", false, "list and export also strings used as names by code, they are refused by --import")
+	pflag.Parse()
+}
+
+func usage() {
+	fmt.Println("Lists, exports and imports string constants of .zbc bytecode used by Gamewave console")
+	fmt.Println("Usage: zbc_strings [flags] file.zbc")
+	fmt.Println("Flags:")
+	pflag.PrintDefaults()
+}
+
+func main() {
+	parseFlags()
+	args := pflag.Args()
+	if len(args) != 1 {
+		usage()
+		os.Exit(1)
+	}
+	if exportName != "" && importName != "" {
+		fmt.Println("Strings can't be exported and imported at once")
+		usage()
+		os.Exit(1)
+	}
+
+	var err error
+	switch {
+	case importName != "":
+		if outputName == "" {
+			outputName = args[0]
+		}
+		err = importStrings(args[0], importName, outputName)
+	case exportName != "":
+		err = exportStrings(args[0], exportName)
+	default:
+		err = listStrings(args[0])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to process %s: %s\n", args[0], err)
+		os.Exit(1)
+	}
+}
+
+// readChunk returns parsed bytecode of inputName, and whether the file was packed
+func readChunk(inputName string) (*chunk.Chunk, bool, error) {
+	// file deepcode ignore PT: This is CLI tool, this is intended to be traversable
+	file, err := os.Open(inputName)
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't open file %s: %s", inputName, err)
+	}
+	defer file.Close()
+
+	packed, err := zbc.IsPacked(file)
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't read header of %s: %s", inputName, err)
+	}
+	bytecode, err := zbc.ReadBytecode(file)
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't read bytecode from %s: %s", inputName, err)
+	}
+	c, err := chunk.Decode(bytes.NewReader(bytecode))
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't parse bytecode from %s: %s", inputName, err)
+	}
+	return c, packed, nil
+}
+
+func entries(c *chunk.Chunk) []translate.Entry {
+	var result []translate.Entry
+	for _, e := range translate.List(c) {
+		if all || !e.Name {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+func listStrings(inputName string) error {
+	c, _, err := readChunk(inputName)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries(c) {
+		fmt.Printf("%s\t%s\n", e.ID, strconv.Quote(e.Text))
+	}
+	return nil
+}
+
+func exportStrings(inputName, exportName string) error {
+	c, _, err := readChunk(inputName)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if exportName != "-" {
+		file, err := os.Create(exportName)
+		if err != nil {
+			return fmt.Errorf("couldn't create file %s: %s", exportName, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if strings.ToLower(filepath.Ext(exportName)) == ".json" {
+		err = translate.WriteJSON(w, entries(c))
+	} else {
+		err = translate.WritePO(w, entries(c), filepath.Base(inputName))
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't write strings to %s: %s", exportName, err)
+	}
+	return nil
+}
+
+func importStrings(inputName, importName, outputName string) error {
+	c, packed, err := readChunk(inputName)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(importName)
+	if err != nil {
+		return fmt.Errorf("couldn't open file %s: %s", importName, err)
+	}
+	var translations []translate.Entry
+	if strings.ToLower(filepath.Ext(importName)) == ".json" {
+		translations, err = translate.ReadJSON(file)
+	} else {
+		translations, err = translate.ReadPO(file)
+	}
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("couldn't read translations from %s: %s", importName, err)
+	}
+
+	n, err := translate.Apply(c, translations)
+	if err != nil {
+		return fmt.Errorf("couldn't apply translations from %s: %s", importName, err)
+	}
+
+	bytecode := bytes.Buffer{}
+	if err = chunk.Encode(&bytecode, c); err != nil {
+		return fmt.Errorf("couldn't encode bytecode: %s", err)
+	}
+	output := bytecode.Bytes()
+	if packed {
+		buf := bytes.Buffer{}
+		if err = zbc.Pack(&buf, output); err != nil {
+			return fmt.Errorf("couldn't pack bytecode: %s", err)
+		}
+		output = buf.Bytes()
+	}
+
+	fmt.Printf("Replaced %d strings in %s\n", n, inputName)
+	err = os.WriteFile(outputName, output, 0o644)
+	if err != nil {
+		return fmt.Errorf("couldn't write output file %s: %s", outputName, err)
+	}
+	return nil
+}
@@ -17,10 +17,12 @@ import (
 // flags
 var (
 	outputName string
+	verify     bool
 )
 
 func parseFlags() {
 	pflag.StringVarP(&outputName, "output", "o", "", "name of the output file")
+	pflag.BoolVar(&verify, "verify", false, "check unpacked bytecode, and report files that would crash the console")
 	pflag.Parse()
 }
 
@@ -81,8 +83,8 @@ func getWalkFunc(failed *bool) filepath.WalkFunc {
 				outputName = strings.TrimSuffix(path, filepath.Ext(path)) + ".zbc_unpacked"
 				err := unpackBytecode(path, outputName)
 				if err != nil {
-					fail := true
-					failed = &fail
+					fmt.Printf("Failed to unpack %s: %s\n", path, err)
+					*failed = true
 				}
 			}
 		}
@@ -133,5 +135,12 @@ func unpackBytecode(inputName, outputName string) error {
 		return fmt.Errorf("couldn't close output file %s: %s", outputName, err)
 	}
 
+	if verify {
+		// the unpacked file is still written, so it can be inspected and fixed
+		if err := zbc.Verify(unpacked); err != nil {
+			return fmt.Errorf("bytecode in %s failed verification:\n%s", inputName, err)
+		}
+	}
+
 	return nil
 }
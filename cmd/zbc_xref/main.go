@@ -0,0 +1,141 @@
+/*
+zbc_xref lists strings, globals and call sites of Gamewave .zbc bytecode, and aggregates them
+across whole directories into a JSON or CSV report.
+*/
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc"
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+	"github.com/namgo/GameWaveFans/pkg/zbc/xref"
+	"github.com/spf13/pflag"
+)
+
+// flags
+var (
+	outputName string
+	format     string
+)
+
+func parseFlags() {
+	pflag.StringVarP(&outputName, "output", "o", "-", "name of the output file, - for standard output")
+	pflag.StringVarP(&format, "format", "f", "json", "report format, json or csv")
+	pflag.Parse()
+}
+
+func usage() {
+	fmt.Println("Lists strings, globals and called functions of .zbc bytecode used by Gamewave console")
+	fmt.Println("Accepts packed .zbc files and unpacked bytecode, directories are searched recursively for .zbc files")
+	fmt.Println("Flags:")
+	pflag.PrintDefaults()
+}
+
+func main() {
+	failed := false
+	parseFlags()
+	args := pflag.Args()
+	if len(args) < 1 {
+		usage()
+		os.Exit(1)
+	}
+	if format != "json" && format != "csv" {
+		fmt.Printf("Unknown format %s\n", format)
+		usage()
+		os.Exit(1)
+	}
+
+	var indexes []*xref.Index
+	for _, inputName := range args {
+		f, err := os.Stat(inputName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get info about %s: %s\n", inputName, err)
+			failed = true
+			continue
+		}
+		if f.IsDir() {
+			err := filepath.Walk(inputName, getWalkFunc(&indexes, &failed))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to index dir %s: %s\n", inputName, err)
+				failed = true
+			}
+		} else {
+			idx, err := indexFile(inputName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to index %s: %s\n", inputName, err)
+				failed = true
+				continue
+			}
+			indexes = append(indexes, idx)
+		}
+	}
+
+	if err := writeReport(xref.NewReport(indexes)); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write report: %s\n", err)
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func getWalkFunc(indexes *[]*xref.Index, failed *bool) filepath.WalkFunc {
+	return func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.ToLower(filepath.Ext(path)) == ".zbc" {
+			idx, err := indexFile(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to index %s: %s\n", path, err)
+				*failed = true
+				return nil
+			}
+			*indexes = append(*indexes, idx)
+		}
+		return nil
+	}
+}
+
+func indexFile(inputName string) (*xref.Index, error) {
+	// file deepcode ignore PT: This is CLI tool, this is intended to be traversable
+	file, err := os.Open(inputName)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open file %s: %s", inputName, err)
+	}
+	bytecode, err := zbc.ReadBytecode(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read bytecode from %s: %s", inputName, err)
+	}
+
+	c, err := chunk.Decode(bytes.NewReader(bytecode))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse bytecode from %s: %s", inputName, err)
+	}
+	return xref.Build(c, filepath.ToSlash(inputName)), nil
+}
+
+func writeReport(report *xref.Report) error {
+	var w io.Writer = os.Stdout
+	if outputName != "-" {
+		file, err := os.Create(outputName)
+		if err != nil {
+			return fmt.Errorf("couldn't create output file %s: %s", outputName, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if format == "csv" {
+		return report.WriteCSV(w)
+	}
+	return report.WriteJSON(w)
+}
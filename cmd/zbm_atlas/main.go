@@ -0,0 +1,213 @@
+/*
+zbm_atlas packs a directory of images into Gamewave .zbm texture atlases of legal sizes,
+and writes a JSON or Lua manifest with positions of the images in them.
+*/
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/namgo/GameWaveFans/pkg/zbm"
+	"github.com/spf13/pflag"
+)
+
+// options of the encoder and of the packer, set by flags
+var (
+	options      zbm.Options
+	atlasOptions zbm.AtlasOptions
+)
+
+// flags
+var (
+	outputName string
+	manifest   string
+	quantizer  string
+	alphaMode  string
+	widths     []int
+	heights    []int
+)
+
+func parseFlags() {
+	pflag.StringVarP(&outputName, "output", "o", "", "base name of the output files, name of the input directory by default")
+	pflag.StringVarP(&manifest, "manifest", "m", "json", "manifest format, json or lua")
+	pflag.IntVar(&atlasOptions.MaxWidth, "max-width", 0, "largest width of atlases, the largest legal width by default")
+	pflag.IntVar(&atlasOptions.MaxHeight, "max-height", 0, "largest height of atlases, the largest legal height by default")
+	pflag.IntVar(&atlasOptions.Spacing, "spacing", 1, "number of transparent pixels between images")
+	pflag.StringVarP(&quantizer, "quantizer", "q", "truncate", "how colors are converted, one of: "+strings.Join(zbm.QuantizerNames(), ", "))
+	pflag.StringVar(&alphaMode, "alpha", "full", "how alpha is stored, one of: "+strings.Join(zbm.AlphaModeNames(), ", "))
+	pflag.Uint8Var(&options.AlphaThreshold, "alpha-threshold", zbm.DefaultAlphaThreshold, "lowest alpha of opaque pixels in key alpha mode")
+	pflag.IntSliceVar(&widths, "widths", nil, "legal widths of textures, multiples of 8 up to 1024 by default")
+	pflag.IntSliceVar(&heights, "heights", nil, "legal heights of textures, up to 1024 by default")
+	pflag.Parse()
+}
+
+func usage() {
+	fmt.Println("Packs images of a directory into .zbm texture atlases used by Gamewave console")
+	fmt.Println("Atlases are written to <output>_<n>.zbm, and positions of images to <output>.json or <output>.lua")
+	fmt.Println("Flags:")
+	pflag.PrintDefaults()
+}
+
+func main() {
+	parseFlags()
+	args := pflag.Args()
+	if len(args) != 1 {
+		usage()
+		os.Exit(1)
+	}
+	if err := parseOptions(); err != nil {
+		fmt.Println(err)
+		usage()
+		os.Exit(1)
+	}
+
+	inputName := filepath.Clean(args[0])
+	if outputName == "" {
+		outputName = inputName
+	}
+	if err := packAtlases(inputName, outputName); err != nil {
+		fmt.Printf("Failed to pack atlases of %s: %s\n", inputName, err)
+		os.Exit(1)
+	}
+}
+
+// parseOptions checks values of flags, and sets options of the encoder and of the packer
+func parseOptions() error {
+	if manifest != "json" && manifest != "lua" {
+		return fmt.Errorf("unknown manifest format %s", manifest)
+	}
+	var err error
+	if options.Quantizer, err = zbm.ParseQuantizer(quantizer); err != nil {
+		return err
+	}
+	if options.Alpha, err = zbm.ParseAlphaMode(alphaMode); err != nil {
+		return err
+	}
+
+	atlasOptions.Sizes = zbm.DefaultSizeTable()
+	if len(widths) == 0 && len(heights) == 0 {
+		return nil
+	}
+	if len(widths) == 0 {
+		widths = atlasOptions.Sizes.Widths
+	}
+	if len(heights) == 0 {
+		heights = atlasOptions.Sizes.Heights
+	}
+	atlasOptions.Sizes, err = zbm.NewSizeTable(widths, heights)
+	return err
+}
+
+func packAtlases(inputName, outputName string) error {
+	paths, err := imageFiles(inputName)
+	if err != nil {
+		return fmt.Errorf("couldn't list images: %s", err)
+	}
+
+	sprites := make([]zbm.Sprite, 0, len(paths))
+	for _, path := range paths {
+		img, err := readImage(path)
+		if err != nil {
+			return err
+		}
+		// images are named by their paths, so images of different directories don't collide
+		name, err := filepath.Rel(inputName, path)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(strings.TrimSuffix(name, filepath.Ext(name)))
+		sprites = append(sprites, zbm.Sprite{Name: name, Image: img})
+	}
+
+	atlases, err := zbm.PackAtlases(sprites, atlasOptions)
+	if err != nil {
+		return err
+	}
+
+	files := make([]string, len(atlases))
+	for i, atlas := range atlases {
+		name := fmt.Sprintf("%s_%d.zbm", outputName, i)
+		files[i] = filepath.Base(name)
+		b := atlas.Image.Bounds()
+		fmt.Printf("Packing %d images to %s: %dx%d\n", len(atlas.Placements), name, b.Dx(), b.Dy())
+		if err = writeTexture(name, atlas.Image); err != nil {
+			return err
+		}
+	}
+
+	name := outputName + "." + manifest
+	file, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("couldn't create manifest file %s: %s", name, err)
+	}
+	m := zbm.NewManifest(atlases, files)
+	if manifest == "lua" {
+		err = m.WriteLua(file)
+	} else {
+		err = m.WriteJSON(file)
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("couldn't write manifest file %s: %s", name, err)
+	}
+	return file.Close()
+}
+
+// imageFiles returns paths of images under directory root, in lexical order, so atlases are
+// the same every time. Textures and files of unknown formats are skipped
+func imageFiles(root string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		// file deepcode ignore PT: This is CLI tool, this is intended to be traversable
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, format, err := image.DecodeConfig(file); err == nil && format != zbm.FormatName {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+func readImage(inputName string) (image.Image, error) {
+	// file deepcode ignore PT: This is CLI tool, this is intended to be traversable
+	file, err := os.Open(inputName)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open file %s: %s", inputName, err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read image file %s: %s", inputName, err)
+	}
+	return img, nil
+}
+
+func writeTexture(outputName string, img image.Image) error {
+	file, err := os.Create(outputName)
+	if err != nil {
+		return fmt.Errorf("couldn't create output image file %s: %s", outputName, err)
+	}
+	if err = zbm.EncodeOptions(file, img, &options); err != nil {
+		file.Close()
+		return fmt.Errorf("couldn't pack output image %s: %s", outputName, err)
+	}
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("couldn't close output image file %s: %s", outputName, err)
+	}
+	return nil
+}
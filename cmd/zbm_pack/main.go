@@ -4,27 +4,66 @@ zbm_pack converts popular image formats to Gamewave .zbm images.
 package main
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/iafan/cwalk"
+	"github.com/namgo/GameWaveFans/pkg/common"
+	"github.com/namgo/GameWaveFans/pkg/deflate"
 	"github.com/namgo/GameWaveFans/pkg/zbm"
 	"github.com/spf13/pflag"
 )
 
+// options of the encoder, set by flags
+var options zbm.Options
+
+// legal sizes of textures, and filter used to resample images to them, set by flags
+var (
+	sizes          zbm.SizeTable
+	resampleFilter zbm.Filter
+)
+
+// fitModes are values of fit flag
+var fitModes = []string{"keep", "reject", "pad", "resample"}
+
+// compression of packed data, set by flags
+var compression = common.DefaultCompression
+
 // flags
 var (
 	outputName string
+	baseName   string
+	quantizer  string
+	alphaMode  string
+	fit        string
+	filter     string
+	widths     []int
+	heights    []int
 )
 
 func parseFlags() {
 	pflag.StringVarP(&outputName, "output", "o", "", "name of the output file")
+	pflag.StringVarP(&quantizer, "quantizer", "q", "truncate", "how colors are converted, one of: "+strings.Join(zbm.QuantizerNames(), ", "))
+	pflag.StringVar(&alphaMode, "alpha", "full", "how alpha is stored, one of: "+strings.Join(zbm.AlphaModeNames(), ", "))
+	pflag.Uint8Var(&options.AlphaThreshold, "alpha-threshold", zbm.DefaultAlphaThreshold, "lowest alpha of opaque pixels in key alpha mode")
+	pflag.StringVar(&fit, "fit", "keep", "what to do with images of sizes not accepted by the console, one of: "+strings.Join(fitModes, ", "))
+	pflag.StringVar(&filter, "filter", "lanczos", "filter used to resample images, one of: "+strings.Join(zbm.FilterNames(), ", "))
+	pflag.IntSliceVar(&widths, "widths", nil, "legal widths of textures, multiples of 8 up to 1024 by default")
+	pflag.IntSliceVar(&heights, "heights", nil, "legal heights of textures, up to 1024 by default")
+	pflag.StringVarP(&baseName, "base", "b", "", "original .zbm texture, its header and pixels with unchanged colors are kept, its smaller levels are resampled with filter when colors change")
+	pflag.IntVarP(&compression.Level, "level", "l", compression.Level, "zlib compression level, from 0 for no compression to 9 for the best one")
+	pflag.IntVarP(&compression.Iterations, "exhaustive", "x", 0, "iterations of exhaustive deflate, which is slow, but packs data better than the best level; give a number with =, like -x=30 or --exhaustive=30")
+	pflag.Lookup("exhaustive").NoOptDefVal = strconv.Itoa(deflate.DefaultIterations)
 	pflag.Parse()
 }
 
@@ -48,6 +87,37 @@ func main() {
 		usage()
 		os.Exit(1)
 	}
+	if err := compression.Check(); err != nil {
+		fmt.Println(err)
+		usage()
+		os.Exit(1)
+	}
+	q, err := zbm.ParseQuantizer(quantizer)
+	if err != nil {
+		fmt.Println(err)
+		usage()
+		os.Exit(1)
+	}
+	options.Quantizer = q
+	options.Compression = &compression
+	options.Alpha, err = zbm.ParseAlphaMode(alphaMode)
+	if err != nil {
+		fmt.Println(err)
+		usage()
+		os.Exit(1)
+	}
+
+	if err = parseSizeFlags(); err != nil {
+		fmt.Println(err)
+		usage()
+		os.Exit(1)
+	}
+
+	if baseName != "" && len(args) > 1 {
+		fmt.Println("Base texture can only be used with one input file")
+		usage()
+		os.Exit(1)
+	}
 
 	for _, inputName := range args {
 		f, err := os.Stat(inputName)
@@ -56,13 +126,8 @@ func main() {
 			failed = true
 		}
 		if f.IsDir() {
-			walkFunc := getWalkFunc(inputName)
-			err := cwalk.Walk(inputName, walkFunc)
-			if err != nil {
-				fmt.Printf("Failed to unpack dir %s: %s\n", inputName, err)
-				// for _, errors := range err.(cwalk.WalkerError).ErrorList {
-				// 	fmt.Println(errors)
-				// }
+			if err := cwalk.Walk(inputName, getWalkFunc(inputName)); err != nil {
+				fmt.Printf("Failed to pack dir %s: %s\n", inputName, err)
 				failed = true
 			}
 		} else {
@@ -81,31 +146,29 @@ func main() {
 	}
 }
 
+// getWalkFunc returns function packing images found in basePath, textures are written next to them.
+// Files of unknown formats and textures are skipped, errors of other files are collected by cwalk
 func getWalkFunc(basePath string) filepath.WalkFunc {
-	return func(path string, info fs.FileInfo, _ error) error {
-		if !info.IsDir() {
-			// check if file is an image
-			// file deepcode ignore PT: This is CLI tool, this is intended to be traversable
-			file, err := os.Open(filepath.Join(basePath, path))
-			if err != nil {
-				return err
-			}
-			_, format, err := image.DecodeConfig(file)
-			if err != nil {
-				return err
-			}
-
-			err = file.Close()
-			if err != nil {
-				return err
-			}
-
-			if err != nil && format != zbm.FormatName {
-				outputName = filepath.Join(basePath, strings.TrimSuffix(path, filepath.Ext(path))+".zbm")
-				return packTexture(filepath.Join(basePath, path), outputName)
-			}
+	return func(path string, info fs.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
 		}
-		return nil
+		inputName := filepath.Join(basePath, path)
+		// check if file is an image
+		// file deepcode ignore PT: This is CLI tool, this is intended to be traversable
+		file, err := os.Open(inputName)
+		if err != nil {
+			return err
+		}
+		_, format, err := image.DecodeConfig(file)
+		file.Close()
+		if errors.Is(err, image.ErrFormat) || format == zbm.FormatName {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("couldn't read image file config %s: %s", inputName, err)
+		}
+		return packTexture(inputName, strings.TrimSuffix(inputName, filepath.Ext(inputName))+".zbm")
 	}
 }
 
@@ -137,12 +200,21 @@ func packTexture(inputName, outputName string) error {
 		return fmt.Errorf("couldn't close image file %s: %s", inputName, err)
 	}
 
+	img, err = fitImage(img)
+	if err != nil {
+		return fmt.Errorf("couldn't fit image %s: %s", inputName, err)
+	}
+
 	outputFile, err := os.Create(outputName)
 	if err != nil {
 		return fmt.Errorf("couldn't create output image file %s: %s", outputName, err)
 	}
 
-	err = zbm.Encode(outputFile, img)
+	if baseName != "" {
+		err = packOverBase(outputFile, img)
+	} else {
+		err = zbm.EncodeOptions(outputFile, img, &options)
+	}
 	if err != nil {
 		return fmt.Errorf("couldn't pack output image %s: %s", outputName, err)
 	}
@@ -154,3 +226,84 @@ func packTexture(inputName, outputName string) error {
 
 	return nil
 }
+
+// parseSizeFlags sets table of legal sizes and resampling filter
+func parseSizeFlags() error {
+	if !slices.Contains(fitModes, fit) {
+		return fmt.Errorf("unknown fit mode %q, expected one of: %s", fit, strings.Join(fitModes, ", "))
+	}
+	var err error
+	resampleFilter, err = zbm.ParseFilter(filter)
+	if err != nil {
+		return err
+	}
+
+	sizes = zbm.DefaultSizeTable()
+	if len(widths) == 0 && len(heights) == 0 {
+		return nil
+	}
+	if len(widths) == 0 {
+		widths = sizes.Widths
+	}
+	if len(heights) == 0 {
+		heights = sizes.Heights
+	}
+	sizes, err = zbm.NewSizeTable(widths, heights)
+	return err
+}
+
+// fitImage checks size of img, and pads or resamples it to a legal size, as selected by fit flag
+func fitImage(img image.Image) (image.Image, error) {
+	b := img.Bounds()
+	if fit == "keep" || sizes.Legal(b.Dx(), b.Dy()) {
+		return img, nil
+	}
+	switch fit {
+	case "pad":
+		width, height, err := sizes.Padded(b.Dx(), b.Dy())
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Padding to %dx%d\n", width, height)
+		return zbm.Pad(img, width, height), nil
+	case "resample":
+		width, height := sizes.Nearest(b.Dx(), b.Dy())
+		fmt.Printf("Resampling to %dx%d\n", width, height)
+		return zbm.Resample(img, width, height, resampleFilter), nil
+	default:
+		return nil, sizes.Check(b.Dx(), b.Dy())
+	}
+}
+
+// packOverBase writes img using header and unchanged pixels of the base texture
+func packOverBase(w io.Writer, img image.Image) error {
+	// file deepcode ignore PT: This is CLI tool, this is intended to be traversable
+	file, err := os.Open(baseName)
+	if err != nil {
+		return fmt.Errorf("couldn't open base texture %s: %s", baseName, err)
+	}
+	defer file.Close()
+
+	h, levels, err := zbm.DecodeRawLevels(file)
+	if err != nil {
+		return fmt.Errorf("couldn't read base texture %s: %s", baseName, err)
+	}
+	changed, err := zbm.UpdatePixels(levels[0], int(h.Width), img, &options)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Changed %d of %d pixels of %s\n", changed, len(levels[0]), baseName)
+
+	// smaller levels are mip levels of the full size one, they would show the old image
+	if changed > 0 && len(levels) > 1 {
+		for level := 1; level < len(levels); level++ {
+			width, height := h.LevelSize(level)
+			m := zbm.Resample(img, width, height, resampleFilter)
+			if _, err = zbm.UpdatePixels(levels[level], width, m, &options); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Resampled %d smaller levels of %s\n", len(levels)-1, baseName)
+	}
+	return zbm.EncodeRawLevels(w, h, levels, &options)
+}
@@ -1,37 +1,64 @@
 /*
 zbm_unpack converts Gamewave .zbm images to one of the more popular formats.
 
-This program can output jpg or png files.
+This program can output png, jpg, tga, bmp or qoi files, grayscale images of Y, Cb, Cr and alpha
+planes, or bin files with unpacked pixel data, as stored in textures.
 */
 package main
 
 import (
+	"bufio"
+	"encoding/binary"
 	"fmt"
 	"image"
 	"image/jpeg"
 	"image/png"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/iafan/cwalk"
+	"github.com/namgo/GameWaveFans/pkg/bmp"
+	"github.com/namgo/GameWaveFans/pkg/qoi"
+	"github.com/namgo/GameWaveFans/pkg/tga"
 	"github.com/namgo/GameWaveFans/pkg/zbm"
 	"github.com/spf13/pflag"
 )
 
+// formats are extensions of supported output files
+var formats = []string{"png", "jpg", "tga", "bmp", "qoi", "bin"}
+
+// options of the decoder, set by flags
+var options zbm.Options
+
 // flags
 var (
 	outputName string
+	info       bool
+	allLevels  bool
+	alphaMode  string
+	format     string
+	planes     bool
 )
 
 func parseFlags() {
 	pflag.StringVarP(&outputName, "output", "o", "", "name of the output file")
+	pflag.BoolVarP(&info, "info", "i", false, "print headers of textures instead of unpacking them")
+	pflag.BoolVarP(&allLevels, "all-levels", "a", false, "unpack all levels of textures, level n is written to name_n file")
+	pflag.StringVarP(&format, "format", "f", "png", "format of output files, if output name isn't given, one of: "+strings.Join(formats, ", "))
+	pflag.BoolVarP(&planes, "planes", "p", false, "write Y, Cb, Cr and alpha of pixels as grayscale images, plane P is written to name_P file")
+	pflag.StringVar(&alphaMode, "alpha", "full", "how alpha of textures is read, one of: "+strings.Join(zbm.AlphaModeNames(), ", "))
+	pflag.Uint8Var(&options.AlphaThreshold, "alpha-threshold", zbm.DefaultAlphaThreshold, "lowest alpha of opaque pixels in key alpha mode")
 	pflag.Parse()
 }
 
 func usage() {
 	fmt.Println("Unpacks image from .zbm texture format used by Gamewave console")
+	fmt.Println("Format is chosen by extension of the output name, bin files hold unpacked pixel data as stored in textures:")
+	fmt.Println("big endian 32-bit words with the first pixel of every pair in the low half, and the last pixel of odd count alone")
 	fmt.Println("Flags:")
 	pflag.PrintDefaults()
 }
@@ -50,8 +77,18 @@ func main() {
 		usage()
 		os.Exit(1)
 	}
-
-	zbm.RegisterFormat()
+	mode, err := zbm.ParseAlphaMode(alphaMode)
+	if err != nil {
+		fmt.Println(err)
+		usage()
+		os.Exit(1)
+	}
+	options.Alpha = mode
+	if !slices.Contains(formats, format) {
+		fmt.Printf("unknown format %q, expected one of: %s\n", format, strings.Join(formats, ", "))
+		usage()
+		os.Exit(1)
+	}
 
 	for _, inputName := range args {
 		f, err := os.Stat(inputName)
@@ -71,7 +108,7 @@ func main() {
 			}
 		} else {
 			if outputName == "" || len(args) > 1 {
-				outputName = strings.TrimSuffix(inputName, filepath.Ext(inputName)) + ".png"
+				outputName = strings.TrimSuffix(inputName, filepath.Ext(inputName)) + "." + format
 			}
 			err := unpackTexture(inputName, outputName)
 			if err != nil {
@@ -89,7 +126,7 @@ func getWalkFunc(basePath string) filepath.WalkFunc {
 	return func(path string, info fs.FileInfo, _ error) error {
 		if !info.IsDir() {
 			if strings.ToLower(filepath.Ext(path)) == ".zbm" {
-				outputName = filepath.Join(basePath, strings.TrimSuffix(path, filepath.Ext(path))+".png")
+				outputName = filepath.Join(basePath, strings.TrimSuffix(path, filepath.Ext(path))+"."+format)
 				return unpackTexture(filepath.Join(basePath, path), outputName)
 			}
 		}
@@ -104,6 +141,11 @@ func unpackTexture(inputName, outputName string) error {
 		return fmt.Errorf("couldn't open file %s: %s", inputName, err)
 	}
 
+	if info {
+		defer file.Close()
+		return printHeader(inputName, file)
+	}
+
 	config, format, err := image.DecodeConfig(file)
 	if err != nil {
 		return fmt.Errorf("couldn't read image file config %s: %s", inputName, err)
@@ -119,8 +161,9 @@ func unpackTexture(inputName, outputName string) error {
 		return fmt.Errorf("couldn't seek in image file %s: %s", inputName, err)
 	}
 
-	img, _, err := image.Decode(file)
+	outputs, err := readOutputs(file, outputName)
 	if err != nil {
+		file.Close()
 		return fmt.Errorf("couldn't read image file %s: %s", inputName, err)
 	}
 
@@ -129,6 +172,103 @@ func unpackTexture(inputName, outputName string) error {
 		return fmt.Errorf("couldn't close image file %s: %s", inputName, err)
 	}
 
+	for _, o := range outputs {
+		if o.img != nil {
+			err = writeImage(o.name, o.img)
+		} else {
+			err = writeWords(o.name, o.words)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// output is an image or words of pixels written to a file
+type output struct {
+	name  string
+	img   image.Image
+	words []uint16
+}
+
+// outputFileName returns name of the file with the given level and plane of a texture,
+// level 0 and no plane is written to outputName
+func outputFileName(outputName string, level int, plane string) string {
+	ext := filepath.Ext(outputName)
+	name := strings.TrimSuffix(outputName, ext)
+	if level > 0 {
+		name = fmt.Sprintf("%s_%d", name, level)
+	}
+	if plane != "" {
+		name += "_" + plane
+	}
+	return name + ext
+}
+
+// readOutputs decodes the texture, and returns files written for its first level, or all levels
+func readOutputs(r io.Reader, outputName string) ([]output, error) {
+	var outputs []output
+	if strings.ToLower(filepath.Ext(outputName)) == ".bin" {
+		if planes {
+			return nil, fmt.Errorf("planes can't be written to bin files")
+		}
+		_, levels, err := zbm.DecodeRawLevels(r)
+		if err != nil {
+			return nil, err
+		}
+		if !allLevels {
+			levels = levels[:1]
+		}
+		for level, words := range levels {
+			outputs = append(outputs, output{name: outputFileName(outputName, level, ""), words: words})
+		}
+		return outputs, nil
+	}
+
+	if planes {
+		var levels []*zbm.Planes
+		if allLevels {
+			var err error
+			if levels, err = zbm.DecodeTexturePlanes(r); err != nil {
+				return nil, err
+			}
+		} else {
+			p, err := zbm.DecodePlanes(r)
+			if err != nil {
+				return nil, err
+			}
+			levels = []*zbm.Planes{p}
+		}
+		for level, p := range levels {
+			for i, img := range p.Images() {
+				outputs = append(outputs, output{name: outputFileName(outputName, level, zbm.PlaneNames()[i]), img: img})
+			}
+		}
+		return outputs, nil
+	}
+
+	var levels []image.Image
+	if allLevels {
+		texture, err := zbm.DecodeTexture(r, &options)
+		if err != nil {
+			return nil, err
+		}
+		levels = texture.Levels
+	} else {
+		img, err := zbm.DecodeOptions(r, &options)
+		if err != nil {
+			return nil, err
+		}
+		levels = []image.Image{img}
+	}
+	for level, img := range levels {
+		outputs = append(outputs, output{name: outputFileName(outputName, level, ""), img: img})
+	}
+	return outputs, nil
+}
+
+func writeImage(outputName string, img image.Image) error {
 	outputFile, err := os.Create(outputName)
 	if err != nil {
 		return fmt.Errorf("couldn't create output image file %s: %s", outputName, err)
@@ -143,6 +283,12 @@ func unpackTexture(inputName, outputName string) error {
 		err = jpeg.Encode(outputFile, img, &o)
 	case ".png":
 		err = png.Encode(outputFile, img)
+	case ".tga":
+		err = tga.Encode(outputFile, img)
+	case ".bmp":
+		err = bmp.Encode(outputFile, img)
+	case ".qoi":
+		err = qoi.Encode(outputFile, img)
 	default:
 		err = fmt.Errorf("unknown output format: %s", ext)
 	}
@@ -158,3 +304,42 @@ func unpackTexture(inputName, outputName string) error {
 	}
 	return nil
 }
+
+// writeWords writes pixels of a level packed into 32-bit words, the way textures store them
+func writeWords(outputName string, words []uint16) error {
+	outputFile, err := os.Create(outputName)
+	if err != nil {
+		return fmt.Errorf("couldn't create output file %s: %s", outputName, err)
+	}
+	w := bufio.NewWriter(outputFile)
+	for i := 0; i < len(words) && err == nil; i += 2 {
+		word := uint32(words[i])
+		if i+1 < len(words) {
+			word |= uint32(words[i+1]) << 16
+		}
+		err = binary.Write(w, binary.BigEndian, word)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		outputFile.Close()
+		return fmt.Errorf("couldn't write output file %s: %s", outputName, err)
+	}
+	err = outputFile.Close()
+	if err != nil {
+		return fmt.Errorf("couldn't close output file %s: %s", outputName, err)
+	}
+	return nil
+}
+
+func printHeader(inputName string, r io.Reader) error {
+	h, err := zbm.ReadHeader(r)
+	if err != nil {
+		return fmt.Errorf("couldn't read header of %s: %s", inputName, err)
+	}
+	fmt.Printf("%s: %dx%d %s, %d bytes per pixel, type %d, OSD %d, levels %d, packed %d bytes, unpacked %d bytes, unknown %d %d %d\n",
+		inputName, h.Width, h.Height, h.FormatID, h.BytesPerPixel, h.Type, h.OSD, h.Levels,
+		h.SizePacked, h.SizeUnpacked, h.Unknown5, h.Unknown6, h.Unknown8)
+	return nil
+}
@@ -8,15 +8,21 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/go-audio/transforms"
 	"github.com/go-audio/wav"
 	"github.com/iafan/cwalk"
+	"github.com/namgo/GameWaveFans/pkg/common"
+	"github.com/namgo/GameWaveFans/pkg/deflate"
 	"github.com/namgo/GameWaveFans/pkg/zwf"
 	"github.com/spf13/pflag"
 )
 
+// compression of packed data, set by flags
+var compression = common.DefaultCompression
+
 // flags
 var (
 	outputName string
@@ -24,6 +30,9 @@ var (
 
 func parseFlags() {
 	pflag.StringVarP(&outputName, "output", "o", "", "name of the output file")
+	pflag.IntVarP(&compression.Level, "level", "l", compression.Level, "zlib compression level, from 0 for no compression to 9 for the best one")
+	pflag.IntVarP(&compression.Iterations, "exhaustive", "x", 0, "iterations of exhaustive deflate, which is slow, but packs data better than the best level; give a number with =, like -x=30 or --exhaustive=30")
+	pflag.Lookup("exhaustive").NoOptDefVal = strconv.Itoa(deflate.DefaultIterations)
 	pflag.Parse()
 }
 
@@ -49,6 +58,11 @@ func main() {
 		usage()
 		os.Exit(1)
 	}
+	if err := compression.Check(); err != nil {
+		fmt.Println(err)
+		usage()
+		os.Exit(1)
+	}
 
 	for _, inputName := range args {
 		f, err := os.Stat(inputName)
@@ -144,7 +158,7 @@ func packSound(inputName, outputName string) error {
 		return fmt.Errorf("couldn't create output zwf file %s: %s", outputName, err)
 	}
 
-	err = zwf.Encode(outputFile, buffer)
+	err = zwf.EncodeCompression(outputFile, buffer, compression)
 	if err != nil {
 		return fmt.Errorf("couldn't pack output image %s: %s", outputName, err)
 	}
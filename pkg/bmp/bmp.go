@@ -0,0 +1,93 @@
+// Package bmp encodes images as uncompressed Windows BMP files. Grayscale images are stored
+// with 8-bit indexes into a gray palette, other images as 32-bit BGRA with a V4 header,
+// so that readers don't ignore their alpha
+package bmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+)
+
+const (
+	fileHeaderSize = 14
+	infoHeaderSize = 40
+	v4HeaderSize   = 108
+	paletteSize    = 256 * 4
+	// compression values
+	compressionRGB       = 0
+	compressionBitfields = 3
+	// resolution of 72 DPI, in pixels per meter
+	resolution = 2835
+	// colorSpaceSRGB is the "sRGB" tag of the V4 header
+	colorSpaceSRGB = 0x73524742
+)
+
+// Encode writes m to w in BMP format
+func Encode(w io.Writer, m image.Image) error {
+	b := m.Bounds()
+	gray, isGray := m.(*image.Gray)
+
+	headerSize, bits, compression, colors := v4HeaderSize, 32, compressionBitfields, 0
+	if isGray {
+		headerSize, bits, compression, colors = infoHeaderSize, 8, compressionRGB, 256
+	}
+	// rows are padded to whole 4-byte words
+	stride := (b.Dx()*bits/8 + 3) &^ 3
+	offset := fileHeaderSize + headerSize + colors*4
+	imageSize := stride * b.Dy()
+
+	header := make([]byte, offset)
+	copy(header, "BM")
+	binary.LittleEndian.PutUint32(header[2:], uint32(offset+imageSize))
+	binary.LittleEndian.PutUint32(header[10:], uint32(offset))
+	info := header[fileHeaderSize:]
+	binary.LittleEndian.PutUint32(info[0:], uint32(headerSize))
+	binary.LittleEndian.PutUint32(info[4:], uint32(b.Dx()))
+	// negative height means rows are stored from the top
+	binary.LittleEndian.PutUint32(info[8:], uint32(-b.Dy()))
+	binary.LittleEndian.PutUint16(info[12:], 1)
+	binary.LittleEndian.PutUint16(info[14:], uint16(bits))
+	binary.LittleEndian.PutUint32(info[16:], uint32(compression))
+	binary.LittleEndian.PutUint32(info[20:], uint32(imageSize))
+	binary.LittleEndian.PutUint32(info[24:], resolution)
+	binary.LittleEndian.PutUint32(info[28:], resolution)
+	binary.LittleEndian.PutUint32(info[32:], uint32(colors))
+	if isGray {
+		palette := info[infoHeaderSize:]
+		for i := 0; i < 256; i++ {
+			palette[4*i], palette[4*i+1], palette[4*i+2] = uint8(i), uint8(i), uint8(i)
+		}
+	} else {
+		// masks of red, green, blue and alpha bits
+		binary.LittleEndian.PutUint32(info[40:], 0x00FF0000)
+		binary.LittleEndian.PutUint32(info[44:], 0x0000FF00)
+		binary.LittleEndian.PutUint32(info[48:], 0x000000FF)
+		binary.LittleEndian.PutUint32(info[52:], 0xFF000000)
+		binary.LittleEndian.PutUint32(info[56:], colorSpaceSRGB)
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+	row := make([]byte, stride)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		if isGray {
+			i := gray.PixOffset(b.Min.X, y)
+			copy(row, gray.Pix[i:i+b.Dx()])
+		} else {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+				i := 4 * (x - b.Min.X)
+				row[i], row[i+1], row[i+2], row[i+3] = c.B, c.G, c.R, c.A
+			}
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
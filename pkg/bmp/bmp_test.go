@@ -0,0 +1,51 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	nrgba.SetNRGBA(0, 0, color.NRGBA{1, 2, 3, 4})
+	nrgba.SetNRGBA(1, 0, color.NRGBA{5, 6, 7, 8})
+	gray := image.NewGray(image.Rect(0, 0, 3, 3))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i)
+	}
+	cases := []struct {
+		name                       string
+		m                          image.Image
+		offset, headerSize, bits   int
+		width, height, compression int
+		pixels                     []byte
+	}{
+		{"nrgba", nrgba, 14 + 108, 108, 32, 2, -1, 3, []byte{3, 2, 1, 4, 7, 6, 5, 8}},
+		// rows of gray images are padded to whole words
+		{"gray", gray.SubImage(image.Rect(1, 1, 3, 3)), 14 + 40 + 1024, 40, 8, 2, -2, 0, []byte{4, 5, 0, 0, 7, 8, 0, 0}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			buf := bytes.Buffer{}
+			require.NoError(t, Encode(&buf, tt.m))
+			data := buf.Bytes()
+			u32 := func(offset int) int { return int(int32(binary.LittleEndian.Uint32(data[offset:]))) }
+			require.Equal(t, "BM", string(data[:2]))
+			require.Equal(t, len(data), u32(2))
+			require.Equal(t, tt.offset, u32(10))
+			require.Equal(t, tt.headerSize, u32(14))
+			require.Equal(t, []int{tt.width, tt.height}, []int{u32(18), u32(22)})
+			require.Equal(t, tt.bits, int(binary.LittleEndian.Uint16(data[28:])))
+			require.Equal(t, tt.compression, u32(30))
+			require.Equal(t, len(tt.pixels), u32(34))
+			require.Equal(t, tt.pixels, data[tt.offset:])
+		})
+	}
+}
@@ -6,7 +6,11 @@ import (
 	"bytes"
 	"compress/zlib"
 	"encoding/binary"
+	"fmt"
+	"hash/adler32"
 	"io"
+
+	"github.com/namgo/GameWaveFans/pkg/deflate"
 )
 
 // ReadUint32 reads 4 bytes from the input as Little Endian int
@@ -77,9 +81,78 @@ func ReadZlibFromBuffer(data []byte) ([]byte, error) {
 	return ReadZlib(r)
 }
 
+// Compression selects how data is packed with Zlib
+type Compression struct {
+	// Level is a level of compress/zlib, from NoCompression to BestCompression
+	Level int
+	// Iterations of exhaustive deflate, which is much slower than the best level, but packs data better.
+	// Level is used if it's 0
+	Iterations int
+}
+
+// DefaultCompression is the compression used by WriteZlib
+var DefaultCompression = Compression{Level: zlib.BestCompression}
+
+// Check returns an error if c isn't a valid compression
+func (c Compression) Check() error {
+	if c.Level < zlib.NoCompression || c.Level > zlib.BestCompression {
+		return fmt.Errorf("unsupported compression level %d, expected %d-%d", c.Level, zlib.NoCompression, zlib.BestCompression)
+	}
+	if c.Iterations < 0 {
+		return fmt.Errorf("unsupported number of iterations %d", c.Iterations)
+	}
+	return nil
+}
+
+// exhaustiveWriter keeps all data in memory, and packs it when it's closed
+type exhaustiveWriter struct {
+	w          io.Writer
+	data       bytes.Buffer
+	iterations int
+}
+
+func (e *exhaustiveWriter) Write(p []byte) (int, error) {
+	return e.data.Write(p)
+}
+
+// Close writes the data packed with exhaustive deflate, or with the best level of compress/zlib
+// if it happens to be smaller
+func (e *exhaustiveWriter) Close() error {
+	best := bytes.Buffer{}
+	if _, err := WriteZlib(e.data.Bytes(), &best); err != nil {
+		return err
+	}
+	packed := deflate.Compress(e.data.Bytes(), e.iterations)
+	// header of 32 KiB window and the best compression, like compress/zlib writes, and adler-32 of the data
+	if 2+len(packed)+4 >= best.Len() {
+		_, err := e.w.Write(best.Bytes())
+		return err
+	}
+	stream := append([]byte{0x78, 0xda}, packed...)
+	stream = binary.BigEndian.AppendUint32(stream, adler32.Checksum(e.data.Bytes()))
+	_, err := e.w.Write(stream)
+	return err
+}
+
+// NewZlibWriter returns writer packing data with Zlib to w, all data is written when it's closed
+func NewZlibWriter(w io.Writer, c Compression) (io.WriteCloser, error) {
+	if err := c.Check(); err != nil {
+		return nil, err
+	}
+	if c.Iterations > 0 {
+		return &exhaustiveWriter{w: w, iterations: c.Iterations}, nil
+	}
+	return zlib.NewWriterLevel(w, c.Level)
+}
+
 // WriteZlib writes Zlib-packed data to a writer, and return
 func WriteZlib(data []byte, w io.Writer) (int, error) {
-	zlibEncoder, err := zlib.NewWriterLevel(w, zlib.BestCompression)
+	return WriteZlibCompression(data, w, DefaultCompression)
+}
+
+// WriteZlibCompression writes data packed with Zlib using compression c to a writer
+func WriteZlibCompression(data []byte, w io.Writer, c Compression) (int, error) {
+	zlibEncoder, err := NewZlibWriter(w, c)
 	if err != nil {
 		return 0, err
 	}
@@ -98,9 +171,14 @@ func WriteZlib(data []byte, w io.Writer) (int, error) {
 
 // WriteZlibToBuffer packs data with Zlib to a slice
 func WriteZlibToBuffer(data []byte) ([]byte, error) {
+	return WriteZlibToBufferCompression(data, DefaultCompression)
+}
+
+// WriteZlibToBufferCompression packs data with Zlib using compression c to a slice
+func WriteZlibToBufferCompression(data []byte, c Compression) ([]byte, error) {
 	buf := bytes.Buffer{}
 	w := bufio.NewWriter(&buf)
-	_, err := WriteZlib(data, w)
+	_, err := WriteZlibCompression(data, w, c)
 	if err != nil {
 		return nil, err
 	}
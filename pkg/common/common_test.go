@@ -1,6 +1,8 @@
 package common //nolint:revive
 
 import (
+	"compress/zlib"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -77,3 +79,42 @@ func TestWriteZlibToBuffer(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteZlibCompression(t *testing.T) {
+	t.Parallel()
+	data := []byte(strings.Repeat("Gamewave texture, Gamewave sound, ", 40))
+	best, err := WriteZlibToBuffer(data)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name        string
+		compression Compression
+		// expected error, or whether the stream has to be smaller than the default one
+		expectedError string
+		smaller       bool
+	}{
+		{name: "no compression", compression: Compression{Level: zlib.NoCompression}},
+		{name: "fastest", compression: Compression{Level: zlib.BestSpeed}},
+		{name: "exhaustive", compression: Compression{Iterations: 3}, smaller: true},
+		{name: "wrong level", compression: Compression{Level: 10}, expectedError: "unsupported compression level 10, expected 0-9"},
+		{name: "wrong iterations", compression: Compression{Level: 9, Iterations: -1}, expectedError: "unsupported number of iterations -1"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			packed, err := WriteZlibToBufferCompression(data, tt.compression)
+			if tt.expectedError != "" {
+				require.EqualError(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, byte(0x78), packed[0])
+			unpacked, err := ReadZlibFromBuffer(packed)
+			require.NoError(t, err)
+			require.Equal(t, data, unpacked)
+			if tt.smaller {
+				require.Less(t, len(packed), len(best))
+			}
+		})
+	}
+}
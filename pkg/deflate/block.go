@@ -0,0 +1,416 @@
+package deflate
+
+import (
+	"cmp"
+	"slices"
+)
+
+// lengthBase and lengthExtra are the smallest lengths and numbers of extra bits of length symbols
+var (
+	lengthBase  = [29]uint16{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = [29]uint8{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+)
+
+// distBase and distExtra are the smallest distances and numbers of extra bits of distance symbols
+var (
+	distBase = [30]uint16{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769,
+		1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra = [30]uint8{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+)
+
+// lengthSymbol holds indexes of length symbols of all lengths, counted from symbol 257
+var lengthSymbol = func() (symbols [maxMatch + 1]uint8) {
+	for s, base := range lengthBase {
+		for l := int(base); l <= maxMatch; l++ {
+			symbols[l] = uint8(s)
+		}
+	}
+	return symbols
+}()
+
+// distSymbol returns the distance symbol of distance d
+func distSymbol(d int) int {
+	s, found := slices.BinarySearch(distBase[:], uint16(d))
+	if !found {
+		s--
+	}
+	return s
+}
+
+// codeLengthOrder is the order of lengths of the code length code in block headers
+var codeLengthOrder = [19]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+// fixedLitLenLengths returns code lengths of the fixed literal/length code
+func fixedLitLenLengths() []uint8 {
+	lengths := make([]uint8, 288)
+	for i := range lengths {
+		switch {
+		case i < 144:
+			lengths[i] = 8
+		case i < 256:
+			lengths[i] = 9
+		case i < 280:
+			lengths[i] = 7
+		default:
+			lengths[i] = 8
+		}
+	}
+	return lengths
+}
+
+// bitWriter writes bits starting from the least significant ones, like deflate streams store them
+type bitWriter struct {
+	out   []byte
+	acc   uint64
+	count uint
+}
+
+func (w *bitWriter) bits(value uint32, count uint) {
+	w.acc |= uint64(value) << w.count
+	w.count += count
+	for w.count >= 8 {
+		w.out = append(w.out, byte(w.acc))
+		w.acc >>= 8
+		w.count -= 8
+	}
+}
+
+// code writes Huffman code, which is stored starting from the most significant bit
+func (w *bitWriter) code(code uint16, length uint8) {
+	reversed := uint32(0)
+	for i := uint8(0); i < length; i++ {
+		reversed |= uint32(code>>i&1) << (length - 1 - i)
+	}
+	w.bits(reversed, uint(length))
+}
+
+func (w *bitWriter) align() {
+	if w.count > 0 {
+		w.bits(0, 8-w.count)
+	}
+}
+
+// size returns the number of bits written
+func (w *bitWriter) size() int {
+	return 8*len(w.out) + int(w.count)
+}
+
+// codeLengths returns lengths of Huffman code of symbols with the given frequencies, not longer than limit.
+// Codes are complete, as some inflaters reject other ones, so a single used symbol gets a sibling
+func codeLengths(freqs []int, limit int) []uint8 {
+	lengths := make([]uint8, len(freqs))
+	var used []int
+	for s, f := range freqs {
+		if f > 0 {
+			used = append(used, s)
+		}
+	}
+	switch len(used) {
+	case 0:
+		return lengths
+	case 1:
+		sibling := 0
+		if used[0] == 0 {
+			sibling = 1
+		}
+		lengths[used[0]], lengths[sibling] = 1, 1
+		return lengths
+	}
+
+	// Huffman tree built with two queues, of leaves sorted by frequency, and of merged nodes
+	slices.SortStableFunc(used, func(a, b int) int { return cmp.Compare(freqs[a], freqs[b]) })
+	type node struct {
+		weight int
+		parent int
+	}
+	nodes := make([]node, 0, 2*len(used))
+	for _, s := range used {
+		nodes = append(nodes, node{weight: freqs[s], parent: -1})
+	}
+	leaf, merged := 0, len(used)
+	pop := func() int {
+		if leaf < len(used) && (merged >= len(nodes) || nodes[leaf].weight <= nodes[merged].weight) {
+			leaf++
+			return leaf - 1
+		}
+		merged++
+		return merged - 1
+	}
+	for len(nodes) < 2*len(used)-1 {
+		a, b := pop(), pop()
+		nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, parent: -1})
+		nodes[a].parent = len(nodes) - 1
+		nodes[b].parent = len(nodes) - 1
+	}
+	depths := make([]int, len(nodes))
+	for i := len(nodes) - 2; i >= 0; i-- {
+		depths[i] = depths[nodes[i].parent] + 1
+	}
+
+	// lengths over the limit are cut, and the code is fixed by lengthening the longest codes
+	// below the limit, and then shortening the longest ones while the code is incomplete
+	kraft := 0
+	for i, s := range used {
+		lengths[s] = uint8(min(depths[i], limit))
+		kraft += 1 << (limit - int(lengths[s]))
+	}
+	for kraft > 1<<limit {
+		// used are sorted by frequency, so the rarest of the longest codes is lengthened
+		longest := -1
+		for _, s := range used {
+			if int(lengths[s]) < limit && (longest < 0 || lengths[s] > lengths[longest]) {
+				longest = s
+			}
+		}
+		kraft -= 1 << (limit - int(lengths[longest]) - 1)
+		lengths[longest]++
+	}
+	for kraft < 1<<limit {
+		// the most frequent of the longest codes is shortened
+		longest := used[0]
+		for _, s := range used {
+			if lengths[s] >= lengths[longest] {
+				longest = s
+			}
+		}
+		kraft += 1 << (limit - int(lengths[longest]))
+		lengths[longest]--
+	}
+	return lengths
+}
+
+// canonicalCodes returns codes of the canonical Huffman code with given lengths
+func canonicalCodes(lengths []uint8) []uint16 {
+	var counts [16]int
+	for _, l := range lengths {
+		counts[l]++
+	}
+	counts[0] = 0
+	var next [16]uint16
+	code := uint16(0)
+	for l := 1; l < 16; l++ {
+		code = (code + uint16(counts[l-1])) << 1
+		next[l] = code
+	}
+	codes := make([]uint16, len(lengths))
+	for s, l := range lengths {
+		if l > 0 {
+			codes[s] = next[l]
+			next[l]++
+		}
+	}
+	return codes
+}
+
+// frequencies returns numbers of literal/length and distance symbols of a block, with the end of block
+func frequencies(symbols []symbol) (litLen [286]int, dist [30]int) {
+	litLen[256] = 1
+	for _, s := range symbols {
+		if s.dist == 0 {
+			litLen[s.litLen]++
+			continue
+		}
+		litLen[257+int(lengthSymbol[s.litLen])]++
+		dist[distSymbol(int(s.dist))]++
+	}
+	return litLen, dist
+}
+
+// writeSymbols writes symbols, and the end of block, with the given codes
+func writeSymbols(w *bitWriter, symbols []symbol, litLenLengths, distLengths []uint8) {
+	litLenCodes := canonicalCodes(litLenLengths)
+	distCodes := canonicalCodes(distLengths)
+	for _, s := range symbols {
+		if s.dist == 0 {
+			w.code(litLenCodes[s.litLen], litLenLengths[s.litLen])
+			continue
+		}
+		l := lengthSymbol[s.litLen]
+		w.code(litLenCodes[257+int(l)], litLenLengths[257+int(l)])
+		w.bits(uint32(s.litLen-lengthBase[l]), uint(lengthExtra[l]))
+		d := distSymbol(int(s.dist))
+		w.code(distCodes[d], distLengths[d])
+		w.bits(uint32(s.dist-distBase[d]), uint(distExtra[d]))
+	}
+	w.code(litLenCodes[256], litLenLengths[256])
+}
+
+func finalBit(final bool) uint32 {
+	if final {
+		return 1
+	}
+	return 0
+}
+
+// writeFixed writes symbols as a block using fixed Huffman codes
+func writeFixed(w *bitWriter, symbols []symbol, final bool) {
+	w.bits(finalBit(final), 1)
+	w.bits(1, 2)
+	distLengths := make([]uint8, 30)
+	for i := range distLengths {
+		distLengths[i] = 5
+	}
+	writeSymbols(w, symbols, fixedLitLenLengths(), distLengths)
+}
+
+// writeDynamic writes symbols as a block with its own Huffman codes
+func writeDynamic(w *bitWriter, symbols []symbol, final bool) {
+	litLenFreqs, distFreqs := frequencies(symbols)
+	litLenLengths := codeLengths(litLenFreqs[:], 15)
+	distLengths := codeLengths(distFreqs[:], 15)
+	if slices.Max(distLengths) == 0 {
+		// blocks without matches still need a distance code
+		distLengths[0], distLengths[1] = 1, 1
+	}
+	hlit := 257
+	for i := range litLenLengths {
+		if litLenLengths[i] > 0 {
+			hlit = max(hlit, i+1)
+		}
+	}
+	hdist := 1
+	for i := range distLengths {
+		if distLengths[i] > 0 {
+			hdist = max(hdist, i+1)
+		}
+	}
+
+	lengths := append(slices.Clone(litLenLengths[:hlit]), distLengths[:hdist]...)
+	rle := encodeLengths(lengths)
+	var codeLengthFreqs [19]int
+	for _, r := range rle {
+		codeLengthFreqs[r.symbol]++
+	}
+	codeLengthLengths := codeLengths(codeLengthFreqs[:], 7)
+	codeLengthCodes := canonicalCodes(codeLengthLengths)
+	hclen := 19
+	for hclen > 4 && codeLengthLengths[codeLengthOrder[hclen-1]] == 0 {
+		hclen--
+	}
+
+	w.bits(finalBit(final), 1)
+	w.bits(2, 2)
+	w.bits(uint32(hlit-257), 5)
+	w.bits(uint32(hdist-1), 5)
+	w.bits(uint32(hclen-4), 4)
+	for _, s := range codeLengthOrder[:hclen] {
+		w.bits(uint32(codeLengthLengths[s]), 3)
+	}
+	extraBits := map[uint8]uint{16: 2, 17: 3, 18: 7}
+	for _, r := range rle {
+		w.code(codeLengthCodes[r.symbol], codeLengthLengths[r.symbol])
+		if bits, ok := extraBits[r.symbol]; ok {
+			w.bits(uint32(r.extra), bits)
+		}
+	}
+	writeSymbols(w, symbols, litLenLengths, distLengths[:hdist])
+}
+
+// codeLength is a symbol of the code length code, with value of its extra bits
+type codeLength struct {
+	symbol, extra uint8
+}
+
+// encodeLengths returns code lengths, with runs replaced by repeat symbols
+func encodeLengths(lengths []uint8) []codeLength {
+	var result []codeLength
+	for i := 0; i < len(lengths); {
+		v := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == v {
+			run++
+		}
+		i += run
+		if v == 0 {
+			for run >= 11 {
+				r := min(run, 138)
+				result = append(result, codeLength{18, uint8(r - 11)})
+				run -= r
+			}
+			if run >= 3 {
+				result = append(result, codeLength{17, uint8(run - 3)})
+				run = 0
+			}
+		} else {
+			result = append(result, codeLength{v, 0})
+			run--
+			for run >= 3 {
+				r := min(run, 6)
+				result = append(result, codeLength{16, uint8(r - 3)})
+				run -= r
+			}
+		}
+		for ; run > 0; run-- {
+			result = append(result, codeLength{v, 0})
+		}
+	}
+	return result
+}
+
+// writeStored writes data without compression, in as many blocks as needed
+func writeStored(w *bitWriter, data []byte, final bool) {
+	for {
+		n := min(len(data), maxStored)
+		last := n == len(data)
+		w.bits(finalBit(final && last), 1)
+		w.bits(0, 2)
+		w.align()
+		w.bits(uint32(n), 16)
+		w.bits(uint32(^uint16(n)), 16)
+		w.out = append(w.out, data[:n]...)
+		data = data[n:]
+		if last {
+			return
+		}
+	}
+}
+
+// dynamicSize returns size in bits of symbols written as a block with its own Huffman codes
+func dynamicSize(symbols []symbol) int {
+	w := &bitWriter{}
+	writeDynamic(w, symbols, false)
+	return w.size()
+}
+
+// fixedSize returns size in bits of symbols written as a block using fixed Huffman codes
+func fixedSize(symbols []symbol) int {
+	w := &bitWriter{}
+	writeFixed(w, symbols, false)
+	return w.size()
+}
+
+// storedSize returns the largest size in bits of n bytes of data written as stored blocks
+func storedSize(n int) int {
+	blocks := max((n+maxStored-1)/maxStored, 1)
+	return blocks*(3+7+32) + 8*n
+}
+
+// dataSize returns the number of bytes of data described by symbols
+func dataSize(symbols []symbol) int {
+	n := 0
+	for _, s := range symbols {
+		n += s.size()
+	}
+	return n
+}
+
+// blockSize returns size in bits of symbols written as the smallest kind of block
+func blockSize(symbols []symbol) int {
+	return min(dynamicSize(symbols), fixedSize(symbols), storedSize(dataSize(symbols)))
+}
+
+// writeBlock writes symbols of data starting at pos as the smallest kind of block,
+// and returns position of the following data
+func writeBlock(w *bitWriter, data []byte, pos int, symbols []symbol, final bool) int {
+	end := pos + dataSize(symbols)
+	dynamic, fixed := dynamicSize(symbols), fixedSize(symbols)
+	switch {
+	case storedSize(end-pos) < min(dynamic, fixed):
+		writeStored(w, data[pos:end], final)
+	case fixed <= dynamic:
+		writeFixed(w, symbols, final)
+	default:
+		writeDynamic(w, symbols, final)
+	}
+	return end
+}
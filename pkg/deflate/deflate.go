@@ -0,0 +1,254 @@
+// Package deflate produces small raw deflate streams by exhaustive search, like zopfli: matches
+// are chosen by the cheapest path through the data, with costs of symbols refined by every iteration.
+// Streams use only features of RFC 1951 with the default 32 KiB window, so any inflater can read them
+package deflate
+
+import (
+	"math"
+	"slices"
+)
+
+const (
+	windowSize = 1 << 15
+	minMatch   = 3
+	maxMatch   = 258
+	// maxChain limits the number of earlier positions compared when searching for matches
+	maxChain = 1024
+	hashBits = 16
+	// chunkSize is the size of data parsed with one cost model
+	chunkSize = 1 << 20
+	// maxStored is the largest size of data in a stored block
+	maxStored = 1<<16 - 1
+)
+
+// DefaultIterations is the number of iterations which gets most of the possible gains
+const DefaultIterations = 15
+
+// match is a repetition of data at distance dist, with lengths up to length
+type match struct {
+	length, dist uint16
+}
+
+// symbol is a literal byte if dist is 0, or a match of length litLen at distance dist
+type symbol struct {
+	litLen, dist uint16
+}
+
+// size returns the number of bytes of data described by s
+func (s symbol) size() int {
+	if s.dist == 0 {
+		return 1
+	}
+	return int(s.litLen)
+}
+
+// findMatches returns matches of every position, matches of position i are
+// matches[offsets[i]:offsets[i+1]]. Every match is the closest one longer than the previous one
+func findMatches(data []byte) (offsets []int32, matches []match) {
+	n := len(data)
+	offsets = make([]int32, n+1)
+	head := make([]int32, 1<<hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, n)
+	for i := 0; i < n; i++ {
+		offsets[i] = int32(len(matches))
+		if i+minMatch > n {
+			continue
+		}
+		h := (uint32(data[i])<<16 | uint32(data[i+1])<<8 | uint32(data[i+2])) * 2654435761 >> (32 - hashBits)
+		limit := min(maxMatch, n-i)
+		best := minMatch - 1
+		for p, steps := head[h], 0; p >= 0 && i-int(p) <= windowSize && steps < maxChain; p, steps = prev[p], steps+1 {
+			q := int(p)
+			// matches not longer than the best one are skipped early
+			if data[q+best] != data[i+best] {
+				continue
+			}
+			length := 0
+			for length < limit && data[q+length] == data[i+length] {
+				length++
+			}
+			if length > best {
+				matches = append(matches, match{uint16(length), uint16(i - q)})
+				best = length
+				if best == limit {
+					break
+				}
+			}
+		}
+		prev[i] = head[h]
+		head[h] = int32(i)
+	}
+	offsets[n] = int32(len(matches))
+	return offsets, matches
+}
+
+// costs are estimated sizes in bits of literal/length and distance symbols, without extra bits
+type costs struct {
+	litLen [286]float64
+	dist   [30]float64
+}
+
+// fixedCosts returns sizes of symbols of the fixed Huffman code
+func fixedCosts() *costs {
+	c := &costs{}
+	lengths := fixedLitLenLengths()
+	for i := range c.litLen {
+		c.litLen[i] = float64(lengths[i])
+	}
+	for i := range c.dist {
+		c.dist[i] = 5
+	}
+	return c
+}
+
+// statisticCosts returns entropy of symbols, as an estimate of their sizes in a block with symbols
+func statisticCosts(symbols []symbol) *costs {
+	litLenFreqs, distFreqs := frequencies(symbols)
+	c := &costs{}
+	entropy(litLenFreqs[:], c.litLen[:])
+	entropy(distFreqs[:], c.dist[:])
+	return c
+}
+
+// entropy sets sizes of symbols with the given frequencies, unused symbols cost as much as the rarest ones
+func entropy(freqs []int, sizes []float64) {
+	total := 0
+	for _, f := range freqs {
+		total += f
+	}
+	if total == 0 {
+		return
+	}
+	log2Total := math.Log2(float64(total))
+	for i, f := range freqs {
+		sizes[i] = log2Total
+		if f > 0 {
+			sizes[i] -= math.Log2(float64(f))
+		}
+	}
+}
+
+// parse returns symbols of the cheapest path through data[start:end]
+func parse(data []byte, offsets []int32, matches []match, start, end int, c *costs) []symbol {
+	n := end - start
+	var lengthCosts [maxMatch + 1]float64
+	for l := minMatch; l <= maxMatch; l++ {
+		s := lengthSymbol[l]
+		lengthCosts[l] = c.litLen[257+int(s)] + float64(lengthExtra[s])
+	}
+	distCost := func(d uint16) float64 {
+		s := distSymbol(int(d))
+		return c.dist[s] + float64(distExtra[s])
+	}
+
+	cost := make([]float64, n+1)
+	for i := range cost[1:] {
+		cost[i+1] = math.Inf(1)
+	}
+	// the last step reaching every position, length 1 is a literal
+	steps := make([]match, n+1)
+	relax := func(i, length int, dist uint16, value float64) {
+		if value < cost[i+length] {
+			cost[i+length] = value
+			steps[i+length] = match{uint16(length), dist}
+		}
+	}
+	for i := 0; i < n; i++ {
+		base := cost[i]
+		relax(i, 1, 0, base+c.litLen[data[start+i]])
+
+		ms := matches[offsets[start+i]:offsets[start+i+1]]
+		if len(ms) == 0 {
+			continue
+		}
+		// in long repetitions only the longest matches are worth trying
+		if longest := ms[len(ms)-1]; longest.length == maxMatch && i+maxMatch <= n {
+			relax(i, maxMatch, longest.dist, base+lengthCosts[maxMatch]+distCost(longest.dist))
+			continue
+		}
+		from := minMatch
+		for _, m := range ms {
+			to := min(int(m.length), n-i)
+			d := distCost(m.dist)
+			for l := from; l <= to; l++ {
+				relax(i, l, m.dist, base+lengthCosts[l]+d)
+			}
+			from = max(from, int(m.length)+1)
+		}
+	}
+
+	var symbols []symbol
+	for i := n; i > 0; {
+		s := steps[i]
+		if s.length == 1 {
+			symbols = append(symbols, symbol{litLen: uint16(data[start+i-1])})
+		} else {
+			symbols = append(symbols, symbol{litLen: s.length, dist: s.dist})
+		}
+		i -= int(s.length)
+	}
+	slices.Reverse(symbols)
+	return symbols
+}
+
+// optimize returns symbols of data[start:end], the smallest ones found in the given number of iterations
+func optimize(data []byte, offsets []int32, matches []match, start, end, iterations int) []symbol {
+	c := fixedCosts()
+	var best []symbol
+	bestSize := math.MaxInt
+	for i := 0; i < max(iterations, 1); i++ {
+		symbols := parse(data, offsets, matches, start, end, c)
+		if size := dynamicSize(symbols); size < bestSize {
+			best, bestSize = symbols, size
+		}
+		c = statisticCosts(symbols)
+	}
+	return best
+}
+
+// splitBlocks splits symbols into blocks, where their own Huffman codes make the stream smaller
+func splitBlocks(symbols []symbol) [][]symbol {
+	const parts = 8
+	if len(symbols) < 2*parts {
+		return [][]symbol{symbols}
+	}
+	bestSize := blockSize(symbols)
+	bestSplit := 0
+	for k := 1; k < parts; k++ {
+		split := len(symbols) * k / parts
+		if size := blockSize(symbols[:split]) + blockSize(symbols[split:]); size < bestSize {
+			bestSize, bestSplit = size, split
+		}
+	}
+	if bestSplit == 0 {
+		return [][]symbol{symbols}
+	}
+	return append(splitBlocks(symbols[:bestSplit]), splitBlocks(symbols[bestSplit:])...)
+}
+
+// Compress returns data packed as raw deflate stream, searched for the smallest one
+// in the given number of iterations
+func Compress(data []byte, iterations int) []byte {
+	w := &bitWriter{}
+	if len(data) == 0 {
+		writeFixed(w, nil, true)
+		w.align()
+		return w.out
+	}
+
+	offsets, matches := findMatches(data)
+	for start := 0; start < len(data); start += chunkSize {
+		end := min(start+chunkSize, len(data))
+		blocks := splitBlocks(optimize(data, offsets, matches, start, end, iterations))
+		pos := start
+		for k, block := range blocks {
+			final := end == len(data) && k == len(blocks)-1
+			pos = writeBlock(w, data, pos, block, final)
+		}
+	}
+	w.align()
+	return w.out
+}
@@ -0,0 +1,110 @@
+package deflate
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// bestCompression returns size of data packed by compress/flate with its best level
+func bestCompression(t *testing.T, data []byte) int {
+	t.Helper()
+	buf := bytes.Buffer{}
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Len()
+}
+
+func TestCompress(t *testing.T) {
+	t.Parallel()
+	random := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(random)
+	// texture-like data: 16-bit words of a gradient with some noise
+	pixels := make([]byte, 2*300*200)
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < len(pixels)/2; i++ {
+		binary.BigEndian.PutUint16(pixels[2*i:], uint16(0xF000|(i%300)/10<<6|r.Intn(3)))
+	}
+	// repetitions farther than a chunk
+	long := append(append([]byte(nil), random[:40000]...), random[:40000]...)
+
+	cases := []struct {
+		name string
+		data []byte
+		// whether the stream has to be smaller than the one of compress/flate
+		smaller bool
+	}{
+		{"empty", nil, false},
+		{"single byte", []byte{7}, false},
+		{"text", []byte(strings.Repeat("Gamewave texture and sound packer, ", 50)), true},
+		{"zeros", make([]byte, 200000), false},
+		{"random", random, false},
+		{"pixels", pixels, true},
+		{"long distance", long, false},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			packed := Compress(tt.data, 5)
+			unpacked, err := io.ReadAll(flate.NewReader(bytes.NewReader(packed)))
+			require.NoError(t, err)
+			require.Equal(t, len(tt.data), len(unpacked))
+			require.True(t, bytes.Equal(tt.data, unpacked))
+
+			best := bestCompression(t, tt.data)
+			if tt.smaller {
+				require.Less(t, len(packed), best)
+			} else {
+				// stored blocks of random data are a few bytes larger
+				require.LessOrEqual(t, len(packed), best+8)
+			}
+		})
+	}
+}
+
+func TestCodeLengths(t *testing.T) {
+	t.Parallel()
+	// fibonacci frequencies make the deepest Huffman trees
+	fibonacci := []int{1, 1}
+	for len(fibonacci) < 30 {
+		fibonacci = append(fibonacci, fibonacci[len(fibonacci)-1]+fibonacci[len(fibonacci)-2])
+	}
+	cases := []struct {
+		name  string
+		freqs []int
+		limit int
+	}{
+		{"single", []int{0, 0, 5}, 15},
+		{"single first", []int{5, 0, 0}, 7},
+		{"balanced", []int{1, 1, 1, 1}, 15},
+		{"fibonacci", fibonacci, 15},
+		{"fibonacci short", fibonacci[:19], 7},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			lengths := codeLengths(tt.freqs, tt.limit)
+			// the code is complete
+			kraft := 0.0
+			for s, l := range lengths {
+				require.LessOrEqual(t, int(l), tt.limit)
+				if tt.freqs[s] > 0 {
+					require.NotZero(t, l)
+				}
+				if l > 0 {
+					kraft += 1 / float64(int(1)<<l)
+				}
+			}
+			require.Equal(t, 1.0, kraft)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// Package qoi encodes images in the Quite OK Image format, a simple lossless format
+// packing better than uncompressed ones, see https://qoiformat.org/qoi-specification.pdf.
+// Opaque and grayscale images are stored with 3 channels, other images with 4
+package qoi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+const (
+	headerSize = 14
+	magic      = "qoif"
+	// colorSpaceSRGB marks sRGB colors with linear alpha
+	colorSpaceSRGB = 0
+	// tags of chunks
+	opIndex = 0x00
+	opDiff  = 0x40
+	opLuma  = 0x80
+	opRun   = 0xC0
+	opRGB   = 0xFE
+	opRGBA  = 0xFF
+	// maxRun is the longest run stored in a single chunk
+	maxRun = 62
+)
+
+// padding marks the end of the stream
+var padding = []byte{0, 0, 0, 0, 0, 0, 0, 1}
+
+// hash returns the position of c in the array of recently seen colors
+func hash(c color.NRGBA) int {
+	return (int(c.R)*3 + int(c.G)*5 + int(c.B)*7 + int(c.A)*11) % 64
+}
+
+// opaque reports whether all pixels of m are opaque
+func opaque(m image.Image) bool {
+	if o, ok := m.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := m.At(x, y).RGBA(); a != 0xFFFF {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Encode writes m to w in QOI format
+func Encode(w io.Writer, m image.Image) error {
+	b := m.Bounds()
+	if int64(b.Dx())*int64(b.Dy()) >= 400_000_000 {
+		return fmt.Errorf("qoi: image of size %dx%d is too large", b.Dx(), b.Dy())
+	}
+	channels := byte(4)
+	if opaque(m) {
+		channels = 3
+	}
+
+	header := [headerSize]byte{}
+	copy(header[:], magic)
+	binary.BigEndian.PutUint32(header[4:], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(header[8:], uint32(b.Dy()))
+	header[12] = channels
+	header[13] = colorSpaceSRGB
+
+	// errors of writes are kept by bw, and returned by Flush
+	bw := bufio.NewWriter(w)
+	bw.Write(header[:])
+	var index [64]color.NRGBA
+	prev := color.NRGBA{A: 0xFF}
+	run := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if c == prev {
+				run++
+				if run == maxRun {
+					bw.WriteByte(opRun | byte(run-1))
+					run = 0
+				}
+				continue
+			}
+			if run > 0 {
+				bw.WriteByte(opRun | byte(run-1))
+				run = 0
+			}
+			writeColor(bw, &index, prev, c)
+			prev = c
+		}
+	}
+	if run > 0 {
+		bw.WriteByte(opRun | byte(run-1))
+	}
+	bw.Write(padding)
+	return bw.Flush()
+}
+
+// writeColor writes c in the smallest chunk, using the previous color and the recently seen ones
+func writeColor(bw *bufio.Writer, index *[64]color.NRGBA, prev, c color.NRGBA) {
+	h := hash(c)
+	if index[h] == c {
+		bw.WriteByte(opIndex | byte(h))
+		return
+	}
+	index[h] = c
+	if c.A != prev.A {
+		bw.Write([]byte{opRGBA, c.R, c.G, c.B, c.A})
+		return
+	}
+	// differences wrap around, like they do in decoders
+	dr, dg, db := int(int8(c.R-prev.R)), int(int8(c.G-prev.G)), int(int8(c.B-prev.B))
+	drg, dbg := dr-dg, db-dg
+	switch {
+	case dr >= -2 && dr <= 1 && dg >= -2 && dg <= 1 && db >= -2 && db <= 1:
+		bw.WriteByte(opDiff | byte(dr+2)<<4 | byte(dg+2)<<2 | byte(db+2))
+	case dg >= -32 && dg <= 31 && drg >= -8 && drg <= 7 && dbg >= -8 && dbg <= 7:
+		bw.Write([]byte{opLuma | byte(dg+32), byte(drg+8)<<4 | byte(dbg+8)})
+	default:
+		bw.Write([]byte{opRGB, c.R, c.G, c.B})
+	}
+}
@@ -0,0 +1,105 @@
+package qoi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// decode reads pixels of a QOI stream, following the specification
+func decode(t *testing.T, data []byte) *image.NRGBA {
+	t.Helper()
+	require.Equal(t, magic, string(data[:4]))
+	width, height := int(binary.BigEndian.Uint32(data[4:])), int(binary.BigEndian.Uint32(data[8:]))
+	m := image.NewNRGBA(image.Rect(0, 0, width, height))
+	var index [64]color.NRGBA
+	c := color.NRGBA{A: 0xFF}
+	data = data[headerSize:]
+	for i := 0; i < len(m.Pix); i += 4 {
+		run := 0
+		switch tag := data[0]; {
+		case tag == opRGB:
+			c.R, c.G, c.B = data[1], data[2], data[3]
+			data = data[4:]
+		case tag == opRGBA:
+			c = color.NRGBA{data[1], data[2], data[3], data[4]}
+			data = data[5:]
+		case tag&0xC0 == opIndex:
+			c = index[tag]
+			data = data[1:]
+		case tag&0xC0 == opDiff:
+			c.R += tag>>4&3 - 2
+			c.G += tag>>2&3 - 2
+			c.B += tag&3 - 2
+			data = data[1:]
+		case tag&0xC0 == opLuma:
+			dg := tag&0x3F - 32
+			c.R += dg + data[1]>>4 - 8
+			c.G += dg
+			c.B += dg + data[1]&0xF - 8
+			data = data[2:]
+		default:
+			run = int(tag & 0x3F)
+			data = data[1:]
+		}
+		index[hash(c)] = c
+		for ; run >= 0; run-- {
+			copy(m.Pix[i:], []byte{c.R, c.G, c.B, c.A})
+			if run > 0 {
+				i += 4
+			}
+		}
+	}
+	require.Equal(t, padding, data)
+	return m
+}
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+	red := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	red.SetNRGBA(0, 0, color.NRGBA{0xFF, 0, 0, 0xFF})
+	red.SetNRGBA(1, 0, color.NRGBA{0xFF, 0, 0, 0xFF})
+	buf := bytes.Buffer{}
+	require.NoError(t, Encode(&buf, red))
+	// red is the previous black with red decreased by 1, and it's repeated once
+	expected := append([]byte{'q', 'o', 'i', 'f', 0, 0, 0, 2, 0, 0, 0, 1, 3, 0, 0x5A, 0xC0}, padding...)
+	require.Equal(t, expected, buf.Bytes())
+
+	// random small changes of colors, and long runs, use all kinds of chunks
+	rng := rand.New(rand.NewSource(1))
+	m := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	c := color.NRGBA{0x80, 0x80, 0x80, 0xFF}
+	for i := 0; i < len(m.Pix); i += 4 {
+		switch rng.Intn(6) {
+		case 0:
+			c.R += uint8(rng.Intn(5) - 2)
+		case 1:
+			c.G += uint8(rng.Intn(80) - 40)
+		case 2:
+			c = color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(2) * 0xFF)}
+		}
+		if i/4%640 < 100 {
+			c = color.NRGBA{1, 2, 3, 4}
+		}
+		copy(m.Pix[i:], []byte{c.R, c.G, c.B, c.A})
+	}
+	buf.Reset()
+	require.NoError(t, Encode(&buf, m))
+	require.Equal(t, byte(4), buf.Bytes()[12])
+	require.Equal(t, m, decode(t, buf.Bytes()))
+
+	gray := image.NewGray(image.Rect(0, 0, 3, 1))
+	copy(gray.Pix, []byte{0, 10, 200})
+	buf.Reset()
+	require.NoError(t, Encode(&buf, gray))
+	require.Equal(t, byte(3), buf.Bytes()[12])
+	decoded := decode(t, buf.Bytes())
+	for x, v := range gray.Pix {
+		require.Equal(t, color.NRGBA{v, v, v, 0xFF}, decoded.NRGBAAt(x, 0))
+	}
+}
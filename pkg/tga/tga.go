@@ -0,0 +1,68 @@
+// Package tga encodes images as uncompressed Truevision TGA files, readable by most image editors.
+// Grayscale images are stored with one byte per pixel, other images as 32-bit BGRA
+package tga
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// image types of the header
+const (
+	typeTrueColor = 2
+	typeGray      = 3
+)
+
+// headerSize is the size of the header, without the image id, which isn't written
+const headerSize = 18
+
+// descriptorTopLeft marks rows stored from the top, images are stored from the bottom by default
+const descriptorTopLeft = 0x20
+
+// Encode writes m to w in TGA format
+func Encode(w io.Writer, m image.Image) error {
+	b := m.Bounds()
+	if b.Dx() > 0xFFFF || b.Dy() > 0xFFFF {
+		return fmt.Errorf("tga: image of size %dx%d is too large", b.Dx(), b.Dy())
+	}
+	gray, isGray := m.(*image.Gray)
+
+	header := [headerSize]byte{}
+	binary.LittleEndian.PutUint16(header[12:], uint16(b.Dx()))
+	binary.LittleEndian.PutUint16(header[14:], uint16(b.Dy()))
+	if isGray {
+		header[2] = typeGray
+		header[16] = 8
+		header[17] = descriptorTopLeft
+	} else {
+		header[2] = typeTrueColor
+		header[16] = 32
+		// the low bits hold the number of alpha bits
+		header[17] = descriptorTopLeft | 8
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(header[:]); err != nil {
+		return err
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		if isGray {
+			i := gray.PixOffset(b.Min.X, y)
+			if _, err := bw.Write(gray.Pix[i : i+b.Dx()]); err != nil {
+				return err
+			}
+			continue
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if _, err := bw.Write([]byte{c.B, c.G, c.R, c.A}); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,38 @@
+package tga
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	nrgba.SetNRGBA(0, 0, color.NRGBA{1, 2, 3, 4})
+	nrgba.SetNRGBA(1, 0, color.NRGBA{5, 6, 7, 8})
+	gray := image.NewGray(image.Rect(0, 0, 3, 3))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i)
+	}
+	cases := []struct {
+		name     string
+		m        image.Image
+		expected []byte
+	}{
+		{"nrgba", nrgba, []byte{0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 1, 0, 32, 0x28, 3, 2, 1, 4, 7, 6, 5, 8}},
+		// sub-images start at their own top-left corner
+		{"gray", gray.SubImage(image.Rect(1, 1, 3, 3)), []byte{0, 0, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 2, 0, 8, 0x20, 4, 5, 7, 8}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			buf := bytes.Buffer{}
+			require.NoError(t, Encode(&buf, tt.m))
+			require.Equal(t, tt.expected, buf.Bytes())
+		})
+	}
+}
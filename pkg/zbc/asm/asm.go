@@ -0,0 +1,612 @@
+// Package asm assembles text listings, as printed by package disasm, into Lua 5.0 chunks.
+//
+// Numbers at the start of instruction lines are labels, jump operands (@label) and
+// local variable ranges refer to them, so instructions can be added or removed
+// without renumbering the whole listing. Jumps and ranges outside of the function,
+// which have no label, are written as raw offsets (without @) and raw 0-based
+// instruction indexes (#pc). Instructions without a [line] inherit the source line
+// of the previous one.
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+)
+
+// A SyntaxError reports a problem with a listing line
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+type instruction struct {
+	srcLine  int
+	label    int
+	hasLabel bool
+	// line is the source line, or -1 if there's no line information
+	line     int
+	mnemonic string
+	operands []string
+}
+
+type local struct {
+	srcLine    int
+	name       string
+	start, end position
+}
+
+// position is a label, or a raw instruction index written as #pc
+type position struct {
+	value int
+	raw   bool
+}
+
+type function struct {
+	id           string
+	f            *chunk.Function
+	hasUpvalues  bool
+	instructions []instruction
+	locals       []local
+}
+
+type parser struct {
+	line      int
+	c         *chunk.Chunk
+	hasHeader bool
+	functions map[string]*chunk.Function
+	cur       *function
+	inCode    bool
+}
+
+// Assemble parses a listing and returns the chunk it describes
+func Assemble(r io.Reader) (*chunk.Chunk, error) {
+	p := parser{
+		c:         &chunk.Chunk{Header: chunk.DefaultHeader()},
+		functions: map[string]*chunk.Function{},
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		p.line++
+		if err := p.parseLine(scanner.Text()); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if p.cur != nil {
+		return nil, p.errorf("missing .end of function %s", p.cur.id)
+	}
+	if p.c.Main == nil {
+		return nil, p.errorf("no main function")
+	}
+	return p.c, nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &SyntaxError{Line: p.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// tokenize splits line on whitespace, keeping quoted strings together and dropping comments
+func tokenize(line string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ';':
+			return tokens, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(line) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, line[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for j < len(line) && !strings.ContainsRune(" \t\r;\"", rune(line[j])) {
+				j++
+			}
+			tokens = append(tokens, line[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func (p *parser) parseLine(line string) error {
+	if p.inCode {
+		return p.parseCodeLine(line)
+	}
+
+	tokens, err := tokenize(line)
+	if err != nil {
+		return p.errorf("%s", err)
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	if tokens[0] == ".chunk" {
+		return p.parseHeader(tokens[1:])
+	}
+	if tokens[0] == ".function" {
+		if len(tokens) != 2 {
+			return p.errorf("expected function id")
+		}
+		return p.startFunction(tokens[1])
+	}
+	if p.cur == nil {
+		return p.errorf("unexpected %s outside of a function", tokens[0])
+	}
+
+	f := p.cur.f
+	switch tokens[0] {
+	case ".source":
+		if len(tokens) != 2 {
+			return p.errorf("expected source name")
+		}
+		f.Source, err = unquote(tokens[1])
+	case ".linedefined":
+		f.LineDefined, err = intArgument(tokens)
+	case ".upvalues":
+		var n int
+		n, err = byteArgument(tokens)
+		f.NumUpvalues = uint8(n)
+		p.cur.hasUpvalues = true
+	case ".params":
+		var n int
+		n, err = byteArgument(tokens)
+		f.NumParams = uint8(n)
+	case ".vararg":
+		var n int
+		n, err = byteArgument(tokens)
+		f.IsVararg = n != 0
+	case ".maxstack":
+		var n int
+		n, err = byteArgument(tokens)
+		f.MaxStackSize = uint8(n)
+	case ".const":
+		err = p.parseConstant(tokens)
+	case ".local":
+		err = p.parseLocal(tokens)
+	case ".upvalue":
+		err = p.parseUpvalueName(tokens)
+	case ".code":
+		p.inCode = true
+	default:
+		return p.errorf("unknown directive %s", tokens[0])
+	}
+	if err != nil {
+		if _, ok := err.(*SyntaxError); ok {
+			return err
+		}
+		return p.errorf("%s: %s", tokens[0], err)
+	}
+	return nil
+}
+
+func unquote(s string) (string, error) {
+	if !strings.HasPrefix(s, "\"") {
+		return "", fmt.Errorf("expected a quoted string, got %s", s)
+	}
+	return strconv.Unquote(s)
+}
+
+func intArgument(tokens []string) (int, error) {
+	if len(tokens) != 2 {
+		return 0, fmt.Errorf("expected one number")
+	}
+	return strconv.Atoi(tokens[1])
+}
+
+func byteArgument(tokens []string) (int, error) {
+	n, err := intArgument(tokens)
+	if err == nil && (n < 0 || n > 255) {
+		err = fmt.Errorf("%d is out of range", n)
+	}
+	return n, err
+}
+
+// indexed parses operands like K3, checking that they're given in order
+func indexed(token, prefix string, expected int) error {
+	n, err := strconv.Atoi(strings.TrimPrefix(token, prefix))
+	if err != nil || !strings.HasPrefix(token, prefix) {
+		return fmt.Errorf("expected %s%d, got %s", prefix, expected, token)
+	}
+	if n != expected {
+		return fmt.Errorf("expected %s%d, got %s; entries must be listed in order", prefix, expected, token)
+	}
+	return nil
+}
+
+func (p *parser) parseHeader(tokens []string) error {
+	if p.hasHeader || len(p.functions) > 0 {
+		return p.errorf(".chunk must be given once, before functions")
+	}
+	p.hasHeader = true
+	h := &p.c.Header
+	for _, token := range tokens {
+		key, value, found := strings.Cut(token, "=")
+		if !found {
+			switch token {
+			case "little":
+				h.LittleEndian = true
+			case "big":
+				h.LittleEndian = false
+			case "float":
+				h.IntegralNumber = false
+			case "integral":
+				h.IntegralNumber = true
+			case "5.0":
+				h.Version = chunk.Version
+			default:
+				return p.errorf("unknown .chunk option %s", token)
+			}
+			continue
+		}
+		n, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return p.errorf("invalid .chunk option %s", token)
+		}
+		switch key {
+		case "int":
+			h.SizeInt = uint8(n)
+		case "size_t":
+			h.SizeSizeT = uint8(n)
+		case "instruction":
+			h.SizeInstruction = uint8(n)
+		case "number":
+			h.SizeNumber = uint8(n)
+		default:
+			return p.errorf("unknown .chunk option %s", token)
+		}
+	}
+	return nil
+}
+
+func (p *parser) startFunction(id string) error {
+	if p.cur != nil {
+		return p.errorf("missing .end of function %s", p.cur.id)
+	}
+	if _, ok := p.functions[id]; ok {
+		return p.errorf("function %s is defined twice", id)
+	}
+
+	f := &chunk.Function{}
+	if id == "main" {
+		p.c.Main = f
+	} else {
+		slash := strings.LastIndex(id, "/")
+		if slash < 0 {
+			return p.errorf("function id %s should be main, or parent/index", id)
+		}
+		parentID, index := id[:slash], id[slash+1:]
+		parent, ok := p.functions[parentID]
+		if !ok {
+			return p.errorf("function %s has no parent function defined before it", id)
+		}
+		n, err := strconv.Atoi(index)
+		if err != nil || n != len(parent.Functions) {
+			return p.errorf("function %s is out of order, expected %s/%d", id, parentID, len(parent.Functions))
+		}
+		parent.Functions = append(parent.Functions, f)
+		f.Source = parent.Source
+	}
+
+	p.functions[id] = f
+	p.cur = &function{id: id, f: f}
+	f.LineInfo = []int{}
+	f.Locals = []chunk.Local{}
+	f.Upvalues = []string{}
+	f.Constants = []chunk.Constant{}
+	f.Functions = []*chunk.Function{}
+	f.Code = []chunk.Instruction{}
+	return nil
+}
+
+func (p *parser) parseConstant(tokens []string) error {
+	f := p.cur.f
+	if len(tokens) != 3 {
+		return fmt.Errorf("expected index and value")
+	}
+	if err := indexed(tokens[1], "K", len(f.Constants)); err != nil {
+		return err
+	}
+
+	value := tokens[2]
+	switch {
+	case value == "nil":
+		f.Constants = append(f.Constants, chunk.NilConstant())
+	case strings.HasPrefix(value, "\""):
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return err
+		}
+		f.Constants = append(f.Constants, chunk.StringConstant(s))
+	default:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid constant %s", value)
+		}
+		f.Constants = append(f.Constants, chunk.NumberConstant(n))
+	}
+	return nil
+}
+
+func (p *parser) parseLocal(tokens []string) error {
+	if len(tokens) != 4 {
+		return fmt.Errorf("expected name, start and end")
+	}
+	name, err := unquote(tokens[1])
+	if err != nil {
+		return err
+	}
+	start, err := parsePosition(tokens[2])
+	if err != nil {
+		return err
+	}
+	end, err := parsePosition(tokens[3])
+	if err != nil {
+		return err
+	}
+	p.cur.locals = append(p.cur.locals, local{srcLine: p.line, name: name, start: start, end: end})
+	return nil
+}
+
+func parsePosition(token string) (position, error) {
+	raw := strings.HasPrefix(token, "#")
+	n, err := strconv.Atoi(strings.TrimPrefix(token, "#"))
+	return position{value: n, raw: raw}, err
+}
+
+func (p *parser) parseUpvalueName(tokens []string) error {
+	f := p.cur.f
+	if len(tokens) != 3 {
+		return fmt.Errorf("expected index and name")
+	}
+	if err := indexed(tokens[1], "U", len(f.Upvalues)); err != nil {
+		return err
+	}
+	name, err := unquote(tokens[2])
+	if err != nil {
+		return err
+	}
+	f.Upvalues = append(f.Upvalues, name)
+	return nil
+}
+
+var codeLine = regexp.MustCompile(`^\s*(\d+)?\s*(?:\[\s*(-|\d+)\s*\])?(.*)$`)
+
+func (p *parser) parseCodeLine(line string) error {
+	m := codeLine.FindStringSubmatch(line)
+	tokens, err := tokenize(m[3])
+	if err != nil {
+		return p.errorf("%s", err)
+	}
+	if m[1] == "" && m[2] == "" && len(tokens) == 0 {
+		return nil
+	}
+	if m[1] == "" && m[2] == "" && tokens[0] == ".end" {
+		if len(tokens) != 1 {
+			return p.errorf("unexpected tokens after .end")
+		}
+		p.inCode = false
+		err := p.endFunction()
+		p.cur = nil
+		return err
+	}
+	if len(tokens) == 0 {
+		return p.errorf("missing instruction")
+	}
+	if strings.HasPrefix(tokens[0], ".") && tokens[0] != ".word" {
+		return p.errorf("unexpected %s in code of function %s, missing .end?", tokens[0], p.cur.id)
+	}
+
+	ins := instruction{srcLine: p.line, mnemonic: tokens[0], operands: tokens[1:]}
+	if m[1] != "" {
+		ins.hasLabel = true
+		ins.label, err = strconv.Atoi(m[1])
+		if err != nil {
+			return p.errorf("invalid label %s", m[1])
+		}
+	}
+	switch {
+	case m[2] == "-":
+		ins.line = -1
+	case m[2] != "":
+		ins.line, err = strconv.Atoi(m[2])
+		if err != nil {
+			return p.errorf("invalid line %s", m[2])
+		}
+	case len(p.cur.instructions) > 0:
+		ins.line = p.cur.instructions[len(p.cur.instructions)-1].line
+	default:
+		ins.line = -1
+	}
+	p.cur.instructions = append(p.cur.instructions, ins)
+	return nil
+}
+
+func (p *parser) endFunction() error {
+	fn := p.cur
+	f := fn.f
+
+	labels := map[int]int{}
+	lastLabel := 0
+	for pc, ins := range fn.instructions {
+		if !ins.hasLabel {
+			continue
+		}
+		if _, ok := labels[ins.label]; ok {
+			return &SyntaxError{Line: ins.srcLine, Msg: fmt.Sprintf("duplicate label %d", ins.label)}
+		}
+		labels[ins.label] = pc
+		lastLabel = max(lastLabel, ins.label)
+	}
+	// ranges of locals may end one past the last instruction
+	resolve := func(p position) (int, bool) {
+		if p.raw {
+			return p.value, true
+		}
+		if p.value == lastLabel+1 {
+			return len(fn.instructions), true
+		}
+		pc, ok := labels[p.value]
+		return pc, ok
+	}
+
+	for pc, ins := range fn.instructions {
+		i, err := encodeInstruction(ins, pc, labels)
+		if err != nil {
+			return &SyntaxError{Line: ins.srcLine, Msg: err.Error()}
+		}
+		f.Code = append(f.Code, i)
+
+		if ins.line < 0 {
+			continue
+		}
+		if len(f.LineInfo) != pc {
+			return &SyntaxError{Line: ins.srcLine, Msg: "line information must be given for all instructions, or only for the first ones"}
+		}
+		f.LineInfo = append(f.LineInfo, ins.line)
+	}
+
+	for _, l := range fn.locals {
+		start, ok := resolve(l.start)
+		if !ok {
+			return &SyntaxError{Line: l.srcLine, Msg: fmt.Sprintf("unknown label %d", l.start.value)}
+		}
+		end, ok := resolve(l.end)
+		if !ok {
+			return &SyntaxError{Line: l.srcLine, Msg: fmt.Sprintf("unknown label %d", l.end.value)}
+		}
+		f.Locals = append(f.Locals, chunk.Local{Name: l.name, StartPC: start, EndPC: end})
+	}
+
+	if !fn.hasUpvalues {
+		f.NumUpvalues = uint8(len(f.Upvalues))
+	}
+	return nil
+}
+
+func encodeInstruction(ins instruction, pc int, labels map[int]int) (chunk.Instruction, error) {
+	if ins.mnemonic == ".word" {
+		if len(ins.operands) != 1 {
+			return 0, fmt.Errorf(".word expects one value")
+		}
+		n, err := strconv.ParseUint(ins.operands[0], 0, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid .word value %s", ins.operands[0])
+		}
+		return chunk.Instruction(n), nil
+	}
+
+	op, ok := chunk.LookupOpCode(strings.ToUpper(ins.mnemonic))
+	if !ok {
+		return 0, fmt.Errorf("unknown instruction %s", ins.mnemonic)
+	}
+	info := op.Info()
+
+	count := 3
+	if info.Mode != chunk.ModeABC {
+		count = 2
+	}
+	if len(ins.operands) != count {
+		return 0, fmt.Errorf("%s expects %d operands, got %d", info.Name, count, len(ins.operands))
+	}
+
+	a, err := operand(ins.operands[0], info.A, pc, labels, 0, chunk.MaxArgA)
+	if err != nil {
+		return 0, err
+	}
+	switch info.Mode {
+	case chunk.ModeABx:
+		bx, err := operand(ins.operands[1], info.B, pc, labels, 0, chunk.MaxArgBx)
+		if err != nil {
+			return 0, err
+		}
+		return chunk.NewABx(op, a, bx), nil
+	case chunk.ModeAsBx:
+		sbx, err := operand(ins.operands[1], info.B, pc, labels, -chunk.MaxArgSBx, chunk.MaxArgBx-chunk.MaxArgSBx)
+		if err != nil {
+			return 0, err
+		}
+		return chunk.NewAsBx(op, a, sbx), nil
+	}
+
+	b, err := operand(ins.operands[1], info.B, pc, labels, 0, chunk.MaxArgB)
+	if err != nil {
+		return 0, err
+	}
+	c, err := operand(ins.operands[2], info.C, pc, labels, 0, chunk.MaxArgC)
+	if err != nil {
+		return 0, err
+	}
+	return chunk.NewABC(op, a, b, c), nil
+}
+
+// prefixes of operands allowed for each kind of argument
+var prefixes = map[chunk.ArgMode]string{
+	chunk.ArgRegister: "R",
+	chunk.ArgRK:       "RK",
+	chunk.ArgConstant: "K",
+	chunk.ArgUpvalue:  "U",
+	chunk.ArgFunction: "F",
+	chunk.ArgJump:     "@",
+}
+
+func operand(token string, mode chunk.ArgMode, pc int, labels map[int]int, lowest, highest int) (int, error) {
+	prefix := ""
+	if token != "" && strings.ContainsRune("RKUF@", rune(token[0])) {
+		prefix = token[:1]
+		if !strings.Contains(prefixes[mode], prefix) {
+			return 0, fmt.Errorf("unexpected operand %s", token)
+		}
+	}
+	n, err := strconv.Atoi(token[len(prefix):])
+	if err != nil {
+		return 0, fmt.Errorf("invalid operand %s", token)
+	}
+
+	switch prefix {
+	case "@":
+		target, ok := labels[n]
+		if !ok {
+			return 0, fmt.Errorf("unknown label %d", n)
+		}
+		n = target - (pc + 1)
+	case "R":
+		if mode == chunk.ArgRK && chunk.IsConstant(n) {
+			return 0, fmt.Errorf("register %s is out of range", token)
+		}
+	case "K":
+		if mode == chunk.ArgRK {
+			n += chunk.MaxStack
+		}
+	}
+
+	if n < lowest || n > highest {
+		return 0, fmt.Errorf("operand %s is out of range", token)
+	}
+	return n, nil
+}
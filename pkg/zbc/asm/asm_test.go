@@ -0,0 +1,183 @@
+package asm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+	"github.com/namgo/GameWaveFans/pkg/zbc/disasm"
+	"github.com/stretchr/testify/require"
+)
+
+const listing = `; local t = {1, 2, x = "y"}
+; local function add(a, b) return a + b + t[1] end
+; for i = 1, 3 do print(add(i, 2)) end
+.chunk 5.0 little int=4 size_t=4 instruction=4 number=8 float
+
+.function main
+.source "@test.lua"
+.linedefined 0
+.upvalues 0
+.params 0
+.vararg 0
+.maxstack 8
+.const K0 1
+.const K1 2
+.const K2 "x"
+.const K3 "y"
+.const K4 3
+.const K5 "print"
+.const K6 "caf\xe9"
+.local "t" 6 18
+.local "add" 8 18
+.local "i" 12 17
+.code
+     1 [   1] NEWTABLE   R0 2 1
+     2 [   1] LOADK      R1 K0   ; 1
+     3 [   1] LOADK      R2 K1   ; 2
+     4 [   1] SETTABLE   R0 K2 K3
+     5 [   1] SETLIST    R0 1
+     6 [   2] CLOSURE    R1 F0
+     7 [   2] MOVE       0 R0 0
+     8 [   3] LOADK      R2 K0
+     9 [   3] LOADK      R3 K4
+    10 [   3] LOADK      R4 K0
+    11 [   3] SUB        R2 R2 R4
+    12 [   3] JMP        0 @19
+    13 [   4] GETGLOBAL  R5 K5
+    14 [   4] MOVE       R6 R1 0
+    15 [   4] MOVE       R7 R2 0
+    16 [   4] LOADK      R8 K1
+    17 [   4] CALL       R6 3 0
+    18 [   4] CALL       R5 0 1
+    19 [   3] FORLOOP    R2 @13
+    20 [   5] RETURN     R0 1 0
+.end
+
+.function main/0
+.linedefined 2
+.upvalues 1
+.params 2
+.vararg 0
+.maxstack 4
+.const K0 1
+.local "a" 1 5
+.local "b" 1 5
+.upvalue U0 "t"
+.code
+     1 [   2] ADD        R2 R0 R1
+     2 [   2] GETUPVAL   R3 U0 0
+     3 [   2] GETTABLE   R3 R3 K0
+     4 [   2] ADD        R2 R2 R3
+     5 [   2] RETURN     R2 2 0
+     6 [   2] RETURN     R0 1 0
+.end
+`
+
+func encode(t *testing.T, c *chunk.Chunk) []byte {
+	t.Helper()
+	buf := bytes.Buffer{}
+	require.NoError(t, chunk.Encode(&buf, c))
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+	c, err := Assemble(strings.NewReader(listing))
+	require.NoError(t, err)
+	require.Len(t, c.Main.Code, 20)
+	require.Equal(t, chunk.NewAsBx(chunk.OpJmp, 0, 6), c.Main.Code[11])
+	require.Equal(t, chunk.NewAsBx(chunk.OpForLoop, 2, -7), c.Main.Code[18])
+	require.Equal(t, chunk.NewABC(chunk.OpSetTable, 0, chunk.MaxStack+2, chunk.MaxStack+3), c.Main.Code[3])
+	require.Equal(t, chunk.Local{Name: "i", StartPC: 11, EndPC: 16}, c.Main.Locals[2])
+	require.Equal(t, "\x63\x61\x66\xe9", c.Main.Constants[6].Text)
+	require.Equal(t, "@test.lua", c.Main.Functions[0].Source)
+	original := encode(t, c)
+
+	decoded, err := chunk.Decode(bytes.NewReader(original))
+	require.NoError(t, err)
+	require.Equal(t, c, decoded)
+
+	text := bytes.Buffer{}
+	require.NoError(t, disasm.Disassemble(&text, decoded))
+	reassembled, err := Assemble(&text)
+	require.NoError(t, err)
+	require.Equal(t, original, encode(t, reassembled))
+}
+
+func TestLabels(t *testing.T) {
+	t.Parallel()
+	// an instruction is added without renumbering, jumps still point at the same labels
+	patched := strings.Replace(listing,
+		"    13 [   4] GETGLOBAL  R5 K5\n",
+		"    13 [   4] GETGLOBAL  R5 K5\n           MOVE       R5 R5 0\n", 1)
+	c, err := Assemble(strings.NewReader(patched))
+	require.NoError(t, err)
+	require.Len(t, c.Main.Code, 21)
+	require.Equal(t, 4, c.Main.LineInfo[13])
+	require.Equal(t, chunk.NewAsBx(chunk.OpJmp, 0, 7), c.Main.Code[11])
+	require.Equal(t, chunk.NewAsBx(chunk.OpForLoop, 2, -8), c.Main.Code[19])
+	require.Equal(t, chunk.Local{Name: "i", StartPC: 11, EndPC: 17}, c.Main.Locals[2])
+}
+
+func TestRawPositions(t *testing.T) {
+	t.Parallel()
+	// malformed chunks jump and keep locals outside of the function, where there are no labels
+	patched := strings.Replace(listing, "JMP        0 @19", "JMP        0 -13", 1)
+	patched = strings.Replace(patched, `.local "i" 12 17`, `.local "i" #-1 #40`, 1)
+	c, err := Assemble(strings.NewReader(patched))
+	require.NoError(t, err)
+	require.Equal(t, chunk.NewAsBx(chunk.OpJmp, 0, -13), c.Main.Code[11])
+	require.Equal(t, chunk.Local{Name: "i", StartPC: -1, EndPC: 40}, c.Main.Locals[2])
+}
+
+func TestErrors(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name          string
+		old, new      string
+		expectedError error
+	}{
+		{
+			name:          "unknown instruction",
+			old:           "ADD        R2 R0 R1",
+			new:           "ADDX       R2 R0 R1",
+			expectedError: &SyntaxError{Line: 57, Msg: "unknown instruction ADDX"},
+		},
+		{
+			name:          "wrong operand kind",
+			old:           "LOADK      R2 K0",
+			new:           "LOADK      R2 R0",
+			expectedError: &SyntaxError{Line: 31, Msg: "unexpected operand R0"},
+		},
+		{
+			name:          "unknown label",
+			old:           "JMP        0 @19",
+			new:           "JMP        0 @70",
+			expectedError: &SyntaxError{Line: 35, Msg: "unknown label 70"},
+		},
+		{
+			name:          "constants out of order",
+			old:           ".const K1 2",
+			new:           ".const K2 2",
+			expectedError: &SyntaxError{Line: 14, Msg: ".const: expected K1, got K2; entries must be listed in order"},
+		},
+		{
+			name:          "missing end",
+			old:           "     6 [   2] RETURN     R0 1 0\n.end",
+			new:           "     6 [   2] RETURN     R0 1 0",
+			expectedError: &SyntaxError{Line: 62, Msg: "missing .end of function main/0"},
+		},
+	}
+
+	for _, tt := range cases {
+		text := strings.Replace(listing, tt.old, tt.new, 1)
+		expectedError := tt.expectedError
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := Assemble(strings.NewReader(text))
+			require.Equal(t, expectedError, err)
+		})
+	}
+}
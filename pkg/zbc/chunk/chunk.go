@@ -0,0 +1,179 @@
+// Package chunk parses Lua 5.0 bytecode chunks, as found in unpacked .zbc files
+package chunk
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Version is the Lua version of chunks used by Gamewave games
+const Version = 0x50
+
+const signature = "\x1bLua"
+
+// testNumber is stored in the header to detect format of lua_Number
+const testNumber = 3.14159265358979323846e7
+
+// Header describes how values are encoded in a chunk
+type Header struct {
+	Version         byte
+	LittleEndian    bool
+	SizeInt         uint8
+	SizeSizeT       uint8
+	SizeInstruction uint8
+	SizeOp          uint8
+	SizeA           uint8
+	SizeB           uint8
+	SizeC           uint8
+	SizeNumber      uint8
+	// IntegralNumber is set when lua_Number is an integer type instead of a float
+	IntegralNumber bool
+}
+
+// DefaultHeader returns header of chunks produced by luac 5.0 on a 32-bit little endian machine
+func DefaultHeader() Header {
+	return Header{
+		Version:         Version,
+		LittleEndian:    true,
+		SizeInt:         4,
+		SizeSizeT:       4,
+		SizeInstruction: 4,
+		SizeOp:          sizeOp,
+		SizeA:           sizeA,
+		SizeB:           sizeB,
+		SizeC:           sizeC,
+		SizeNumber:      8,
+	}
+}
+
+// Chunk is a parsed bytecode file
+type Chunk struct {
+	Header Header
+	Main   *Function
+}
+
+// Function is a function prototype, with its nested prototypes
+type Function struct {
+	// Source is the chunk name, nested functions inherit it from their parent
+	Source       string
+	LineDefined  int
+	NumUpvalues  uint8
+	NumParams    uint8
+	IsVararg     bool
+	MaxStackSize uint8
+	// LineInfo holds source line of every instruction, it's empty in stripped chunks
+	LineInfo  []int
+	Locals    []Local
+	Upvalues  []string
+	Constants []Constant
+	Functions []*Function
+	Code      []Instruction
+}
+
+// Local is debug information about a local variable
+type Local struct {
+	Name string
+	// StartPC and EndPC are 0-based indexes of instructions, where the variable is active
+	StartPC int
+	EndPC   int
+}
+
+// ConstantType is a Lua type tag of a constant
+type ConstantType uint8
+
+// Constant types allowed in Lua 5.0 chunks
+const (
+	TypeNil    ConstantType = 0
+	TypeNumber ConstantType = 3
+	TypeString ConstantType = 4
+)
+
+// Constant is a value from the constant table of a function
+type Constant struct {
+	Type   ConstantType
+	Number float64
+	Text   string
+}
+
+// NilConstant returns a nil constant
+func NilConstant() Constant {
+	return Constant{Type: TypeNil}
+}
+
+// NumberConstant returns a number constant
+func NumberConstant(n float64) Constant {
+	return Constant{Type: TypeNumber, Number: n}
+}
+
+// StringConstant returns a string constant
+func StringConstant(s string) Constant {
+	return Constant{Type: TypeString, Text: s}
+}
+
+// String formats constant the way it would be written in Lua source
+func (k Constant) String() string {
+	switch k.Type {
+	case TypeNil:
+		return "nil"
+	case TypeNumber:
+		return FormatNumber(k.Number)
+	case TypeString:
+		return strconv.Quote(k.Text)
+	}
+	return fmt.Sprintf("<type %d>", k.Type)
+}
+
+// FormatNumber formats a number, so it can be parsed back without losing precision
+func FormatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'g', -1, 64)
+}
+
+// Line returns source line of instruction at pc, or 0 if there's no debug information
+func (f *Function) Line(pc int) int {
+	if pc < 0 || pc >= len(f.LineInfo) {
+		return 0
+	}
+	return f.LineInfo[pc]
+}
+
+// LocalName returns name of a local variable held in register reg at pc,
+// or an empty string if there's no debug information about it
+func (f *Function) LocalName(reg, pc int) string {
+	// locals are listed in order of declaration, so the n-th active one lives in register n
+	n := 0
+	for _, l := range f.Locals {
+		if l.StartPC > pc {
+			break
+		}
+		if pc < l.EndPC {
+			if n == reg {
+				return l.Name
+			}
+			n++
+		}
+	}
+	return ""
+}
+
+// Walk calls fn for the main function and all nested functions, depth first.
+// id names the function by its position in the tree, e.g. "main", "main/0", "main/0/3"
+func (c *Chunk) Walk(fn func(id string, f *Function) error) error {
+	return walk("main", c.Main, fn)
+}
+
+func walk(id string, f *Function, fn func(id string, f *Function) error) error {
+	if err := fn(id, f); err != nil {
+		return err
+	}
+	for i, p := range f.Functions {
+		if err := walk(id+"/"+strconv.Itoa(i), p, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A FormatError reports that the input is not a valid Lua chunk.
+type FormatError string
+
+func (e FormatError) Error() string { return "lua chunk error: " + string(e) }
@@ -0,0 +1,255 @@
+package chunk
+
+import "fmt"
+
+// sizes and positions of instruction fields, Lua 5.0 stores A in the most significant bits
+const (
+	sizeOp = 6
+	sizeA  = 8
+	sizeB  = 9
+	sizeC  = 9
+	sizeBx = sizeB + sizeC
+
+	posC  = sizeOp
+	posB  = posC + sizeC
+	posBx = posC
+	posA  = posB + sizeB
+
+	// MaxArgA is the largest value of A field
+	MaxArgA = 1<<sizeA - 1
+	// MaxArgB is the largest value of B field
+	MaxArgB = 1<<sizeB - 1
+	// MaxArgC is the largest value of C field
+	MaxArgC = 1<<sizeC - 1
+	// MaxArgBx is the largest value of Bx field
+	MaxArgBx = 1<<sizeBx - 1
+	// MaxArgSBx is the largest value of sBx field
+	MaxArgSBx = MaxArgBx >> 1
+)
+
+// MaxStack is the maximum size of a stack frame. B and C operands greater or equal
+// to MaxStack refer to constants instead of registers
+const MaxStack = 250
+
+// FieldsPerFlush is the number of list items stored by a single SETLIST instruction
+const FieldsPerFlush = 32
+
+// IsConstant reports whether RK operand x refers to a constant
+func IsConstant(x int) bool {
+	return x >= MaxStack
+}
+
+// ConstantIndex returns index in constant table referred by RK operand x
+func ConstantIndex(x int) int {
+	return x - MaxStack
+}
+
+// OpCode is an operation code of Lua 5.0 virtual machine
+type OpCode uint8
+
+// Lua 5.0 opcodes
+const (
+	OpMove OpCode = iota
+	OpLoadK
+	OpLoadBool
+	OpLoadNil
+	OpGetUpval
+	OpGetGlobal
+	OpGetTable
+	OpSetGlobal
+	OpSetUpval
+	OpSetTable
+	OpNewTable
+	OpSelf
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpPow
+	OpUnm
+	OpNot
+	OpConcat
+	OpJmp
+	OpEq
+	OpLt
+	OpLe
+	OpTest
+	OpCall
+	OpTailCall
+	OpReturn
+	OpForLoop
+	OpTForLoop
+	OpTForPrep
+	OpSetList
+	OpSetListO
+	OpClose
+	OpClosure
+
+	// NumOpCodes is the number of valid opcodes
+	NumOpCodes = iota
+)
+
+// OpMode is the layout of instruction operands
+type OpMode uint8
+
+// instruction layouts
+const (
+	ModeABC OpMode = iota
+	ModeABx
+	ModeAsBx
+)
+
+// ArgMode describes how an operand is used
+type ArgMode uint8
+
+// operand kinds
+const (
+	// ArgNone is an unused operand
+	ArgNone ArgMode = iota
+	// ArgValue is an immediate number, like a count or a flag
+	ArgValue
+	// ArgRegister is a register index
+	ArgRegister
+	// ArgRK is a register index, or a constant index offset by MaxStack
+	ArgRK
+	// ArgConstant is a constant index
+	ArgConstant
+	// ArgUpvalue is an upvalue index
+	ArgUpvalue
+	// ArgFunction is an index of a nested function
+	ArgFunction
+	// ArgJump is a jump offset, relative to the next instruction
+	ArgJump
+)
+
+// OpInfo describes an opcode. For ABx and AsBx instructions B describes the Bx or sBx operand
+type OpInfo struct {
+	Name string
+	Mode OpMode
+	A    ArgMode
+	B    ArgMode
+	C    ArgMode
+	// Test is set for instructions, that are always followed by a JMP
+	Test bool
+}
+
+var opInfos = [NumOpCodes]OpInfo{
+	OpMove:      {"MOVE", ModeABC, ArgRegister, ArgRegister, ArgNone, false},
+	OpLoadK:     {"LOADK", ModeABx, ArgRegister, ArgConstant, ArgNone, false},
+	OpLoadBool:  {"LOADBOOL", ModeABC, ArgRegister, ArgValue, ArgValue, false},
+	OpLoadNil:   {"LOADNIL", ModeABC, ArgRegister, ArgRegister, ArgNone, false},
+	OpGetUpval:  {"GETUPVAL", ModeABC, ArgRegister, ArgUpvalue, ArgNone, false},
+	OpGetGlobal: {"GETGLOBAL", ModeABx, ArgRegister, ArgConstant, ArgNone, false},
+	OpGetTable:  {"GETTABLE", ModeABC, ArgRegister, ArgRegister, ArgRK, false},
+	OpSetGlobal: {"SETGLOBAL", ModeABx, ArgRegister, ArgConstant, ArgNone, false},
+	OpSetUpval:  {"SETUPVAL", ModeABC, ArgRegister, ArgUpvalue, ArgNone, false},
+	OpSetTable:  {"SETTABLE", ModeABC, ArgRegister, ArgRK, ArgRK, false},
+	OpNewTable:  {"NEWTABLE", ModeABC, ArgRegister, ArgValue, ArgValue, false},
+	OpSelf:      {"SELF", ModeABC, ArgRegister, ArgRegister, ArgRK, false},
+	OpAdd:       {"ADD", ModeABC, ArgRegister, ArgRK, ArgRK, false},
+	OpSub:       {"SUB", ModeABC, ArgRegister, ArgRK, ArgRK, false},
+	OpMul:       {"MUL", ModeABC, ArgRegister, ArgRK, ArgRK, false},
+	OpDiv:       {"DIV", ModeABC, ArgRegister, ArgRK, ArgRK, false},
+	OpPow:       {"POW", ModeABC, ArgRegister, ArgRK, ArgRK, false},
+	OpUnm:       {"UNM", ModeABC, ArgRegister, ArgRegister, ArgNone, false},
+	OpNot:       {"NOT", ModeABC, ArgRegister, ArgRegister, ArgNone, false},
+	OpConcat:    {"CONCAT", ModeABC, ArgRegister, ArgRegister, ArgRegister, false},
+	OpJmp:       {"JMP", ModeAsBx, ArgNone, ArgJump, ArgNone, false},
+	OpEq:        {"EQ", ModeABC, ArgValue, ArgRK, ArgRK, true},
+	OpLt:        {"LT", ModeABC, ArgValue, ArgRK, ArgRK, true},
+	OpLe:        {"LE", ModeABC, ArgValue, ArgRK, ArgRK, true},
+	OpTest:      {"TEST", ModeABC, ArgRegister, ArgRegister, ArgValue, true},
+	OpCall:      {"CALL", ModeABC, ArgRegister, ArgValue, ArgValue, false},
+	OpTailCall:  {"TAILCALL", ModeABC, ArgRegister, ArgValue, ArgValue, false},
+	OpReturn:    {"RETURN", ModeABC, ArgRegister, ArgValue, ArgNone, false},
+	OpForLoop:   {"FORLOOP", ModeAsBx, ArgRegister, ArgJump, ArgNone, false},
+	OpTForLoop:  {"TFORLOOP", ModeABC, ArgRegister, ArgNone, ArgValue, true},
+	OpTForPrep:  {"TFORPREP", ModeAsBx, ArgRegister, ArgJump, ArgNone, false},
+	OpSetList:   {"SETLIST", ModeABx, ArgRegister, ArgValue, ArgNone, false},
+	OpSetListO:  {"SETLISTO", ModeABx, ArgRegister, ArgValue, ArgNone, false},
+	OpClose:     {"CLOSE", ModeABC, ArgRegister, ArgNone, ArgNone, false},
+	OpClosure:   {"CLOSURE", ModeABx, ArgRegister, ArgFunction, ArgNone, false},
+}
+
+// Valid reports whether op is a known opcode
+func (op OpCode) Valid() bool {
+	return op < NumOpCodes
+}
+
+// Info returns description of the opcode
+func (op OpCode) Info() OpInfo {
+	if !op.Valid() {
+		return OpInfo{Name: op.String()}
+	}
+	return opInfos[op]
+}
+
+func (op OpCode) String() string {
+	if !op.Valid() {
+		return fmt.Sprintf("OP_%d", uint8(op))
+	}
+	return opInfos[op].Name
+}
+
+// LookupOpCode returns opcode with a given mnemonic
+func LookupOpCode(name string) (OpCode, bool) {
+	for i, info := range opInfos {
+		if info.Name == name {
+			return OpCode(i), true
+		}
+	}
+	return 0, false
+}
+
+// Instruction is a single encoded VM instruction
+type Instruction uint32
+
+// NewABC encodes an instruction in ABC mode
+func NewABC(op OpCode, a, b, c int) Instruction {
+	return Instruction(uint32(op)&(1<<sizeOp-1) |
+		uint32(a&MaxArgA)<<posA |
+		uint32(b&MaxArgB)<<posB |
+		uint32(c&MaxArgC)<<posC)
+}
+
+// NewABx encodes an instruction in ABx mode
+func NewABx(op OpCode, a, bx int) Instruction {
+	return Instruction(uint32(op)&(1<<sizeOp-1) |
+		uint32(a&MaxArgA)<<posA |
+		uint32(bx&MaxArgBx)<<posBx)
+}
+
+// NewAsBx encodes an instruction in AsBx mode
+func NewAsBx(op OpCode, a, sbx int) Instruction {
+	return NewABx(op, a, sbx+MaxArgSBx)
+}
+
+// OpCode returns operation code of the instruction
+func (i Instruction) OpCode() OpCode {
+	return OpCode(i & (1<<sizeOp - 1))
+}
+
+// A returns A operand
+func (i Instruction) A() int {
+	return int(i>>posA) & MaxArgA
+}
+
+// B returns B operand
+func (i Instruction) B() int {
+	return int(i>>posB) & MaxArgB
+}
+
+// C returns C operand
+func (i Instruction) C() int {
+	return int(i>>posC) & MaxArgC
+}
+
+// Bx returns unsigned Bx operand
+func (i Instruction) Bx() int {
+	return int(i>>posBx) & MaxArgBx
+}
+
+// SBx returns signed sBx operand
+func (i Instruction) SBx() int {
+	return i.Bx() - MaxArgSBx
+}
@@ -0,0 +1,277 @@
+package chunk
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+type decoder struct {
+	data  []byte
+	pos   int
+	h     Header
+	order binary.ByteOrder
+	err   error
+}
+
+// Decode parses a Lua 5.0 chunk, as written by luac or zbc_unpack
+func Decode(r io.Reader) (*Chunk, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	d := decoder{data: data}
+	if err := d.readHeader(); err != nil {
+		return nil, err
+	}
+
+	main := d.readFunction("")
+	if d.err != nil {
+		return nil, d.err
+	}
+	return &Chunk{Header: d.h, Main: main}, nil
+}
+
+func (d *decoder) fail(format string, args ...any) {
+	if d.err == nil {
+		d.err = FormatError(fmt.Sprintf(format, args...))
+	}
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data)-d.pos {
+		d.fail("unexpected end of data at 0x%x", d.pos)
+		return nil
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) byte() byte {
+	b := d.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+// uint reads an unsigned number of given size in chunk byte order
+func (d *decoder) uint(size uint8) uint64 {
+	b := d.bytes(int(size))
+	if b == nil {
+		return 0
+	}
+	var v uint64
+	for i := range b {
+		if d.h.LittleEndian {
+			v |= uint64(b[i]) << (8 * i)
+		} else {
+			v = v<<8 | uint64(b[i])
+		}
+	}
+	return v
+}
+
+// int reads a C int
+func (d *decoder) int() int {
+	v := d.uint(d.h.SizeInt)
+	shift := 64 - 8*uint(d.h.SizeInt)
+	return int(int64(v<<shift) >> shift)
+}
+
+// count reads a length of a vector, which elements take at least elemSize bytes each
+func (d *decoder) count(elemSize int) int {
+	start := d.pos
+	n := d.int()
+	if d.err == nil && (n < 0 || n > (len(d.data)-d.pos)/elemSize) {
+		d.fail("invalid count %d at 0x%x", n, start)
+		return 0
+	}
+	return n
+}
+
+// string reads a size_t prefixed string, the stored length includes terminating zero
+func (d *decoder) string() (string, bool) {
+	start := d.pos
+	size := d.uint(d.h.SizeSizeT)
+	if size == 0 {
+		return "", false
+	}
+	if size > uint64(len(d.data)-d.pos) {
+		d.fail("invalid string size %d at 0x%x", size, start)
+		return "", false
+	}
+	b := d.bytes(int(size))
+	if b == nil {
+		return "", false
+	}
+	return string(b[:len(b)-1]), true
+}
+
+func (d *decoder) number() float64 {
+	b := d.bytes(int(d.h.SizeNumber))
+	if b == nil {
+		return 0
+	}
+	return decodeNumber(d.h, d.order, b)
+}
+
+func decodeNumber(h Header, order binary.ByteOrder, b []byte) float64 {
+	switch {
+	case h.IntegralNumber && len(b) == 4:
+		return float64(int32(order.Uint32(b)))
+	case h.IntegralNumber && len(b) == 8:
+		return float64(int64(order.Uint64(b)))
+	case len(b) == 4:
+		return float64(math.Float32frombits(order.Uint32(b)))
+	case len(b) == 8:
+		return math.Float64frombits(order.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) readHeader() error {
+	sig := d.bytes(len(signature))
+	if d.err != nil || string(sig) != signature {
+		return FormatError("not a Lua chunk")
+	}
+
+	d.h.Version = d.byte()
+	if d.err == nil && d.h.Version != Version {
+		return FormatError(fmt.Sprintf("unsupported Lua version %x.%x", d.h.Version>>4, d.h.Version&0xF))
+	}
+
+	endianness := d.byte()
+	d.h.LittleEndian = endianness == 1
+	d.h.SizeInt = d.byte()
+	d.h.SizeSizeT = d.byte()
+	d.h.SizeInstruction = d.byte()
+	d.h.SizeOp = d.byte()
+	d.h.SizeA = d.byte()
+	d.h.SizeB = d.byte()
+	d.h.SizeC = d.byte()
+	d.h.SizeNumber = d.byte()
+	if d.err != nil {
+		return d.err
+	}
+
+	d.order = binary.BigEndian
+	if d.h.LittleEndian {
+		d.order = binary.LittleEndian
+	}
+
+	if endianness > 1 {
+		return FormatError(fmt.Sprintf("invalid endianness flag %d", endianness))
+	}
+	if d.h.SizeInt < 1 || d.h.SizeInt > 8 {
+		return FormatError(fmt.Sprintf("unsupported int size %d", d.h.SizeInt))
+	}
+	if d.h.SizeSizeT < 1 || d.h.SizeSizeT > 8 {
+		return FormatError(fmt.Sprintf("unsupported size_t size %d", d.h.SizeSizeT))
+	}
+	if d.h.SizeInstruction != 4 || d.h.SizeOp != sizeOp || d.h.SizeA != sizeA || d.h.SizeB != sizeB || d.h.SizeC != sizeC {
+		return FormatError(fmt.Sprintf("unsupported instruction layout %d:%d,%d,%d,%d",
+			d.h.SizeInstruction, d.h.SizeOp, d.h.SizeA, d.h.SizeB, d.h.SizeC))
+	}
+	if d.h.SizeNumber != 4 && d.h.SizeNumber != 8 {
+		return FormatError(fmt.Sprintf("unsupported number size %d", d.h.SizeNumber))
+	}
+
+	// lua_Number may be a float or an integer, the test number tells which one
+	b := d.bytes(int(d.h.SizeNumber))
+	if d.err != nil {
+		return d.err
+	}
+	if decodeNumber(d.h, d.order, b) != testNumberAs(d.h) {
+		d.h.IntegralNumber = true
+		if decodeNumber(d.h, d.order, b) != testNumberAs(d.h) {
+			return FormatError("unknown number format")
+		}
+	}
+	return nil
+}
+
+// testNumberAs returns the test number, as it's stored with number format from h
+func testNumberAs(h Header) float64 {
+	switch {
+	case h.IntegralNumber:
+		return math.Trunc(testNumber)
+	case h.SizeNumber == 4:
+		return float64(float32(testNumber))
+	}
+	return testNumber
+}
+
+func (d *decoder) readFunction(parentSource string) *Function {
+	f := &Function{}
+	source, ok := d.string()
+	if !ok {
+		source = parentSource
+	}
+	f.Source = source
+	f.LineDefined = d.int()
+	f.NumUpvalues = d.byte()
+	f.NumParams = d.byte()
+	f.IsVararg = d.byte() != 0
+	f.MaxStackSize = d.byte()
+
+	n := d.count(int(d.h.SizeInt))
+	f.LineInfo = make([]int, n)
+	for i := range f.LineInfo {
+		f.LineInfo[i] = d.int()
+	}
+
+	n = d.count(int(d.h.SizeSizeT) + 2*int(d.h.SizeInt))
+	f.Locals = make([]Local, n)
+	for i := range f.Locals {
+		f.Locals[i].Name, _ = d.string()
+		f.Locals[i].StartPC = d.int()
+		f.Locals[i].EndPC = d.int()
+	}
+
+	n = d.count(int(d.h.SizeSizeT))
+	f.Upvalues = make([]string, n)
+	for i := range f.Upvalues {
+		f.Upvalues[i], _ = d.string()
+	}
+
+	n = d.count(1)
+	f.Constants = make([]Constant, n)
+	for i := range f.Constants {
+		start := d.pos
+		k := Constant{Type: ConstantType(d.byte())}
+		switch k.Type {
+		case TypeNil:
+		case TypeNumber:
+			k.Number = d.number()
+		case TypeString:
+			k.Text, _ = d.string()
+		default:
+			d.fail("unknown constant type %d at 0x%x", k.Type, start)
+		}
+		f.Constants[i] = k
+	}
+
+	// the smallest function has no source, and all its vectors are empty
+	n = d.count(int(d.h.SizeSizeT) + 7*int(d.h.SizeInt) + 4)
+	f.Functions = make([]*Function, n)
+	for i := range f.Functions {
+		if d.err != nil {
+			return f
+		}
+		f.Functions[i] = d.readFunction(f.Source)
+	}
+
+	n = d.count(int(d.h.SizeInstruction))
+	f.Code = make([]Instruction, n)
+	for i := range f.Code {
+		f.Code[i] = Instruction(d.uint(d.h.SizeInstruction))
+	}
+	return f
+}
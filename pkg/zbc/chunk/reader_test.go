@@ -0,0 +1,211 @@
+package chunk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// builder assembles chunks by hand, the way luac 5.0 dumps them
+type builder struct {
+	buf bytes.Buffer
+	h   Header
+}
+
+func newBuilder(h Header) *builder {
+	b := &builder{h: h}
+	endianness := byte(0)
+	if h.LittleEndian {
+		endianness = 1
+	}
+	b.buf.WriteString(signature)
+	b.buf.Write([]byte{h.Version, endianness, h.SizeInt, h.SizeSizeT, h.SizeInstruction,
+		h.SizeOp, h.SizeA, h.SizeB, h.SizeC, h.SizeNumber})
+	b.number(testNumberAs(h))
+	return b
+}
+
+func (b *builder) uint(size uint8, v uint64) {
+	tmp := make([]byte, 8)
+	if b.h.LittleEndian {
+		binary.LittleEndian.PutUint64(tmp, v)
+		b.buf.Write(tmp[:size])
+	} else {
+		binary.BigEndian.PutUint64(tmp, v)
+		b.buf.Write(tmp[8-size:])
+	}
+}
+
+func (b *builder) int(v int) {
+	b.uint(b.h.SizeInt, uint64(v))
+}
+
+func (b *builder) string(s string) {
+	b.uint(b.h.SizeSizeT, uint64(len(s)+1))
+	b.buf.WriteString(s)
+	b.buf.WriteByte(0)
+}
+
+func (b *builder) number(n float64) {
+	switch {
+	case b.h.IntegralNumber && b.h.SizeNumber == 4:
+		b.uint(4, uint64(uint32(int32(n))))
+	case b.h.IntegralNumber:
+		b.uint(8, uint64(int64(n)))
+	case b.h.SizeNumber == 4:
+		b.uint(4, uint64(math.Float32bits(float32(n))))
+	default:
+		b.uint(8, math.Float64bits(n))
+	}
+}
+
+// hello writes main function of `print("hello", 2)`
+func (b *builder) hello() {
+	b.string("@hello.lua")
+	b.int(0)                        // line defined
+	b.buf.Write([]byte{0, 0, 0, 3}) // upvalues, params, vararg, stack size
+	b.int(4)
+	for range 4 {
+		b.int(1)
+	}
+	b.int(0) // locals
+	b.int(0) // upvalues
+	b.int(3)
+	b.buf.WriteByte(byte(TypeString))
+	b.string("print")
+	b.buf.WriteByte(byte(TypeString))
+	b.string("hello")
+	b.buf.WriteByte(byte(TypeNumber))
+	b.number(2)
+	b.int(0) // functions
+	b.int(5)
+	for _, i := range helloCode {
+		b.uint(4, uint64(i))
+	}
+}
+
+var helloCode = []Instruction{
+	NewABx(OpGetGlobal, 0, 0),
+	NewABx(OpLoadK, 1, 1),
+	NewABx(OpLoadK, 2, 2),
+	NewABC(OpCall, 0, 3, 1),
+	NewABC(OpReturn, 0, 1, 0),
+}
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+	bigEndian := DefaultHeader()
+	bigEndian.LittleEndian = false
+	float32Numbers := DefaultHeader()
+	float32Numbers.SizeNumber = 4
+	integralNumbers := DefaultHeader()
+	integralNumbers.SizeNumber = 4
+	integralNumbers.IntegralNumber = true
+	wideInts := DefaultHeader()
+	wideInts.SizeInt = 8
+	wideInts.SizeSizeT = 8
+
+	cases := []struct {
+		name   string
+		header Header
+	}{
+		{name: "default", header: DefaultHeader()},
+		{name: "big endian", header: bigEndian},
+		{name: "float32 numbers", header: float32Numbers},
+		{name: "integral numbers", header: integralNumbers},
+		{name: "64-bit int and size_t", header: wideInts},
+	}
+
+	for _, tt := range cases {
+		header := tt.header
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			b := newBuilder(header)
+			b.hello()
+
+			c, err := Decode(&b.buf)
+			require.NoError(t, err)
+			require.Equal(t, header, c.Header)
+			require.Equal(t, &Function{
+				Source:       "@hello.lua",
+				MaxStackSize: 3,
+				LineInfo:     []int{1, 1, 1, 1},
+				Locals:       []Local{},
+				Upvalues:     []string{},
+				Constants:    []Constant{StringConstant("print"), StringConstant("hello"), NumberConstant(2)},
+				Functions:    []*Function{},
+				Code:         helloCode,
+			}, c.Main)
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	t.Parallel()
+	b := newBuilder(DefaultHeader())
+	b.hello()
+	valid := b.buf.Bytes()
+
+	lua51 := bytes.Clone(valid)
+	lua51[4] = 0x51
+
+	cases := []struct {
+		name          string
+		data          []byte
+		expectedError error
+	}{
+		{
+			name:          "not a chunk",
+			data:          []byte("\x1bZCS\x0a\x1a"),
+			expectedError: FormatError("not a Lua chunk"),
+		},
+		{
+			name:          "unsupported version",
+			data:          lua51,
+			expectedError: FormatError("unsupported Lua version 5.1"),
+		},
+		{
+			name:          "truncated code",
+			data:          valid[:len(valid)-2],
+			expectedError: FormatError("invalid count 5 at 0x70"),
+		},
+		{
+			name:          "truncated header",
+			data:          valid[:10],
+			expectedError: FormatError("unexpected end of data at 0xa"),
+		},
+	}
+
+	for _, tt := range cases {
+		data := tt.data
+		expectedError := tt.expectedError
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := Decode(bytes.NewReader(data))
+			require.Equal(t, expectedError, err)
+		})
+	}
+}
+
+func TestInstruction(t *testing.T) {
+	t.Parallel()
+	i := NewABC(OpSetTable, 1, MaxStack+3, 7)
+	require.Equal(t, OpSetTable, i.OpCode())
+	require.Equal(t, 1, i.A())
+	require.Equal(t, MaxStack+3, i.B())
+	require.Equal(t, 7, i.C())
+	require.True(t, IsConstant(i.B()))
+	require.Equal(t, 3, ConstantIndex(i.B()))
+
+	j := NewAsBx(OpJmp, 0, -5)
+	require.Equal(t, OpJmp, j.OpCode())
+	require.Equal(t, -5, j.SBx())
+
+	op, ok := LookupOpCode("TFORLOOP")
+	require.True(t, ok)
+	require.Equal(t, OpTForLoop, op)
+	require.Equal(t, "OP_40", OpCode(40).String())
+}
@@ -0,0 +1,146 @@
+package chunk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+type encoder struct {
+	w     *bufio.Writer
+	h     Header
+	order binary.ByteOrder
+}
+
+// Encode writes c in the same layout luac 5.0 uses, with sizes and byte order from c.Header
+func Encode(w io.Writer, c *Chunk) error {
+	h := c.Header
+	if h.SizeInt < 1 || h.SizeInt > 8 || h.SizeSizeT < 1 || h.SizeSizeT > 8 {
+		return FormatError(fmt.Sprintf("unsupported int and size_t sizes %d, %d", h.SizeInt, h.SizeSizeT))
+	}
+	if h.SizeInstruction != 4 || h.SizeOp != sizeOp || h.SizeA != sizeA || h.SizeB != sizeB || h.SizeC != sizeC {
+		return FormatError("unsupported instruction layout")
+	}
+	if h.SizeNumber != 4 && h.SizeNumber != 8 {
+		return FormatError(fmt.Sprintf("unsupported number size %d", h.SizeNumber))
+	}
+
+	err := c.Walk(func(id string, f *Function) error {
+		for i, k := range f.Constants {
+			if k.Type != TypeNil && k.Type != TypeNumber && k.Type != TypeString {
+				return FormatError(fmt.Sprintf("function %s: constant %d has unsupported type %d", id, i, k.Type))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	e := encoder{w: bufio.NewWriter(w), h: h, order: binary.BigEndian}
+	if h.LittleEndian {
+		e.order = binary.LittleEndian
+	}
+
+	e.w.WriteString(signature)
+	endianness := byte(0)
+	if h.LittleEndian {
+		endianness = 1
+	}
+	e.w.Write([]byte{h.Version, endianness, h.SizeInt, h.SizeSizeT, h.SizeInstruction,
+		h.SizeOp, h.SizeA, h.SizeB, h.SizeC, h.SizeNumber})
+	e.number(testNumberAs(h))
+
+	e.function(c.Main, nil)
+	return e.w.Flush()
+}
+
+func (e *encoder) uint(size uint8, v uint64) {
+	for i := range int(size) {
+		shift := 8 * i
+		if !e.h.LittleEndian {
+			shift = 8 * (int(size) - 1 - i)
+		}
+		e.w.WriteByte(byte(v >> shift))
+	}
+}
+
+func (e *encoder) int(v int) {
+	e.uint(e.h.SizeInt, uint64(v))
+}
+
+func (e *encoder) string(s string) {
+	e.uint(e.h.SizeSizeT, uint64(len(s)+1))
+	e.w.WriteString(s)
+	e.w.WriteByte(0)
+}
+
+func (e *encoder) number(n float64) {
+	b := make([]byte, e.h.SizeNumber)
+	switch {
+	case e.h.IntegralNumber && e.h.SizeNumber == 4:
+		e.order.PutUint32(b, uint32(int32(n)))
+	case e.h.IntegralNumber:
+		e.order.PutUint64(b, uint64(int64(n)))
+	case e.h.SizeNumber == 4:
+		e.order.PutUint32(b, math.Float32bits(float32(n)))
+	default:
+		e.order.PutUint64(b, math.Float64bits(n))
+	}
+	e.w.Write(b)
+}
+
+func (e *encoder) function(f, parent *Function) {
+	// like luac, nested functions don't repeat source of their parent
+	if parent != nil && parent.Source == f.Source {
+		e.uint(e.h.SizeSizeT, 0)
+	} else {
+		e.string(f.Source)
+	}
+	e.int(f.LineDefined)
+	isVararg := byte(0)
+	if f.IsVararg {
+		isVararg = 1
+	}
+	e.w.Write([]byte{f.NumUpvalues, f.NumParams, isVararg, f.MaxStackSize})
+
+	e.int(len(f.LineInfo))
+	for _, line := range f.LineInfo {
+		e.int(line)
+	}
+
+	e.int(len(f.Locals))
+	for _, l := range f.Locals {
+		e.string(l.Name)
+		e.int(l.StartPC)
+		e.int(l.EndPC)
+	}
+
+	e.int(len(f.Upvalues))
+	for _, name := range f.Upvalues {
+		e.string(name)
+	}
+
+	e.int(len(f.Constants))
+	for _, k := range f.Constants {
+		e.w.WriteByte(byte(k.Type))
+		switch k.Type {
+		case TypeNumber:
+			e.number(k.Number)
+		case TypeString:
+			e.string(k.Text)
+		}
+	}
+
+	e.int(len(f.Functions))
+	for _, p := range f.Functions {
+		e.function(p, f)
+	}
+
+	e.int(len(f.Code))
+	for _, i := range f.Code {
+		e.uint(e.h.SizeInstruction, uint64(i))
+	}
+}
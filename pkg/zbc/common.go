@@ -2,3 +2,23 @@
 package zbc
 
 const packedHeader = "\x1BZCS\x0A\x1A"
+
+// headerSize is the offset of zlib data in a packed file
+const headerSize = 0x10
+
+// Header describes header of a packed .zbc file
+type Header struct {
+	// Magic is always "\x1BZCS\x0A\x1A"
+	Magic [6]byte
+	// Unknown holds bytes 0x6-0x7, their meaning is not known yet
+	Unknown [2]byte
+	// UnpackedSize is the length of bytecode after unpacking
+	UnpackedSize uint32
+	// PackedSize is the length of zlib stream following the header
+	PackedSize uint32
+}
+
+// A FormatError reports that the input is not a valid Gamewave bytecode file.
+type FormatError string
+
+func (e FormatError) Error() string { return "gamewave zbc error: " + string(e) }
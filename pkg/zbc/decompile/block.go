@@ -0,0 +1,182 @@
+package decompile
+
+import (
+	"sort"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+)
+
+// blockState holds statements of a block and values of temporary registers,
+// that weren't used yet
+type blockState struct {
+	fs      *funcState
+	stmts   []stmt
+	pending map[int]expr
+	// tables assigned to variables by the last statement, that may still be filled by their constructor
+	tables map[int]*table
+	// exprMode blocks compute a single value, any statement makes them invalid
+	exprMode bool
+	failed   bool
+	// until is set when the block ended with condition of a repeat loop
+	until expr
+}
+
+func (fs *funcState) newBlock(exprMode bool) *blockState {
+	return &blockState{fs: fs, pending: map[int]expr{}, exprMode: exprMode}
+}
+
+func (b *blockState) add(s stmt) {
+	if b.exprMode {
+		b.failed = true
+	}
+	b.tables = nil
+	b.stmts = append(b.stmts, s)
+}
+
+// constructor returns table being constructed in register r
+func (b *blockState) constructor(r int) *table {
+	if t, ok := b.pending[r].(*table); ok {
+		return t
+	}
+	return b.tables[r]
+}
+
+func (b *blockState) take(r int) (expr, bool) {
+	e, ok := b.pending[r]
+	delete(b.pending, r)
+	return e, ok
+}
+
+// read returns value of register r at pc
+func (b *blockState) read(r, pc int) expr {
+	if e, ok := b.take(r); ok {
+		return e
+	}
+	fs := b.fs
+	if fs.localAt(r, pc) < 0 && fs.loopVars[r] == 0 && !fs.registers[r] {
+		fs.unresolved[r] = true
+	}
+	return name(fs.regName(r, pc))
+}
+
+// rk returns value of a register or constant operand
+func (b *blockState) rk(x, pc int) expr {
+	if chunk.IsConstant(x) {
+		return b.fs.constant(chunk.ConstantIndex(x))
+	}
+	return b.read(x, pc)
+}
+
+// write stores e in register r, written at pc
+func (b *blockState) write(r int, e expr, pc int) {
+	fs := b.fs
+	switch e.(type) {
+	case self, method, placeholder:
+		b.setPending(r, e)
+		return
+	}
+	if b.exprMode {
+		if fs.isVariable(r, pc) {
+			b.failed = true
+		}
+		b.setPending(r, e)
+		return
+	}
+	if l := fs.localAt(r, pc); l >= 0 {
+		local := fs.f.Locals[l]
+		if c, ok := e.(closure); ok && local.StartPC == pc && !fs.declared[l] {
+			fs.declared[l] = true
+			b.add(functionStmt{name: local.Name, local: true, fn: c.fn})
+			return
+		}
+		b.add(assignStmt{target: name(local.Name), value: e})
+	} else if fs.registers[r] && !b.constructorItem(r, pc) {
+		fs.assign(b, name(fs.regName(r, pc)), e)
+	} else {
+		b.setPending(r, e)
+		return
+	}
+	if t, ok := e.(*table); ok {
+		b.tables = map[int]*table{r: t}
+	}
+}
+
+func (b *blockState) setPending(r int, e expr) {
+	if old, ok := b.pending[r]; ok && hasSideEffects(old) && !b.exprMode {
+		b.add(callStmt{old.(*call)})
+	}
+	b.pending[r] = e
+}
+
+// constructorItem reports whether register r written at pc holds a list item of a table being constructed
+func (b *blockState) constructorItem(r, pc int) bool {
+	for t := range b.tables {
+		if t < r && b.fs.setList(t, pc) >= r {
+			return true
+		}
+	}
+	for t, e := range b.pending {
+		if _, ok := e.(*table); ok && t < r && b.fs.setList(t, pc) >= r {
+			return true
+		}
+	}
+	return false
+}
+
+// top returns the last register of a list ending with a call returning multiple results
+func (b *blockState) top(base int) int {
+	last := base
+	for r, e := range b.pending {
+		if c, ok := e.(*call); ok && c.multret && r > last {
+			last = r
+		}
+	}
+	if last == base {
+		for r := range b.pending {
+			if r > last {
+				last = r
+			}
+		}
+	}
+	return last
+}
+
+// spill assigns pending values to their registers, so they aren't folded across code
+// that wasn't decompiled. The registers are kept as variables by the following passes
+func (b *blockState) spill(pc int) {
+	registers := make([]int, 0, len(b.pending))
+	for r := range b.pending {
+		registers = append(registers, r)
+	}
+	sort.Ints(registers)
+	for _, r := range registers {
+		e := b.pending[r]
+		switch v := e.(type) {
+		case self, placeholder:
+			continue
+		case method:
+			e = index(v)
+		}
+		b.fs.unresolved[r] = true
+		b.add(assignStmt{target: name(b.fs.regName(r, pc)), value: e})
+	}
+	b.pending = map[int]expr{}
+}
+
+// flush adds calls, whose results were never used, as statements
+func (b *blockState) flush() {
+	if b.exprMode {
+		return
+	}
+	registers := make([]int, 0, len(b.pending))
+	for r := range b.pending {
+		registers = append(registers, r)
+	}
+	sort.Ints(registers)
+	for _, r := range registers {
+		if c, ok := b.pending[r].(*call); ok {
+			b.add(callStmt{c})
+		}
+	}
+	b.pending = map[int]expr{}
+}
@@ -0,0 +1,219 @@
+package decompile
+
+import (
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+)
+
+// pair is a test instruction with the jump following it
+type pair struct {
+	// start is the first instruction computing operands of the test
+	start  int
+	test   int
+	target int
+}
+
+func (fs *funcState) isTestJump(pc int) bool {
+	code := fs.f.Code
+	if pc < 0 || pc+1 >= len(code) || code[pc+1].OpCode() != chunk.OpJmp {
+		return false
+	}
+	switch code[pc].OpCode() {
+	case chunk.OpEq, chunk.OpLt, chunk.OpLe, chunk.OpTest:
+		return true
+	}
+	return false
+}
+
+// isPure reports whether instruction at pc only computes a temporary value
+func (fs *funcState) isPure(pc int) bool {
+	i := fs.f.Code[pc]
+	last := i.A()
+	switch i.OpCode() {
+	case chunk.OpMove, chunk.OpLoadK, chunk.OpGetUpval, chunk.OpGetGlobal, chunk.OpGetTable, chunk.OpNewTable,
+		chunk.OpAdd, chunk.OpSub, chunk.OpMul, chunk.OpDiv, chunk.OpPow, chunk.OpUnm, chunk.OpNot, chunk.OpConcat:
+	case chunk.OpLoadBool:
+		if i.C() != 0 {
+			return false
+		}
+	case chunk.OpLoadNil:
+		last = i.B()
+	case chunk.OpSelf:
+		last++
+	case chunk.OpCall:
+		if i.C() == 1 {
+			return false
+		}
+		last += i.C() - 2
+	default:
+		return false
+	}
+	for r := i.A(); r <= last; r++ {
+		if fs.isVariable(r, pc) || fs.isVariable(r, pc+1) {
+			return false
+		}
+	}
+	return true
+}
+
+// chainAt returns the longest sequence of tests starting at pc, separated only by code computing their operands
+func (fs *funcState) chainAt(pc, end int) []pair {
+	var pairs []pair
+	start := pc
+	for k := pc; k+1 < end && fs.isTestJump(k); {
+		pairs = append(pairs, pair{start: start, test: k, target: fs.jumpTarget(k + 1)})
+		k += 2
+		start = k
+		for k < end && fs.isPure(k) {
+			k++
+		}
+	}
+	return pairs
+}
+
+// pairsIn returns tests from code between start and last, which has to be the last jump,
+// or nil if the code holds anything else
+func (fs *funcState) pairsIn(start, last int) []pair {
+	var pairs []pair
+	s := start
+	for k := start; k <= last; {
+		if fs.isTestJump(k) {
+			pairs = append(pairs, pair{start: s, test: k, target: fs.jumpTarget(k + 1)})
+			k += 2
+			s = k
+			continue
+		}
+		if !fs.isPure(k) {
+			return nil
+		}
+		k++
+	}
+	if s != last+1 {
+		return nil
+	}
+	return pairs
+}
+
+// validChain reports whether pairs form a single condition, exits are values of the condition
+// at its targets, falling through after the last test has to be one of them
+func validChain(pairs []pair, exits map[int]expr) bool {
+	if len(pairs) == 0 {
+		return false
+	}
+	if _, ok := exits[pairs[len(pairs)-1].test+2]; !ok {
+		return false
+	}
+	for m, p := range pairs {
+		if _, ok := exits[p.target]; ok {
+			continue
+		}
+		if !isChainStart(pairs[m+1:], p.target) {
+			return false
+		}
+	}
+	return true
+}
+
+func isChainStart(pairs []pair, pc int) bool {
+	for _, p := range pairs {
+		if p.start == pc {
+			return true
+		}
+	}
+	return false
+}
+
+// condition evaluates tests and builds expression, that has value of exits at the reached target
+func (fs *funcState) condition(b *blockState, pairs []pair, exits map[int]expr) expr {
+	jumps := make([]expr, len(pairs))
+	for m, p := range pairs {
+		for k := p.start; k < p.test; k++ {
+			fs.instruction(b, k)
+		}
+		jumps[m] = fs.testExpr(b, p.test)
+	}
+
+	n := len(pairs)
+	conds := make([]expr, n+1)
+	conds[n] = exits[pairs[n-1].test+2]
+	for m := n - 1; m >= 0; m-- {
+		taken, ok := exits[pairs[m].target]
+		for k := m + 1; !ok && k < n; k++ {
+			if pairs[k].start == pairs[m].target {
+				taken, ok = conds[k], true
+			}
+		}
+		conds[m] = choose(jumps[m], taken, conds[m+1])
+	}
+	return conds[0]
+}
+
+// testExpr returns expression, that is true when the jump following the test at pc is taken
+func (fs *funcState) testExpr(b *blockState, pc int) expr {
+	i := fs.f.Code[pc]
+	var e expr
+	switch i.OpCode() {
+	case chunk.OpEq:
+		e = binop{"==", b.rk(i.B(), pc), b.rk(i.C(), pc)}
+	case chunk.OpLt:
+		e = binop{"<", b.rk(i.B(), pc), b.rk(i.C(), pc)}
+	case chunk.OpLe:
+		e = binop{"<=", b.rk(i.B(), pc), b.rk(i.C(), pc)}
+	case chunk.OpTest:
+		e = b.read(i.B(), pc)
+		if i.C() == 0 {
+			return not(e)
+		}
+		return e
+	}
+	if i.A() == 0 {
+		return not(e)
+	}
+	return e
+}
+
+// choose returns expression equal to taken when j is true, and to next otherwise
+func choose(j, taken, next expr) expr {
+	t, takenBool := taken.(boolean)
+	n, nextBool := next.(boolean)
+	switch {
+	case takenBool && nextBool:
+		if t == n {
+			return t
+		}
+		if t {
+			return j
+		}
+		return not(j)
+	case takenBool && bool(t):
+		return binop{"or", j, next}
+	case takenBool:
+		return binop{"and", not(j), next}
+	case nextBool && bool(n):
+		return binop{"or", not(j), taken}
+	case nextBool:
+		return binop{"and", j, taken}
+	}
+	// (a or b) and c, (a and b) or c
+	if y, ok := splitRight(next, "and", taken); ok {
+		return binop{"and", binop{"or", j, y}, taken}
+	}
+	if y, ok := splitRight(next, "or", taken); ok {
+		return binop{"or", binop{"and", not(j), y}, taken}
+	}
+	return binop{"or", binop{"and", j, taken}, binop{"and", not(j), next}}
+}
+
+// splitRight returns e without its rightmost operand, if e is a chain of op ending with target
+func splitRight(e expr, op string, target expr) (expr, bool) {
+	v, ok := e.(binop)
+	if !ok || v.op != op {
+		return nil, false
+	}
+	if v.r == target {
+		return v.l, true
+	}
+	if rest, ok := splitRight(v.r, op, target); ok {
+		return binop{op, v.l, rest}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,30 @@
+// Package decompile reconstructs Lua source from Lua 5.0 chunks used by Gamewave games.
+//
+// Expressions are folded from temporary registers, and control flow is recovered
+// by recognizing code generated by luac 5.0 for if, while, repeat and for statements.
+// Names of locals come from debug information; in stripped functions registers
+// that can't be folded are kept as variables named after them.
+// Code that isn't recognized is kept as disassembly in comments.
+package decompile
+
+import (
+	"io"
+	"strings"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+)
+
+// Decompile writes Lua source of c to w, fileName is mentioned in the header comment
+func Decompile(w io.Writer, c *chunk.Chunk, fileName string) error {
+	out := &writer{}
+	out.line(0, "-- decompiled from ", fileName)
+	if source := strings.TrimLeft(c.Main.Source, "@="); source != "" {
+		out.line(0, "-- original source: ", source)
+	}
+	out.line(0)
+
+	fn := decompileFunction(c.Main, nil, 0, map[string]*function{})
+	out.body(fn, 0)
+	_, err := io.WriteString(w, out.String())
+	return err
+}
@@ -0,0 +1,300 @@
+package decompile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/asm"
+	"github.com/stretchr/testify/require"
+)
+
+const header = ".chunk 5.0 little int=4 size_t=4 instruction=4 number=8 float\n"
+
+const tableAndLoop = `
+.function main
+.source "@test.lua"
+.maxstack 9
+.const K0 1
+.const K1 2
+.const K2 "x"
+.const K3 "y"
+.const K4 3
+.const K5 "print"
+.local "t" 6 21
+.local "add" 8 21
+.local "i" 13 19
+.code
+     1 [   1] NEWTABLE   R0 2 1
+     2 [   1] LOADK      R1 K0
+     3 [   1] LOADK      R2 K1
+     4 [   1] SETTABLE   R0 K2 K3
+     5 [   1] SETLIST    R0 1
+     6 [   2] CLOSURE    R1 F0
+     7 [   2] MOVE       0 R0 0
+     8 [   3] LOADK      R2 K0
+     9 [   3] LOADK      R3 K4
+    10 [   3] LOADK      R4 K0
+    11 [   3] SUB        R2 R2 R4
+    12 [   3] JMP        0 @19
+    13 [   4] GETGLOBAL  R5 K5
+    14 [   4] MOVE       R6 R1 0
+    15 [   4] MOVE       R7 R2 0
+    16 [   4] LOADK      R8 K1
+    17 [   4] CALL       R6 3 0
+    18 [   4] CALL       R5 0 1
+    19 [   3] FORLOOP    R2 @13
+    20 [   5] RETURN     R0 1 0
+.end
+
+.function main/0
+.upvalues 1
+.params 2
+.maxstack 4
+.const K0 1
+.local "a" 1 7
+.local "b" 1 7
+.upvalue U0 "t"
+.code
+     1 [   2] ADD        R2 R0 R1
+     2 [   2] GETUPVAL   R3 U0 0
+     3 [   2] GETTABLE   R3 R3 K0
+     4 [   2] ADD        R2 R2 R3
+     5 [   2] RETURN     R2 2 0
+     6 [   2] RETURN     R0 1 0
+.end
+`
+
+const controlFlow = `; function check(n, list)
+;   local total = 0
+;   for k, v in pairs(list) do
+;     if v > n and k ~= "skip" then total = total + v
+;     elseif v == 0 then break
+;     else total = total - 1 end
+;   end
+;   while total > 10 do total = total / 2 end
+;   repeat total = total + 1 until total >= n or done
+;   local ok = total < n
+;   local name = list.name or "none"
+;   return ok, name
+; end
+.function main
+.maxstack 2
+.const K0 "check"
+.code
+     1 CLOSURE    R0 F0
+     2 SETGLOBAL  R0 K0
+     3 RETURN     R0 1 0
+.end
+
+.function main/0
+.params 2
+.maxstack 7
+.const K0 0
+.const K1 "pairs"
+.const K2 "skip"
+.const K3 1
+.const K4 2
+.const K5 10
+.const K6 "done"
+.const K7 "name"
+.const K8 "none"
+.local "n" 1 41
+.local "list" 1 41
+.local "total" 2 41
+.local "(for generator)" 6 19
+.local "(for state)" 6 19
+.local "k" 6 19
+.local "v" 6 19
+.local "ok" 33 41
+.local "name" 37 41
+.code
+     1 LOADK      R2 K0
+     2 GETGLOBAL  R3 K1
+     3 MOVE       R4 R1 0
+     4 CALL       R3 2 5
+     5 TFORPREP   R3 @17
+     6 LT         0 R0 R6
+     7 JMP        0 @12
+     8 EQ         1 R5 K2
+     9 JMP        0 @12
+    10 ADD        R2 R2 R6
+    11 JMP        0 @17
+    12 EQ         0 R6 K0
+    13 JMP        0 @16
+    14 JMP        0 @19
+    15 JMP        0 @17
+    16 SUB        R2 R2 K3
+    17 TFORLOOP   R3 0 1
+    18 JMP        0 @6
+    19 JMP        0 @21
+    20 DIV        R2 R2 K4
+    21 LT         1 K5 R2
+    22 JMP        0 @20
+    23 ADD        R2 R2 K3
+    24 LE         1 R0 R2
+    25 JMP        0 @29
+    26 GETGLOBAL  R3 K6
+    27 TEST       R3 R3 0
+    28 JMP        0 @23
+    29 LT         1 R2 R0
+    30 JMP        0 @32
+    31 LOADBOOL   R3 0 1
+    32 LOADBOOL   R3 1 0
+    33 GETTABLE   R4 R1 K7
+    34 TEST       R4 R4 1
+    35 JMP        0 @37
+    36 LOADK      R4 K8
+    37 MOVE       R5 R3 0
+    38 MOVE       R6 R4 0
+    39 RETURN     R5 3 0
+    40 RETURN     R0 1 0
+.end
+`
+
+const stripped = `; obj:init({size = 2}); if not (obj.name .. "!") then return end
+.function main
+.maxstack 5
+.const K0 "obj"
+.const K1 "init"
+.const K2 "size"
+.const K3 2
+.const K4 "name"
+.const K5 "!"
+.code
+     1 GETGLOBAL  R0 K0
+     2 SELF       R0 R0 K1
+     3 NEWTABLE   R2 0 1
+     4 SETTABLE   R2 K2 K3
+     5 CALL       R0 3 1
+     6 GETGLOBAL  R1 K0
+     7 GETTABLE   R1 R1 K4
+     8 LOADK      R2 K5
+     9 CONCAT     R0 R1 R2
+    10 TEST       R0 R0 1
+    11 JMP        0 @13
+    12 RETURN     R0 1 0
+    13 RETURN     R0 1 0
+.end
+`
+
+const unknownJump = `
+.function main
+.maxstack 2
+.const K0 1
+.const K1 2
+.code
+     1 LOADK      R0 K0
+     2 JMP        0 @4
+     3 LOADK      R0 K1
+     4 RETURN     R0 2 0
+.end
+`
+
+const malformedConcat = `
+.function main
+.maxstack 3
+.code
+     1 CONCAT     R0 R2 R1
+     2 RETURN     R0 2 0
+.end
+`
+
+func TestDecompile(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name     string
+		listing  string
+		expected string
+	}{
+		{
+			name:    "table, local function and for loop",
+			listing: tableAndLoop,
+			expected: `-- decompiled from test.zbc
+-- original source: test.lua
+
+local t = {x = "y", 1, 2}
+local function add(a, b)
+  return a + b + t[1]
+end
+for i = 1, 3 do
+  print(add(i, 2))
+end
+`,
+		},
+		{
+			name:    "control flow",
+			listing: controlFlow,
+			expected: `-- decompiled from test.zbc
+
+function check(n, list)
+  local total = 0
+  for k, v in pairs(list) do
+    if n < v and k ~= "skip" then
+      total = total + v
+    elseif v == 0 then
+      break
+    else
+      total = total - 1
+    end
+  end
+  while 10 < total do
+    total = total / 2
+  end
+  repeat
+    total = total + 1
+  until n <= total or done
+  local ok = total < n
+  local name = list.name or "none"
+  return ok, name
+end
+`,
+		},
+		{
+			name:    "stripped",
+			listing: stripped,
+			expected: `-- decompiled from test.zbc
+
+obj:init({size = 2})
+if not (obj.name .. "!") then
+  return
+end
+`,
+		},
+		{
+			name:    "unknown jump",
+			listing: unknownJump,
+			expected: `-- decompiled from test.zbc
+
+local r0
+r0 = 1
+-- 2 [   -] JMP        0 @4
+-- 3 [   -] LOADK      R0 K1                   ; 2
+return r0
+`,
+		},
+		{
+			name:    "malformed concat",
+			listing: malformedConcat,
+			expected: `-- decompiled from test.zbc
+
+local r0
+-- 1 [   -] CONCAT     R0 R2 R1
+return r0
+`,
+		},
+	}
+
+	for _, tt := range cases {
+		listing := header + tt.listing
+		expected := tt.expected
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c, err := asm.Assemble(strings.NewReader(listing))
+			require.NoError(t, err)
+			out := bytes.Buffer{}
+			require.NoError(t, Decompile(&out, c, "test.zbc"))
+			require.Equal(t, expected, out.String())
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package decompile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+)
+
+// operator precedence, from the weakest binding
+const (
+	precOr = iota + 1
+	precAnd
+	precCompare
+	precConcat
+	precAdd
+	precMul
+	precUnary
+	precPow
+	precAtom = 100
+)
+
+type expr interface {
+	prec() int
+	format(indent int) string
+}
+
+// constant is a nil, number or string value
+type constant struct {
+	k chunk.Constant
+}
+
+func (constant) prec() int { return precAtom }
+func (e constant) format(int) string {
+	if e.k.Type == chunk.TypeString {
+		return quote(e.k.Text)
+	}
+	return e.k.String()
+}
+
+var nilValue = constant{chunk.NilConstant()}
+
+func isNil(e expr) bool {
+	k, ok := e.(constant)
+	return ok && k.k.Type == chunk.TypeNil
+}
+
+type boolean bool
+
+func (boolean) prec() int { return precAtom }
+func (e boolean) format(int) string {
+	if e {
+		return "true"
+	}
+	return "false"
+}
+
+// name is a local, an upvalue or a global variable
+type name string
+
+func (name) prec() int { return precAtom }
+func (e name) format(int) string {
+	return string(e)
+}
+
+// global returns expression reading a global variable
+func global(s string) expr {
+	if isIdentifier(s) {
+		return name(s)
+	}
+	return index{obj: name("_G"), key: constant{chunk.StringConstant(s)}}
+}
+
+type index struct {
+	obj expr
+	key expr
+}
+
+func (index) prec() int { return precAtom }
+func (e index) format(indent int) string {
+	if k, ok := e.key.(constant); ok && k.k.Type == chunk.TypeString && isIdentifier(k.k.Text) {
+		return formatPrefix(e.obj, indent) + "." + k.k.Text
+	}
+	return formatPrefix(e.obj, indent) + "[" + e.key.format(indent) + "]"
+}
+
+// formatPrefix wraps expressions, that can't be indexed or called directly
+func formatPrefix(e expr, indent int) string {
+	switch e.(type) {
+	case name, index, *call:
+		return e.format(indent)
+	}
+	return "(" + e.format(indent) + ")"
+}
+
+// method is a result of SELF instruction, it's only valid as a called function
+type method struct {
+	obj expr
+	key expr
+}
+
+func (method) prec() int { return precAtom }
+func (e method) format(indent int) string {
+	return index(e).format(indent)
+}
+
+// self is the hidden first argument of a method call
+type self struct{}
+
+func (self) prec() int         { return precAtom }
+func (self) format(int) string { return "self" }
+
+type call struct {
+	fn   expr
+	args []expr
+	// methodName is set for obj:name() calls, fn is the object then
+	methodName string
+	multret    bool
+}
+
+func (*call) prec() int { return precAtom }
+func (e *call) format(indent int) string {
+	args := formatList(e.args, indent)
+	if e.methodName != "" {
+		return formatPrefix(e.fn, indent) + ":" + e.methodName + "(" + args + ")"
+	}
+	return formatPrefix(e.fn, indent) + "(" + args + ")"
+}
+
+// placeholder stands for additional results of a call, that are assigned together with it
+type placeholder struct {
+	n int
+}
+
+func (placeholder) prec() int { return precAtom }
+func (e placeholder) format(int) string {
+	return fmt.Sprintf("nil --[[ result %d of a call ]]", e.n)
+}
+
+type binop struct {
+	op   string
+	l, r expr
+}
+
+var binopPrec = map[string]int{
+	"or": precOr, "and": precAnd,
+	"==": precCompare, "~=": precCompare, "<": precCompare, "<=": precCompare, ">": precCompare, ">=": precCompare,
+	"..": precConcat,
+	"+":  precAdd, "-": precAdd,
+	"*": precMul, "/": precMul,
+	"^": precPow,
+}
+
+func (e binop) prec() int { return binopPrec[e.op] }
+func (e binop) format(indent int) string {
+	p := e.prec()
+	rightAssoc := e.op == ".." || e.op == "^"
+	l := e.l.format(indent)
+	if e.l.prec() < p || (e.l.prec() == p && rightAssoc) {
+		l = "(" + l + ")"
+	}
+	r := e.r.format(indent)
+	if e.r.prec() < p || (e.r.prec() == p && !rightAssoc) {
+		r = "(" + r + ")"
+	}
+	return l + " " + e.op + " " + r
+}
+
+type unop struct {
+	op string
+	e  expr
+}
+
+func (unop) prec() int { return precUnary }
+func (e unop) format(indent int) string {
+	s := e.e.format(indent)
+	if e.e.prec() < precUnary {
+		s = "(" + s + ")"
+	}
+	if e.op == "not" {
+		return "not " + s
+	}
+	// avoid starting a comment with two minus signs
+	if strings.HasPrefix(s, "-") {
+		return e.op + " " + s
+	}
+	return e.op + s
+}
+
+// not returns logical negation of e, simplifying it where possible
+func not(e expr) expr {
+	switch v := e.(type) {
+	case boolean:
+		return !v
+	case unop:
+		if v.op == "not" {
+			return v.e
+		}
+	case binop:
+		switch v.op {
+		case "==":
+			return binop{"~=", v.l, v.r}
+		case "~=":
+			return binop{"==", v.l, v.r}
+		}
+	}
+	return unop{"not", e}
+}
+
+type tableItem struct {
+	// key is nil for list items
+	key   expr
+	value expr
+}
+
+type table struct {
+	items []tableItem
+}
+
+func (*table) prec() int { return precAtom }
+func (e *table) format(indent int) string {
+	if len(e.items) == 0 {
+		return "{}"
+	}
+	items := make([]string, len(e.items))
+	for i, item := range e.items {
+		value := item.value.format(indent + 1)
+		switch {
+		case item.key == nil:
+			items[i] = value
+		case isIdentifierKey(item.key):
+			items[i] = item.key.(constant).k.Text + " = " + value
+		default:
+			items[i] = "[" + item.key.format(indent+1) + "] = " + value
+		}
+	}
+	oneLine := "{" + strings.Join(items, ", ") + "}"
+	if len(oneLine) <= 80 && !strings.Contains(oneLine, "\n") {
+		return oneLine
+	}
+	pad := strings.Repeat(indentation, indent+1)
+	return "{\n" + pad + strings.Join(items, ",\n"+pad) + "\n" + strings.Repeat(indentation, indent) + "}"
+}
+
+// closure is a nested function
+type closure struct {
+	fn *function
+}
+
+func (closure) prec() int { return precAtom }
+func (e closure) format(indent int) string {
+	w := &writer{}
+	w.function("function", e.fn, indent)
+	return strings.TrimSuffix(strings.TrimPrefix(w.String(), strings.Repeat(indentation, indent)), "\n")
+}
+
+// raw is text of an expression that couldn't be decompiled
+type raw string
+
+func (raw) prec() int { return precAtom }
+func (e raw) format(int) string {
+	return string(e)
+}
+
+func formatList(list []expr, indent int) string {
+	s := make([]string, 0, len(list))
+	for _, e := range list {
+		if _, ok := e.(placeholder); ok {
+			continue
+		}
+		s = append(s, e.format(indent))
+	}
+	return strings.Join(s, ", ")
+}
+
+func hasSideEffects(e expr) bool {
+	_, ok := e.(*call)
+	return ok
+}
+
+var keywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true,
+	"false": true, "for": true, "function": true, "if": true, "in": true, "local": true,
+	"nil": true, "not": true, "or": true, "repeat": true, "return": true, "then": true,
+	"true": true, "until": true, "while": true,
+}
+
+func isIdentifier(s string) bool {
+	if s == "" || keywords[s] {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func isIdentifierKey(e expr) bool {
+	k, ok := e.(constant)
+	return ok && k.k.Type == chunk.TypeString && isIdentifier(k.k.Text)
+}
+
+// quote formats a string as Lua literal, bytes outside of ASCII are kept as they are
+func quote(s string) string {
+	b := strings.Builder{}
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&b, `\%03d`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,834 @@
+package decompile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+	"github.com/namgo/GameWaveFans/pkg/zbc/disasm"
+)
+
+// passes is the maximum number of times a function is decompiled, while looking for
+// registers of stripped functions, that have to be kept as variables
+const passes = 4
+
+var arithmetic = map[chunk.OpCode]string{
+	chunk.OpAdd: "+",
+	chunk.OpSub: "-",
+	chunk.OpMul: "*",
+	chunk.OpDiv: "/",
+	chunk.OpPow: "^",
+}
+
+// funcState holds state of a single pass over a function
+type funcState struct {
+	f        *chunk.Function
+	upvalues []string
+	// depth of function nesting, used in names of registers
+	depth int
+	cache map[string]*function
+
+	// register of each local variable from debug information
+	localRegs []int
+	declared  []bool
+	// implicit locals are declared by the construct creating them: parameters, loop variables
+	implicit []bool
+
+	// fixed names of registers in functions without debug information
+	fixed map[int]string
+	// registers, that are variables instead of temporary values
+	registers map[int]bool
+	// registers read without a known value during this pass
+	unresolved map[int]bool
+	// registers holding variables of loops being decompiled
+	loopVars map[int]int
+
+	// positions of jumps going back to each instruction
+	backJumps map[int][]int
+}
+
+type scope struct {
+	// loopExit is the first instruction after the innermost loop, -1 outside of loops
+	loopExit int
+	// untilJump is the last jump of the condition ending the block, -1 if there's none
+	untilJump int
+}
+
+var topScope = scope{loopExit: -1, untilJump: -1}
+
+// decompileFunction reconstructs f, upvalues are names of its upvalues known to the parent
+func decompileFunction(f *chunk.Function, upvalues []string, depth int, cache map[string]*function) *function {
+	key := fmt.Sprintf("%p %s", f, strings.Join(upvalues, ","))
+	if fn, ok := cache[key]; ok {
+		return fn
+	}
+
+	registers := map[int]bool{}
+	var fn *function
+	for range passes {
+		fs := newFuncState(f, upvalues, depth, registers, cache)
+		fn = fs.run()
+		grown := false
+		for r := range fs.unresolved {
+			if !registers[r] {
+				registers[r] = true
+				grown = true
+			}
+		}
+		if !grown {
+			break
+		}
+	}
+	cache[key] = fn
+	return fn
+}
+
+func newFuncState(f *chunk.Function, upvalues []string, depth int, registers map[int]bool,
+	cache map[string]*function,
+) *funcState {
+	fs := &funcState{
+		f:          f,
+		upvalues:   upvalues,
+		depth:      depth,
+		cache:      cache,
+		localRegs:  make([]int, len(f.Locals)),
+		declared:   make([]bool, len(f.Locals)),
+		implicit:   make([]bool, len(f.Locals)),
+		fixed:      map[int]string{},
+		registers:  map[int]bool{},
+		unresolved: map[int]bool{},
+		loopVars:   map[int]int{},
+		backJumps:  map[int][]int{},
+	}
+	for r := range registers {
+		fs.registers[r] = true
+	}
+
+	for i, l := range f.Locals {
+		for j := range i {
+			if f.Locals[j].StartPC <= l.StartPC && l.StartPC < f.Locals[j].EndPC {
+				fs.localRegs[i]++
+			}
+		}
+		// parameters and the implicit arg table of vararg functions
+		if l.StartPC == 0 && (fs.localRegs[i] < int(f.NumParams) || (f.IsVararg && l.Name == "arg")) {
+			fs.implicit[i] = true
+		}
+	}
+	for r := range int(f.NumParams) {
+		if fs.localAt(r, 0) < 0 {
+			fs.fixed[r] = fs.registerName(r)
+		}
+	}
+	if f.IsVararg && fs.localAt(int(f.NumParams), 0) < 0 {
+		fs.fixed[int(f.NumParams)] = "arg"
+	}
+	for r := range fs.fixed {
+		fs.registers[r] = true
+	}
+
+	for pc, i := range f.Code {
+		if i.OpCode() == chunk.OpJmp {
+			if target := pc + 1 + i.SBx(); target <= pc {
+				fs.backJumps[target] = append(fs.backJumps[target], pc)
+			}
+		}
+	}
+	return fs
+}
+
+func (fs *funcState) run() (fn *function) {
+	fn = &function{proto: fs.f, vararg: fs.f.IsVararg}
+	for r := range int(fs.f.NumParams) {
+		fn.params = append(fn.params, fs.regName(r, 0))
+	}
+	defer func() {
+		// malformed code shouldn't stop decompilation of the rest of the chunk
+		if err := recover(); err != nil {
+			fn.registers = nil
+			fn.body = []stmt{disassembly(fs.f, fmt.Sprintf("decompilation failed: %v", err))}
+		}
+	}()
+
+	b := fs.newBlock(false)
+	fs.fill(b, 0, len(fs.f.Code), topScope)
+	fn.body = b.stmts
+
+	var registers []int
+	for r := range fs.registers {
+		if _, ok := fs.fixed[r]; !ok {
+			registers = append(registers, r)
+		}
+	}
+	for r := range fs.unresolved {
+		if !fs.registers[r] {
+			registers = append(registers, r)
+		}
+	}
+	sort.Ints(registers)
+	for _, r := range registers {
+		fn.registers = append(fn.registers, fs.registerName(r))
+	}
+	return fn
+}
+
+// disassembly returns the whole function f as a comment
+func disassembly(f *chunk.Function, reason string) comment {
+	c := comment{reason}
+	for pc := range f.Code {
+		c = append(c, strings.TrimSpace(disasm.FormatInstruction(f, pc)))
+	}
+	return c
+}
+
+// localAt returns index of the local variable held in register r at pc, or -1
+func (fs *funcState) localAt(r, pc int) int {
+	for i := len(fs.f.Locals) - 1; i >= 0; i-- {
+		l := fs.f.Locals[i]
+		if fs.localRegs[i] == r && l.StartPC <= pc && pc < l.EndPC {
+			return i
+		}
+	}
+	return -1
+}
+
+func (fs *funcState) regName(r, pc int) string {
+	if l := fs.localAt(r, pc); l >= 0 {
+		return fs.f.Locals[l].Name
+	}
+	if s, ok := fs.fixed[r]; ok {
+		return s
+	}
+	return fs.registerName(r)
+}
+
+// registerName returns name of a register without debug information, registers of nested
+// functions are suffixed with their depth, so they don't hide registers of their parents
+func (fs *funcState) registerName(r int) string {
+	if fs.depth == 0 {
+		return fmt.Sprintf("r%d", r)
+	}
+	return fmt.Sprintf("r%d_%d", r, fs.depth)
+}
+
+// isVariable reports whether writes to r at pc are assignments
+func (fs *funcState) isVariable(r, pc int) bool {
+	return fs.localAt(r, pc) >= 0 || fs.registers[r]
+}
+
+func (fs *funcState) upvalue(n int) string {
+	if n < len(fs.upvalues) && fs.upvalues[n] != "" {
+		return fs.upvalues[n]
+	}
+	return fmt.Sprintf("u%d", n)
+}
+
+func (fs *funcState) constant(n int) expr {
+	if n < len(fs.f.Constants) {
+		return constant{fs.f.Constants[n]}
+	}
+	return raw(fmt.Sprintf("K%d", n))
+}
+
+func (fs *funcState) jumpTarget(pc int) int {
+	return pc + 1 + fs.f.Code[pc].SBx()
+}
+
+// markImplicit marks locals in registers [lo, hi) starting between from and to as declared by a loop
+func (fs *funcState) markImplicit(from, to, lo, hi int) {
+	for i, l := range fs.f.Locals {
+		if l.StartPC >= from && l.StartPC <= to && fs.localRegs[i] >= lo && fs.localRegs[i] < hi {
+			fs.implicit[i] = true
+		}
+	}
+}
+
+func (fs *funcState) fill(b *blockState, start, end int, sc scope) {
+	for pc := start; pc < end && b.until == nil; {
+		fs.declare(b, pc)
+		pc = fs.step(b, pc, end, sc)
+	}
+	b.flush()
+}
+
+// declare adds declarations of locals starting at pc
+func (fs *funcState) declare(b *blockState, pc int) {
+	if b.exprMode {
+		return
+	}
+	var names []string
+	var values []expr
+	for l, local := range fs.f.Locals {
+		if local.StartPC != pc || fs.declared[l] || fs.implicit[l] {
+			continue
+		}
+		r := fs.localRegs[l]
+		// local function is declared by its closure
+		if pc < len(fs.f.Code) && fs.f.Code[pc].OpCode() == chunk.OpClosure && fs.f.Code[pc].A() == r {
+			continue
+		}
+		fs.declared[l] = true
+		value, ok := b.take(r)
+		// internal variables of the compiler
+		if strings.HasPrefix(local.Name, "(") {
+			continue
+		}
+		if !ok {
+			value = nilValue
+		}
+		names = append(names, local.Name)
+		values = append(values, value)
+	}
+	if len(names) == 0 {
+		return
+	}
+	if c, ok := values[0].(closure); ok && len(names) == 1 {
+		b.add(functionStmt{name: names[0], local: true, fn: c.fn})
+		return
+	}
+	b.add(localStmt{names: names, values: values})
+}
+
+func (fs *funcState) step(b *blockState, pc, end int, sc scope) int {
+	if next, ok := fs.repeatLoop(b, pc, end, sc); ok {
+		return next
+	}
+	switch fs.f.Code[pc].OpCode() {
+	case chunk.OpSub:
+		if next, ok := fs.numericFor(b, pc, end); ok {
+			return next
+		}
+	case chunk.OpTForPrep:
+		if next, ok := fs.genericFor(b, pc, end); ok {
+			return next
+		}
+		return fs.unknownJump(b, pc, end)
+	case chunk.OpJmp:
+		return fs.jump(b, pc, end, sc)
+	case chunk.OpEq, chunk.OpLt, chunk.OpLe, chunk.OpTest:
+		return fs.test(b, pc, end, sc)
+	}
+	return fs.instruction(b, pc)
+}
+
+// raw adds disassembly of an instruction that couldn't be decompiled
+func (fs *funcState) raw(b *blockState, pc int) int {
+	b.add(comment{strings.TrimSpace(disasm.FormatInstruction(fs.f, pc))})
+	return pc + 1
+}
+
+// repeatLoop decompiles repeat-until loop starting at pc, its condition jumps back to the start
+func (fs *funcState) repeatLoop(b *blockState, pc, end int, sc scope) (int, bool) {
+	last := -1
+	for _, j := range fs.backJumps[pc] {
+		if j > pc && j < end && j != sc.untilJump && j > last {
+			last = j
+		}
+	}
+	if last < 0 {
+		return 0, false
+	}
+	body := fs.newBlock(b.exprMode)
+	fs.fill(body, pc, last+1, scope{loopExit: last + 1, untilJump: last})
+	cond := body.until
+	if cond == nil {
+		body.add(comment{"condition of the loop wasn't recovered"})
+		cond = boolean(false)
+	}
+	b.add(repeatStmt{body: body.stmts, cond: cond})
+	return last + 1, true
+}
+
+// numericFor decompiles for loop, its preparation subtracts step from the initial value
+// and jumps to FORLOOP at the end of the body
+func (fs *funcState) numericFor(b *blockState, pc, end int) (int, bool) {
+	code := fs.f.Code
+	i := code[pc]
+	a := i.A()
+	if i.B() != a || i.C() != a+2 || pc+1 >= end || code[pc+1].OpCode() != chunk.OpJmp {
+		return 0, false
+	}
+	loop := fs.jumpTarget(pc + 1)
+	if loop <= pc+1 || loop >= end || code[loop].OpCode() != chunk.OpForLoop || code[loop].A() != a ||
+		fs.jumpTarget(loop) != pc+2 {
+		return 0, false
+	}
+
+	init, limit, step := b.read(a, pc), b.read(a+1, pc), b.read(a+2, pc)
+	fs.markImplicit(pc, pc+2, a, a+3)
+	fs.loopVars[a]++
+	body := fs.newBlock(b.exprMode)
+	fs.fill(body, pc+2, loop, scope{loopExit: loop + 1, untilJump: -1})
+	fs.loopVars[a]--
+
+	b.add(numericForStmt{variable: fs.regName(a, pc+2), init: init, limit: limit, step: step, body: body.stmts})
+	return loop + 1, true
+}
+
+// genericFor decompiles for-in loop, TFORPREP jumps to TFORLOOP followed by a jump back to the body
+func (fs *funcState) genericFor(b *blockState, pc, end int) (int, bool) {
+	code := fs.f.Code
+	a := code[pc].A()
+	loop := fs.jumpTarget(pc)
+	if loop <= pc || loop+1 >= end || code[loop].OpCode() != chunk.OpTForLoop || code[loop].A() != a ||
+		code[loop+1].OpCode() != chunk.OpJmp || fs.jumpTarget(loop+1) != pc+1 {
+		return 0, false
+	}
+	vars := code[loop].C() + 1
+
+	values := []expr{b.read(a, pc), b.read(a+1, pc), b.read(a+2, pc)}
+	for r := a + 3; r < a+2+vars; r++ {
+		b.take(r)
+	}
+	for len(values) > 1 {
+		last := values[len(values)-1]
+		if _, ok := last.(placeholder); !ok && !isNil(last) {
+			break
+		}
+		values = values[:len(values)-1]
+	}
+
+	fs.markImplicit(pc, pc+1, a, a+2+vars)
+	names := make([]string, vars)
+	for k := range names {
+		names[k] = fs.regName(a+2+k, pc+1)
+		fs.loopVars[a+2+k]++
+	}
+	body := fs.newBlock(b.exprMode)
+	fs.fill(body, pc+1, loop, scope{loopExit: loop + 2, untilJump: -1})
+	for k := range names {
+		fs.loopVars[a+2+k]--
+	}
+
+	b.add(genericForStmt{variables: names, values: values, body: body.stmts})
+	return loop + 2, true
+}
+
+func (fs *funcState) jump(b *blockState, pc, end int, sc scope) int {
+	target := fs.jumpTarget(pc)
+	switch {
+	case pc == sc.untilJump:
+		// repeat ... until false
+		b.until = boolean(false)
+		return pc + 1
+	case target > pc:
+		if next, ok := fs.whileLoop(b, pc, target, end); ok {
+			return next
+		}
+	}
+	switch target {
+	case sc.loopExit:
+		b.add(breakStmt{})
+		return pc + 1
+	case pc + 1:
+		return pc + 1
+	}
+	return fs.unknownJump(b, pc, end)
+}
+
+// unknownJump adds disassembly of a jump at pc, that isn't a part of a recognized statement.
+// Values of registers can't be folded across it, so they are assigned before it,
+// and instructions skipped by it are kept as disassembly too
+func (fs *funcState) unknownJump(b *blockState, pc, end int) int {
+	b.spill(pc)
+	next := fs.raw(b, pc)
+	if target := fs.jumpTarget(pc); target <= end {
+		for next < target {
+			next = fs.raw(b, next)
+		}
+	}
+	return next
+}
+
+// whileLoop decompiles while loop, the jump at pc skips the body to the condition,
+// which jumps back to the body while it's true
+func (fs *funcState) whileLoop(b *blockState, pc, condStart, end int) (int, bool) {
+	exits := map[int]expr{pc + 1: boolean(true)}
+	last := -1
+	var pairs []pair
+	for j := end - 1; j >= condStart && last < 0; j-- {
+		if fs.f.Code[j].OpCode() != chunk.OpJmp || fs.jumpTarget(j) != pc+1 {
+			continue
+		}
+		if j == condStart {
+			last = j
+			break
+		}
+		pairs = fs.pairsIn(condStart, j)
+		exits[j+1] = boolean(false)
+		if pairs != nil && validChain(pairs, exits) {
+			last = j
+		}
+		delete(exits, j+1)
+	}
+	if last < 0 {
+		return 0, false
+	}
+
+	body := fs.newBlock(b.exprMode)
+	fs.fill(body, pc+1, condStart, scope{loopExit: last + 1, untilJump: -1})
+	var cond expr = boolean(true)
+	if last != condStart {
+		exits[last+1] = boolean(false)
+		cb := fs.newBlock(true)
+		cond = fs.condition(cb, pairs, exits)
+	}
+	b.add(whileStmt{cond: cond, body: body.stmts})
+	return last + 1, true
+}
+
+func (fs *funcState) test(b *blockState, pc, end int, sc scope) int {
+	if pc+1 >= end || !fs.isTestJump(pc) {
+		return fs.raw(b, pc)
+	}
+	if next, ok := fs.boolValue(b, pc, end); ok {
+		return next
+	}
+	if next, ok := fs.logicalValue(b, pc, end); ok {
+		return next
+	}
+
+	pairs := fs.chainAt(pc, end)
+	if sc.untilJump >= 0 {
+		start := fs.jumpTarget(sc.untilJump)
+		for n := len(pairs); n > 0; n-- {
+			exits := map[int]expr{sc.untilJump + 1: boolean(true), start: boolean(false)}
+			if pairs[n-1].test+1 == sc.untilJump && validChain(pairs[:n], exits) {
+				b.until = fs.condition(b, pairs[:n], exits)
+				return sc.untilJump + 1
+			}
+		}
+	}
+
+	code := fs.f.Code
+	inner := scope{loopExit: sc.loopExit, untilJump: -1}
+	for n := len(pairs); n > 0; n-- {
+		thenStart := pairs[n-1].test + 2
+		thenEnd := pairs[n-1].target
+		exits := map[int]expr{thenStart: boolean(true), thenEnd: boolean(false)}
+		if thenEnd < thenStart || thenEnd > end || !validChain(pairs[:n], exits) {
+			continue
+		}
+		cond := fs.condition(b, pairs[:n], exits)
+
+		// then block ends with a jump over the else block
+		next := thenEnd
+		var elseBody []stmt
+		if thenEnd-1 >= thenStart && code[thenEnd-1].OpCode() == chunk.OpJmp &&
+			(thenEnd-2 < thenStart || !fs.isTestJump(thenEnd-2)) {
+			if target := fs.jumpTarget(thenEnd - 1); target > thenEnd && target <= end && target != sc.loopExit {
+				elseBlock := fs.newBlock(b.exprMode)
+				fs.fill(elseBlock, thenEnd, target, inner)
+				elseBody = elseBlock.stmts
+				thenEnd--
+				next = target
+			}
+		}
+		thenBlock := fs.newBlock(b.exprMode)
+		fs.fill(thenBlock, thenStart, thenEnd, inner)
+		b.add(ifStmt{cond: cond, then: thenBlock.stmts, elseBody: elseBody})
+		return next
+	}
+	b.spill(pc)
+	fs.raw(b, pc)
+	return fs.unknownJump(b, pc+1, end)
+}
+
+// boolValue decompiles comparisons stored in a register, tests jump to a pair of LOADBOOL
+// instructions setting the register to false or true
+func (fs *funcState) boolValue(b *blockState, pc, end int) (int, bool) {
+	code := fs.f.Code
+	pairs := fs.chainAt(pc, end)
+	for n := len(pairs); n > 0; n-- {
+		s := pairs[n-1].test + 2
+		if s+1 >= end {
+			continue
+		}
+		loadFalse, loadTrue := code[s], code[s+1]
+		if loadFalse.OpCode() != chunk.OpLoadBool || loadFalse.B() != 0 || loadFalse.C() == 0 ||
+			loadTrue.OpCode() != chunk.OpLoadBool || loadTrue.B() == 0 || loadTrue.C() != 0 ||
+			loadFalse.A() != loadTrue.A() {
+			continue
+		}
+		exits := map[int]expr{s: boolean(false), s + 1: boolean(true)}
+		if !validChain(pairs[:n], exits) {
+			continue
+		}
+		b.write(loadTrue.A(), fs.condition(b, pairs[:n], exits), s+1)
+		return s + 2, true
+	}
+	return 0, false
+}
+
+// logicalValue decompiles and/or operators stored in a register, TEST copies its operand
+// to the register and jumps over the code computing the other operand
+func (fs *funcState) logicalValue(b *blockState, pc, end int) (int, bool) {
+	i := fs.f.Code[pc]
+	target := fs.jumpTarget(pc + 1)
+	if i.OpCode() != chunk.OpTest || target <= pc+2 || target > end {
+		return 0, false
+	}
+	// locals scoped inside of the skipped code mean it's a block of an if statement
+	for _, l := range fs.f.Locals {
+		if l.StartPC > pc+1 && l.StartPC <= target && l.EndPC <= target {
+			return 0, false
+		}
+	}
+
+	unresolved := map[int]bool{}
+	for r := range fs.unresolved {
+		unresolved[r] = true
+	}
+	sub := fs.newBlock(true)
+	fs.fill(sub, pc+2, target, topScope)
+	value, ok := sub.pending[i.A()]
+	if !ok || sub.failed || len(sub.stmts) > 0 || len(sub.pending) != 1 {
+		fs.unresolved = unresolved
+		return 0, false
+	}
+
+	op := "and"
+	if i.C() != 0 {
+		op = "or"
+	}
+	b.write(i.A(), binop{op, b.read(i.B(), pc), value}, target-1)
+	return target, true
+}
+
+// instruction decompiles instructions, that don't change control flow
+func (fs *funcState) instruction(b *blockState, pc int) int {
+	f := fs.f
+	i := f.Code[pc]
+	a := i.A()
+	switch op := i.OpCode(); op {
+	case chunk.OpMove:
+		b.write(a, b.read(i.B(), pc), pc)
+	case chunk.OpLoadK:
+		b.write(a, fs.constant(i.Bx()), pc)
+	case chunk.OpLoadBool:
+		if i.C() != 0 {
+			return fs.raw(b, pc)
+		}
+		b.write(a, boolean(i.B() != 0), pc)
+	case chunk.OpLoadNil:
+		for r := a; r <= i.B(); r++ {
+			b.write(r, nilValue, pc)
+		}
+	case chunk.OpGetUpval:
+		b.write(a, name(fs.upvalue(i.B())), pc)
+	case chunk.OpGetGlobal:
+		b.write(a, fs.global(i.Bx()), pc)
+	case chunk.OpGetTable:
+		b.write(a, index{obj: b.read(i.B(), pc), key: b.rk(i.C(), pc)}, pc)
+	case chunk.OpSetGlobal:
+		fs.assign(b, fs.global(i.Bx()), b.read(a, pc))
+	case chunk.OpSetUpval:
+		fs.assign(b, name(fs.upvalue(i.B())), b.read(a, pc))
+	case chunk.OpSetTable:
+		if t := b.constructor(a); t != nil {
+			key := b.rk(i.B(), pc)
+			t.items = append(t.items, tableItem{key: key, value: b.rk(i.C(), pc)})
+			break
+		}
+		value, key := b.rk(i.C(), pc), b.rk(i.B(), pc)
+		fs.assign(b, index{obj: b.read(a, pc), key: key}, value)
+	case chunk.OpNewTable:
+		b.write(a, &table{}, pc)
+	case chunk.OpSelf:
+		obj, key := b.read(i.B(), pc), b.rk(i.C(), pc)
+		b.write(a+1, self{}, pc)
+		b.write(a, method{obj: obj, key: key}, pc)
+	case chunk.OpAdd, chunk.OpSub, chunk.OpMul, chunk.OpDiv, chunk.OpPow:
+		b.write(a, binop{arithmetic[op], b.rk(i.B(), pc), b.rk(i.C(), pc)}, pc)
+	case chunk.OpUnm:
+		b.write(a, unop{"-", b.read(i.B(), pc)}, pc)
+	case chunk.OpNot:
+		b.write(a, not(b.read(i.B(), pc)), pc)
+	case chunk.OpConcat:
+		if i.B() > i.C() {
+			return fs.raw(b, pc)
+		}
+		var e expr
+		for r := i.C(); r >= i.B(); r-- {
+			if v := b.read(r, pc); e == nil {
+				e = v
+			} else {
+				e = binop{"..", v, e}
+			}
+		}
+		b.write(a, e, pc)
+	case chunk.OpCall:
+		c := fs.call(b, pc)
+		switch results := i.C(); results {
+		case 0:
+			c.multret = true
+			b.write(a, c, pc)
+		case 1:
+			b.add(callStmt{c})
+		default:
+			b.write(a, c, pc)
+			for k := 1; k < results-1; k++ {
+				b.write(a+k, placeholder{k + 1}, pc)
+			}
+		}
+	case chunk.OpTailCall:
+		b.add(returnStmt{[]expr{fs.call(b, pc)}})
+		// the compiler adds a return after each tail call
+		if pc+1 < len(f.Code) && f.Code[pc+1].OpCode() == chunk.OpReturn {
+			return pc + 2
+		}
+	case chunk.OpReturn:
+		last := a + i.B() - 2
+		if i.B() == 0 {
+			last = b.top(a - 1)
+		}
+		values := []expr{}
+		for r := a; r <= last; r++ {
+			values = append(values, b.read(r, pc))
+		}
+		// the compiler ends each function with a return
+		if pc != len(f.Code)-1 || len(values) > 0 {
+			b.add(returnStmt{values})
+		}
+	case chunk.OpSetList, chunk.OpSetListO:
+		t := b.constructor(a)
+		if t == nil {
+			return fs.raw(b, pc)
+		}
+		last := a + i.Bx()%chunk.FieldsPerFlush + 1
+		if op == chunk.OpSetListO {
+			last = b.top(a)
+		}
+		for r := a + 1; r <= last; r++ {
+			t.items = append(t.items, tableItem{value: b.read(r, pc)})
+		}
+	case chunk.OpClose:
+	case chunk.OpClosure:
+		if i.Bx() >= len(f.Functions) {
+			return fs.raw(b, pc)
+		}
+		p := f.Functions[i.Bx()]
+		b.write(a, closure{fs.closure(p, pc)}, pc)
+		return pc + 1 + int(p.NumUpvalues)
+	default:
+		return fs.raw(b, pc)
+	}
+	return pc + 1
+}
+
+// setList returns the last register stored by SETLIST to table in register t after pc,
+// or -1 if the table is used in another way first
+func (fs *funcState) setList(t, pc int) int {
+	for _, i := range fs.f.Code[pc+1:] {
+		switch i.OpCode() {
+		case chunk.OpSetList:
+			if i.A() == t {
+				return t + i.Bx()%chunk.FieldsPerFlush + 1
+			}
+		case chunk.OpSetListO:
+			if i.A() == t {
+				return chunk.MaxStack
+			}
+		case chunk.OpSetTable:
+		case chunk.OpJmp, chunk.OpEq, chunk.OpLt, chunk.OpLe, chunk.OpTest, chunk.OpReturn, chunk.OpTailCall,
+			chunk.OpForLoop, chunk.OpTForLoop, chunk.OpTForPrep, chunk.OpSetGlobal, chunk.OpSetUpval:
+			return -1
+		default:
+			if i.A() == t {
+				return -1
+			}
+		}
+	}
+	return -1
+}
+
+func (fs *funcState) global(n int) expr {
+	if n < len(fs.f.Constants) && fs.f.Constants[n].Type == chunk.TypeString {
+		return global(fs.f.Constants[n].Text)
+	}
+	return index{obj: name("_G"), key: fs.constant(n)}
+}
+
+func (fs *funcState) call(b *blockState, pc int) *call {
+	i := fs.f.Code[pc]
+	a := i.A()
+	last := a + i.B() - 1
+	if i.B() == 0 {
+		last = b.top(a)
+	}
+	args := []expr{}
+	for r := a + 1; r <= last; r++ {
+		args = append(args, b.read(r, pc))
+	}
+	fn := b.read(a, pc)
+	if m, ok := fn.(method); ok && len(args) > 0 {
+		if _, ok := args[0].(self); ok {
+			if isIdentifierKey(m.key) {
+				return &call{fn: m.obj, args: args[1:], methodName: m.key.(constant).k.Text}
+			}
+			args[0] = m.obj
+		}
+		fn = index(m)
+	}
+	return &call{fn: fn, args: args}
+}
+
+// closure decompiles a nested function created at pc, names of its upvalues are taken
+// from its debug information, or from instructions following CLOSURE
+func (fs *funcState) closure(p *chunk.Function, pc int) *function {
+	names := make([]string, p.NumUpvalues)
+	for k := range names {
+		if k < len(p.Upvalues) {
+			names[k] = p.Upvalues[k]
+			continue
+		}
+		if pc+1+k >= len(fs.f.Code) {
+			continue
+		}
+		switch i := fs.f.Code[pc+1+k]; i.OpCode() {
+		case chunk.OpMove:
+			names[k] = fs.regName(i.B(), pc)
+			if !fs.isVariable(i.B(), pc) {
+				fs.unresolved[i.B()] = true
+			}
+		case chunk.OpGetUpval:
+			names[k] = fs.upvalue(i.B())
+		}
+	}
+	return decompileFunction(p, names, fs.depth+1, fs.cache)
+}
+
+// assign adds assignment to a variable or table field, using function statements for closures
+func (fs *funcState) assign(b *blockState, target, value expr) {
+	if c, ok := value.(closure); ok {
+		if path, ok := functionName(target); ok {
+			fn := c.fn
+			if t, ok := target.(index); ok && len(fn.params) > 0 && fn.params[0] == "self" {
+				obj, _ := functionName(t.obj)
+				path = obj + ":" + t.key.(constant).k.Text
+				method := *fn
+				method.params = fn.params[1:]
+				fn = &method
+			}
+			b.add(functionStmt{name: path, fn: fn})
+			return
+		}
+	}
+	b.add(assignStmt{target: target, value: value})
+}
+
+// functionName returns name usable in a function statement
+func functionName(e expr) (string, bool) {
+	switch v := e.(type) {
+	case name:
+		return string(v), true
+	case index:
+		if !isIdentifierKey(v.key) {
+			return "", false
+		}
+		obj, ok := functionName(v.obj)
+		return obj + "." + v.key.(constant).k.Text, ok
+	}
+	return "", false
+}
@@ -0,0 +1,228 @@
+package decompile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+)
+
+const indentation = "  "
+
+// function is a decompiled function prototype
+type function struct {
+	// proto is the decompiled function, its disassembly is written if writing of the function fails
+	proto  *chunk.Function
+	params []string
+	vararg bool
+	// registers of stripped functions that couldn't be folded into expressions
+	registers []string
+	body      []stmt
+}
+
+type stmt interface {
+	write(w *writer, indent int)
+}
+
+type writer struct {
+	strings.Builder
+}
+
+func (w *writer) line(indent int, parts ...string) {
+	w.WriteString(strings.Repeat(indentation, indent))
+	for _, p := range parts {
+		w.WriteString(p)
+	}
+	w.WriteByte('\n')
+}
+
+func (w *writer) block(stmts []stmt, indent int) {
+	for _, s := range stmts {
+		s.write(w, indent)
+	}
+}
+
+func (w *writer) function(header string, fn *function, indent int) {
+	params := fn.params
+	if fn.vararg {
+		params = append(params[:len(params):len(params)], "...")
+	}
+	w.line(indent, header, "(", strings.Join(params, ", "), ")")
+	w.body(fn, indent+1)
+	w.line(indent, "end")
+}
+
+// body writes statements of fn, or its disassembly if they can't be written,
+// so a single malformed function doesn't stop decompilation of the rest of the chunk
+func (w *writer) body(fn *function, indent int) {
+	out := &writer{}
+	if err := out.tryBody(fn, indent); err != nil {
+		disassembly(fn.proto, fmt.Sprintf("decompilation failed: %v", err)).write(w, indent)
+		return
+	}
+	w.WriteString(out.String())
+}
+
+func (w *writer) tryBody(fn *function, indent int) (err any) {
+	defer func() {
+		err = recover()
+	}()
+	if len(fn.registers) > 0 {
+		w.line(indent, "local ", strings.Join(fn.registers, ", "))
+	}
+	w.block(fn.body, indent)
+	return nil
+}
+
+// comment holds disassembly of instructions, that couldn't be decompiled
+type comment []string
+
+func (s comment) write(w *writer, indent int) {
+	for _, text := range s {
+		w.line(indent, "-- ", text)
+	}
+}
+
+type localStmt struct {
+	names  []string
+	values []expr
+}
+
+func (s localStmt) write(w *writer, indent int) {
+	values := s.values
+	for len(values) > 0 && isNil(values[len(values)-1]) {
+		values = values[:len(values)-1]
+	}
+	if len(values) == 0 {
+		w.line(indent, "local ", strings.Join(s.names, ", "))
+		return
+	}
+	w.line(indent, "local ", strings.Join(s.names, ", "), " = ", formatList(values, indent))
+}
+
+type assignStmt struct {
+	target expr
+	value  expr
+}
+
+func (s assignStmt) write(w *writer, indent int) {
+	w.line(indent, s.target.format(indent), " = ", s.value.format(indent))
+}
+
+type callStmt struct {
+	call *call
+}
+
+func (s callStmt) write(w *writer, indent int) {
+	w.line(indent, s.call.format(indent))
+}
+
+type returnStmt struct {
+	values []expr
+}
+
+func (s returnStmt) write(w *writer, indent int) {
+	if len(s.values) == 0 {
+		w.line(indent, "return")
+		return
+	}
+	w.line(indent, "return ", formatList(s.values, indent))
+}
+
+type breakStmt struct{}
+
+func (breakStmt) write(w *writer, indent int) {
+	w.line(indent, "break")
+}
+
+type ifStmt struct {
+	cond     expr
+	then     []stmt
+	elseBody []stmt
+}
+
+func (s ifStmt) write(w *writer, indent int) {
+	w.line(indent, "if ", s.cond.format(indent), " then")
+	w.block(s.then, indent+1)
+	for {
+		if len(s.elseBody) == 1 {
+			if next, ok := s.elseBody[0].(ifStmt); ok {
+				w.line(indent, "elseif ", next.cond.format(indent), " then")
+				w.block(next.then, indent+1)
+				s = next
+				continue
+			}
+		}
+		break
+	}
+	if len(s.elseBody) > 0 {
+		w.line(indent, "else")
+		w.block(s.elseBody, indent+1)
+	}
+	w.line(indent, "end")
+}
+
+type whileStmt struct {
+	cond expr
+	body []stmt
+}
+
+func (s whileStmt) write(w *writer, indent int) {
+	w.line(indent, "while ", s.cond.format(indent), " do")
+	w.block(s.body, indent+1)
+	w.line(indent, "end")
+}
+
+type repeatStmt struct {
+	body []stmt
+	cond expr
+}
+
+func (s repeatStmt) write(w *writer, indent int) {
+	w.line(indent, "repeat")
+	w.block(s.body, indent+1)
+	w.line(indent, "until ", s.cond.format(indent))
+}
+
+type numericForStmt struct {
+	variable          string
+	init, limit, step expr
+	body              []stmt
+}
+
+func (s numericForStmt) write(w *writer, indent int) {
+	header := s.variable + " = " + s.init.format(indent) + ", " + s.limit.format(indent)
+	if k, ok := s.step.(constant); !ok || k.k.Type != chunk.TypeNumber || k.k.Number != 1 {
+		header += ", " + s.step.format(indent)
+	}
+	w.line(indent, "for ", header, " do")
+	w.block(s.body, indent+1)
+	w.line(indent, "end")
+}
+
+type genericForStmt struct {
+	variables []string
+	values    []expr
+	body      []stmt
+}
+
+func (s genericForStmt) write(w *writer, indent int) {
+	w.line(indent, "for ", strings.Join(s.variables, ", "), " in ", formatList(s.values, indent), " do")
+	w.block(s.body, indent+1)
+	w.line(indent, "end")
+}
+
+// functionStmt is a function assigned to a local, global or table field
+type functionStmt struct {
+	name  string
+	local bool
+	fn    *function
+}
+
+func (s functionStmt) write(w *writer, indent int) {
+	header := "function " + s.name
+	if s.local {
+		header = "local " + header
+	}
+	w.function(header, s.fn, indent)
+}
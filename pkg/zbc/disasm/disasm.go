@@ -0,0 +1,173 @@
+// Package disasm prints Lua 5.0 chunks as text listings, similar to luac -l.
+//
+// Listings hold all information stored in a chunk, so they can be assembled back
+// by package asm into an identical chunk.
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+)
+
+// Disassemble writes listing of all functions in c to w
+func Disassemble(w io.Writer, c *chunk.Chunk) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, FormatHeader(c.Header))
+
+	parents := map[*chunk.Function]*chunk.Function{}
+	err := c.Walk(func(id string, f *chunk.Function) error {
+		for _, p := range f.Functions {
+			parents[p] = f
+		}
+		fmt.Fprintln(bw)
+		writeFunction(bw, id, f, parents[f])
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// FormatHeader formats the .chunk directive
+func FormatHeader(h chunk.Header) string {
+	endianness := "big"
+	if h.LittleEndian {
+		endianness = "little"
+	}
+	numberFormat := "float"
+	if h.IntegralNumber {
+		numberFormat = "integral"
+	}
+	return fmt.Sprintf(".chunk %x.%x %s int=%d size_t=%d instruction=%d number=%d %s",
+		h.Version>>4, h.Version&0xF, endianness, h.SizeInt, h.SizeSizeT, h.SizeInstruction, h.SizeNumber, numberFormat)
+}
+
+func writeFunction(w io.Writer, id string, f, parent *chunk.Function) {
+	fmt.Fprintf(w, ".function %s\n", id)
+	fmt.Fprintf(w, "; %s <%s:%d> (%d instructions)\n", id, strings.TrimLeft(f.Source, "@="), f.LineDefined, len(f.Code))
+	fmt.Fprintf(w, "; %d params, %d stacks, %d upvalues, %d locals, %d constants, %d functions\n",
+		f.NumParams, f.MaxStackSize, f.NumUpvalues, len(f.Locals), len(f.Constants), len(f.Functions))
+	if parent == nil || parent.Source != f.Source {
+		fmt.Fprintf(w, ".source %s\n", strconv.Quote(f.Source))
+	}
+	fmt.Fprintf(w, ".linedefined %d\n", f.LineDefined)
+	fmt.Fprintf(w, ".upvalues %d\n", f.NumUpvalues)
+	fmt.Fprintf(w, ".params %d\n", f.NumParams)
+	fmt.Fprintf(w, ".vararg %d\n", boolToInt(f.IsVararg))
+	fmt.Fprintf(w, ".maxstack %d\n", f.MaxStackSize)
+	for i, k := range f.Constants {
+		fmt.Fprintf(w, ".const K%d %s\n", i, k)
+	}
+	for _, l := range f.Locals {
+		fmt.Fprintf(w, ".local %s %s %s\n", strconv.Quote(l.Name), position(f, l.StartPC), position(f, l.EndPC))
+	}
+	for i, name := range f.Upvalues {
+		fmt.Fprintf(w, ".upvalue U%d %s\n", i, strconv.Quote(name))
+	}
+	fmt.Fprintln(w, ".code")
+	for pc := range f.Code {
+		fmt.Fprintln(w, FormatInstruction(f, pc))
+	}
+	fmt.Fprintln(w, ".end")
+}
+
+// position formats start or end of a local variable range as a label, or as a raw index
+// prefixed with # if it's outside of the function, where there are no labels
+func position(f *chunk.Function, pc int) string {
+	if pc < 0 || pc > len(f.Code) {
+		return "#" + strconv.Itoa(pc)
+	}
+	return strconv.Itoa(pc + 1)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// FormatInstruction formats a single instruction of f, with its number, source line and a comment
+func FormatInstruction(f *chunk.Function, pc int) string {
+	line := "-"
+	if pc < len(f.LineInfo) {
+		line = strconv.Itoa(f.LineInfo[pc])
+	}
+	text, comment := Instruction(f, pc)
+	s := fmt.Sprintf("%6d [%4s] %s", pc+1, line, text)
+	if comment != "" {
+		s = fmt.Sprintf("%-48s ; %s", s, comment)
+	}
+	return s
+}
+
+// Instruction returns mnemonic with operands of an instruction at pc, and a comment describing it
+func Instruction(f *chunk.Function, pc int) (string, string) {
+	i := f.Code[pc]
+	op := i.OpCode()
+	if !op.Valid() {
+		return fmt.Sprintf("%-10s 0x%08x", ".word", uint32(i)), ""
+	}
+	info := op.Info()
+
+	var operands, comments []string
+	add := func(mode chunk.ArgMode, value int) {
+		operand, comment := formatOperand(f, pc, mode, value)
+		operands = append(operands, operand)
+		if comment != "" {
+			comments = append(comments, comment)
+		}
+	}
+
+	add(info.A, i.A())
+	switch info.Mode {
+	case chunk.ModeABC:
+		add(info.B, i.B())
+		add(info.C, i.C())
+	case chunk.ModeABx:
+		add(info.B, i.Bx())
+	case chunk.ModeAsBx:
+		add(info.B, i.SBx())
+	}
+
+	return fmt.Sprintf("%-10s %s", info.Name, strings.Join(operands, " ")), strings.Join(comments, " ")
+}
+
+func formatOperand(f *chunk.Function, pc int, mode chunk.ArgMode, value int) (string, string) {
+	switch mode {
+	case chunk.ArgRegister:
+		return "R" + strconv.Itoa(value), ""
+	case chunk.ArgRK:
+		if chunk.IsConstant(value) {
+			return formatOperand(f, pc, chunk.ArgConstant, chunk.ConstantIndex(value))
+		}
+		return "R" + strconv.Itoa(value), ""
+	case chunk.ArgConstant:
+		comment := "<invalid constant>"
+		if value < len(f.Constants) {
+			comment = f.Constants[value].String()
+		}
+		return "K" + strconv.Itoa(value), comment
+	case chunk.ArgUpvalue:
+		comment := ""
+		if value < len(f.Upvalues) {
+			comment = f.Upvalues[value]
+		}
+		return "U" + strconv.Itoa(value), comment
+	case chunk.ArgFunction:
+		return "F" + strconv.Itoa(value), ""
+	case chunk.ArgJump:
+		// targets outside of the function have no label, the raw offset is kept
+		if target := pc + value + 1; target < 0 || target >= len(f.Code) {
+			return strconv.Itoa(value), "jump outside of the function"
+		}
+		return "@" + strconv.Itoa(pc+value+2), ""
+	}
+	return strconv.Itoa(value), ""
+}
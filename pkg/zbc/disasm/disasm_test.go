@@ -0,0 +1,112 @@
+package disasm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/asm"
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatInstruction(t *testing.T) {
+	t.Parallel()
+
+	f := &chunk.Function{
+		Code: []chunk.Instruction{
+			chunk.NewABx(chunk.OpLoadK, 0, 0),
+			chunk.NewABC(chunk.OpGetTable, 1, 0, chunk.MaxStack+1),
+			chunk.NewABC(chunk.OpAdd, 1, chunk.MaxStack+1, chunk.MaxStack+0),
+			chunk.NewABx(chunk.OpLoadK, 0, 5),
+			chunk.NewAsBx(chunk.OpJmp, 0, -2),
+			chunk.NewABC(chunk.OpGetUpval, 0, 0, 0),
+			chunk.NewAsBx(chunk.OpForLoop, 0, 2),
+			chunk.Instruction(0xFFFFFFFF),
+			chunk.NewAsBx(chunk.OpJmp, 0, 1),
+			chunk.NewAsBx(chunk.OpJmp, 0, -11),
+		},
+		Constants: []chunk.Constant{chunk.StringConstant("hi"), chunk.NumberConstant(2)},
+		Upvalues:  []string{"count"},
+		LineInfo:  []int{3, 3, 4, 4, 5, 5, 6, 6, 7, 7},
+	}
+	stripped := &chunk.Function{
+		Code: []chunk.Instruction{chunk.NewABC(chunk.OpReturn, 0, 1, 0)},
+	}
+
+	tests := []struct {
+		name string
+		f    *chunk.Function
+		pc   int
+		want string
+	}{
+		{"constant", f, 0, `     1 [   3] LOADK      R0 K0                   ; "hi"`},
+		{"rk constant", f, 1, `     2 [   3] GETTABLE   R1 R0 K1                ; 2`},
+		{"two constants", f, 2, `     3 [   4] ADD        R1 K1 K0                ; 2 "hi"`},
+		{"invalid constant", f, 3, `     4 [   4] LOADK      R0 K5                   ; <invalid constant>`},
+		{"backward jump", f, 4, `     5 [   5] JMP        0 @4`},
+		{"upvalue", f, 5, `     6 [   5] GETUPVAL   R0 U0 0                 ; count`},
+		{"forward jump", f, 6, `     7 [   6] FORLOOP    R0 @10`},
+		{"invalid opcode", f, 7, `     8 [   6] .word      0xffffffff`},
+		{"jump past the end", f, 8, `     9 [   7] JMP        0 1                     ; jump outside of the function`},
+		{"jump before the start", f, 9, `    10 [   7] JMP        0 -11                   ; jump outside of the function`},
+		{"stripped", stripped, 0, `     1 [   -] RETURN     R0 1 0`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, FormatInstruction(tt.f, tt.pc))
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+	// jumps and locals outside of the function can't use labels
+	c := &chunk.Chunk{
+		Header: chunk.DefaultHeader(),
+		Main: &chunk.Function{
+			MaxStackSize: 2,
+			Code: []chunk.Instruction{
+				chunk.NewAsBx(chunk.OpJmp, 0, 5),
+				chunk.NewAsBx(chunk.OpJmp, 0, -3),
+				chunk.NewABC(chunk.OpReturn, 0, 1, 0),
+			},
+			Locals: []chunk.Local{{Name: "a", StartPC: 0, EndPC: 3}, {Name: "b", StartPC: -2, EndPC: 9}},
+		},
+	}
+	original := bytes.Buffer{}
+	require.NoError(t, chunk.Encode(&original, c))
+
+	text := bytes.Buffer{}
+	require.NoError(t, Disassemble(&text, c))
+	require.Contains(t, text.String(), ".local \"a\" 1 4\n.local \"b\" #-2 #9\n")
+	reassembled, err := asm.Assemble(&text)
+	require.NoError(t, err)
+	encoded := bytes.Buffer{}
+	require.NoError(t, chunk.Encode(&encoded, reassembled))
+	require.Equal(t, original.Bytes(), encoded.Bytes())
+}
+
+func TestFormatHeader(t *testing.T) {
+	t.Parallel()
+
+	big := chunk.DefaultHeader()
+	big.LittleEndian = false
+	big.SizeNumber = 4
+	big.IntegralNumber = true
+
+	tests := []struct {
+		name string
+		h    chunk.Header
+		want string
+	}{
+		{"default", chunk.DefaultHeader(), ".chunk 5.0 little int=4 size_t=4 instruction=4 number=8 float"},
+		{"big endian integral", big, ".chunk 5.0 big int=4 size_t=4 instruction=4 number=4 integral"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, FormatHeader(tt.h))
+		})
+	}
+}
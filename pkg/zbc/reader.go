@@ -1,6 +1,9 @@
 package zbc
 
 import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"reflect"
@@ -24,33 +27,94 @@ func IsPacked(r io.ReadSeeker) (bool, error) {
 	return false, nil
 }
 
-// Unpack return unzlibbed ZBC bytecode
-func Unpack(r io.ReadSeeker) ([]byte, error) {
-	packed, err := IsPacked(r)
-	if err != nil {
-		return []byte{}, err
+// ReadHeader reads header of a packed file, and leaves r at the start of zlib data
+func ReadHeader(r io.ReadSeeker) (Header, error) {
+	var h Header
+	if _, err := r.Seek(0, 0); err != nil {
+		return h, err
 	}
-	if !packed {
-		return []byte{}, fmt.Errorf("file is not packed")
+
+	buf := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return h, err
+	}
+
+	copy(h.Magic[:], buf[0x0:0x6])
+	copy(h.Unknown[:], buf[0x6:0x8])
+	h.UnpackedSize = binary.LittleEndian.Uint32(buf[0x8:0xC])
+	h.PackedSize = binary.LittleEndian.Uint32(buf[0xC:0x10])
+
+	if string(h.Magic[:]) != packedHeader {
+		return h, FormatError("file is not packed")
 	}
+	return h, nil
+}
 
-	// unpackedSize, err := common.ReadUint32(r, 8)
-	// if err != nil {
-	// 	return []byte{}, err
-	// }
+// countingReader counts bytes consumed by zlib decoder. It implements io.ByteReader,
+// so the decoder doesn't read ahead of the end of the stream
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
 
-	// packedSize, err := common.ReadUint32(r, 0xC)
-	// if err != nil {
-	// 	return []byte{}, err
-	// }
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
 
-	_, err = r.Seek(0x10, 0)
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// Unpack return unzlibbed ZBC bytecode
+func Unpack(r io.ReadSeeker) ([]byte, error) {
+	h, err := ReadHeader(r)
 	if err != nil {
 		return []byte{}, err
 	}
-	unpacked, err := common.ReadZlib(r)
+
+	counter := &countingReader{r: bufio.NewReader(r)}
+	zlibDecoder, err := zlib.NewReader(counter)
+	if err != nil {
+		return nil, err
+	}
+	unpacked, err := io.ReadAll(zlibDecoder)
 	if err != nil {
 		return nil, err
 	}
+	err = zlibDecoder.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(unpacked) != int(h.UnpackedSize) {
+		return nil, FormatError(fmt.Sprintf("unpacked size mismatch: got %d, expected %d", len(unpacked), h.UnpackedSize))
+	}
+	if counter.n != int64(h.PackedSize) {
+		return nil, FormatError(fmt.Sprintf("packed size mismatch: got %d, expected %d", counter.n, h.PackedSize))
+	}
 	return unpacked, nil
 }
+
+// ReadBytecode returns bytecode from a packed or an unpacked file
+func ReadBytecode(r io.ReadSeeker) ([]byte, error) {
+	packed, err := IsPacked(r)
+	if err != nil {
+		return nil, err
+	}
+	if packed {
+		return Unpack(r)
+	}
+	if _, err := r.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
@@ -0,0 +1,93 @@
+package zbc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func packed(t *testing.T, data []byte) []byte {
+	t.Helper()
+	buf := bytes.Buffer{}
+	require.NoError(t, Pack(&buf, data))
+	return buf.Bytes()
+}
+
+func TestReadHeader(t *testing.T) {
+	t.Parallel()
+	data := packed(t, []byte("Gamewave"))
+
+	h, err := ReadHeader(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, packedHeader, string(h.Magic[:]))
+	require.Equal(t, [2]byte{0, 0}, h.Unknown)
+	require.Equal(t, uint32(8), h.UnpackedSize)
+	require.Equal(t, uint32(len(data)-headerSize), h.PackedSize)
+
+	_, err = ReadHeader(bytes.NewReader([]byte("\x1bLua\x50\x01\x04\x04\x04\x06\x08\x09\x09\x08\x00\x00")))
+	require.Equal(t, FormatError("file is not packed"), err)
+}
+
+func TestUnpack(t *testing.T) {
+	t.Parallel()
+	valid := packed(t, []byte("Gamewave"))
+
+	withUnpackedSize := func(size uint32) []byte {
+		data := bytes.Clone(valid)
+		binary.LittleEndian.PutUint32(data[0x8:0xC], size)
+		return data
+	}
+	withPackedSize := func(size uint32) []byte {
+		data := bytes.Clone(valid)
+		binary.LittleEndian.PutUint32(data[0xC:0x10], size)
+		return data
+	}
+
+	cases := []struct {
+		name          string
+		data          []byte
+		expectedData  []byte
+		expectedError error
+	}{
+		{
+			name:          "valid file",
+			data:          valid,
+			expectedData:  []byte("Gamewave"),
+			expectedError: nil,
+		},
+		{
+			name:          "trailing data is ignored",
+			data:          append(bytes.Clone(valid), 0, 0, 0, 0),
+			expectedData:  []byte("Gamewave"),
+			expectedError: nil,
+		},
+		{
+			name:          "wrong unpacked size",
+			data:          withUnpackedSize(9),
+			expectedData:  nil,
+			expectedError: FormatError("unpacked size mismatch: got 8, expected 9"),
+		},
+		{
+			name:          "wrong packed size",
+			data:          withPackedSize(1),
+			expectedData:  nil,
+			expectedError: FormatError(fmt.Sprintf("packed size mismatch: got %d, expected 1", len(valid)-headerSize)),
+		},
+	}
+
+	for _, tt := range cases {
+		name := tt.name
+		data := tt.data
+		expectedData := tt.expectedData
+		expectedError := tt.expectedError
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			data, err := Unpack(bytes.NewReader(data))
+			require.Equal(t, expectedError, err)
+			require.Equal(t, expectedData, data)
+		})
+	}
+}
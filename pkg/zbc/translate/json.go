@@ -0,0 +1,78 @@
+package translate
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonEntry is Entry as stored in JSON. JSON strings can't hold bytes, that aren't valid UTF-8,
+// like text in single-byte codepages, so such strings are stored with these bytes replaced
+// by U+FFFD, and their exact bytes are stored in base64 in the raw field
+type jsonEntry struct {
+	ID             string `json:"id"`
+	Text           string `json:"text"`
+	TextRaw        []byte `json:"text_raw,omitempty"`
+	Name           bool   `json:"name,omitempty"`
+	Translation    string `json:"translation"`
+	TranslationRaw []byte `json:"translation_raw,omitempty"`
+}
+
+// replaceInvalid returns s with every byte, that isn't a part of valid UTF-8, replaced by U+FFFD
+func replaceInvalid(s string) string {
+	sb := strings.Builder{}
+	for _, r := range s {
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// toJSON returns a string and its raw bytes, if they aren't valid UTF-8
+func toJSON(s string) (string, []byte) {
+	if utf8.ValidString(s) {
+		return s, nil
+	}
+	return replaceInvalid(s), []byte(s)
+}
+
+// fromJSON returns the string stored by toJSON. Raw bytes are used only if the string wasn't edited
+func fromJSON(s string, raw []byte) string {
+	if raw != nil && s == replaceInvalid(string(raw)) {
+		return string(raw)
+	}
+	return s
+}
+
+// WriteJSON writes entries as an indented JSON array
+func WriteJSON(w io.Writer, entries []Entry) error {
+	stored := make([]jsonEntry, len(entries))
+	for k, e := range entries {
+		s := jsonEntry{ID: e.ID, Name: e.Name}
+		s.Text, s.TextRaw = toJSON(e.Text)
+		s.Translation, s.TranslationRaw = toJSON(e.Translation)
+		stored[k] = s
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(stored)
+}
+
+// ReadJSON reads entries written by WriteJSON
+func ReadJSON(r io.Reader) ([]Entry, error) {
+	var stored []jsonEntry
+	if err := json.NewDecoder(r).Decode(&stored); err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, len(stored))
+	for k, s := range stored {
+		entries[k] = Entry{
+			ID:          s.ID,
+			Text:        fromJSON(s.Text, s.TextRaw),
+			Name:        s.Name,
+			Translation: fromJSON(s.Translation, s.TranslationRaw),
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,204 @@
+package translate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WritePO writes entries as a gettext PO file. IDs are stored as msgctxt, so the same text
+// can be translated differently in different places. Strings used as names are marked with
+// a comment for translators
+func WritePO(w io.Writer, entries []Entry, fileName string) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# strings of %s\n", fileName)
+	fmt.Fprintln(bw, `msgid ""`)
+	fmt.Fprintln(bw, `msgstr ""`)
+	fmt.Fprintln(bw, `"Content-Type: text/plain; charset=UTF-8\n"`)
+	for _, e := range entries {
+		fmt.Fprintln(bw)
+		if e.Name {
+			fmt.Fprintln(bw, "#. used as a name by code, don't translate")
+		}
+		fmt.Fprintf(bw, "msgctxt %s\n", quotePO(e.ID))
+		fmt.Fprintf(bw, "msgid %s\n", quotePO(e.Text))
+		fmt.Fprintf(bw, "msgstr %s\n", quotePO(e.Translation))
+	}
+	return bw.Flush()
+}
+
+// quotePO quotes s as a PO string, strings with line breaks are split into lines after them
+func quotePO(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	if len(lines) > 1 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 1 {
+		return escapePO(s)
+	}
+	sb := strings.Builder{}
+	sb.WriteString(`""`)
+	for _, line := range lines {
+		sb.WriteString("\n")
+		sb.WriteString(escapePO(line))
+	}
+	return sb.String()
+}
+
+func escapePO(s string) string {
+	sb := strings.Builder{}
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\r':
+			sb.WriteString(`\r`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&sb, "\\%03o", c)
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// ReadPO reads entries from a PO file written by WritePO. Entries marked as fuzzy,
+// obsolete entries and the header are skipped
+func ReadPO(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	var e Entry
+	var fuzzy, hasID bool
+	// field is the string continuation lines are appended to
+	var field *string
+
+	flush := func() {
+		if hasID && e.ID != "" && !fuzzy {
+			entries = append(entries, e)
+		}
+		e, fuzzy, hasID, field = Entry{}, false, false, nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			flush()
+			continue
+		case strings.HasPrefix(line, "#,"):
+			fuzzy = fuzzy || strings.Contains(line, "fuzzy")
+			continue
+		case strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, `"`):
+			if field == nil {
+				return nil, fmt.Errorf("line %d: string without a keyword", n)
+			}
+			s, err := unescapePO(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", n, err)
+			}
+			*field += s
+			continue
+		}
+
+		keyword, value, _ := strings.Cut(line, " ")
+		switch keyword {
+		case "msgctxt":
+			if hasID {
+				// entries don't have to be separated by blank lines
+				flush()
+			}
+			field = &e.ID
+		case "msgid":
+			field = &e.Text
+			hasID = true
+		case "msgstr":
+			field = &e.Translation
+		default:
+			return nil, fmt.Errorf("line %d: unknown keyword %s", n, keyword)
+		}
+		s, err := unescapePO(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		*field = s
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return entries, nil
+}
+
+func unescapePO(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", fmt.Errorf("expected a quoted string, got %s", s)
+	}
+	s = s[1 : len(s)-1]
+	sb := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", fmt.Errorf("unterminated escape sequence")
+		}
+		switch c = s[i]; c {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case 'r':
+			sb.WriteByte('\r')
+		case 'a':
+			sb.WriteByte('\a')
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'v':
+			sb.WriteByte('\v')
+		case '"', '\\', '\'', '?':
+			sb.WriteByte(c)
+		case 'x':
+			end := i + 1
+			for end < len(s) && end < i+3 && strings.IndexByte("0123456789abcdefABCDEF", s[end]) >= 0 {
+				end++
+			}
+			v, err := strconv.ParseUint(s[i+1:end], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid escape sequence \\%s", s[i:end])
+			}
+			sb.WriteByte(byte(v))
+			i = end - 1
+		default:
+			end := i
+			for end < len(s) && end < i+3 && s[end] >= '0' && s[end] <= '7' {
+				end++
+			}
+			v, err := strconv.ParseUint(s[i:end], 8, 8)
+			if end == i || err != nil {
+				return "", fmt.Errorf("invalid escape sequence \\%s", s[i:max(end, i+1)])
+			}
+			sb.WriteByte(byte(v))
+			i = end - 1
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,124 @@
+// Package translate lists string constants of Lua 5.0 chunks, and replaces them with translations.
+//
+// Strings are identified by the function holding them and their index in its constant table,
+// like "main/0:K3". IDs don't change, as long as the code of the script isn't changed, so
+// translations can be kept in PO or JSON files, and applied again to the original scripts.
+package translate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+)
+
+// Entry is a string constant, with its translation
+type Entry struct {
+	// ID is the function id and the constant index, like "main/0:K3"
+	ID   string `json:"id"`
+	Text string `json:"text"`
+	// Name is set for strings used by code as names of globals, fields or methods,
+	// or compared with values, changing them breaks the script
+	Name        bool   `json:"name,omitempty"`
+	Translation string `json:"translation"`
+}
+
+// ID returns ID of constant k of function with id fn
+func ID(fn string, k int) string {
+	return fn + ":K" + strconv.Itoa(k)
+}
+
+// ParseID splits ID into function id and constant index
+func ParseID(id string) (fn string, k int, err error) {
+	fn, index, ok := strings.Cut(id, ":K")
+	if ok {
+		k, err = strconv.Atoi(index)
+	}
+	if !ok || err != nil || k < 0 {
+		return "", 0, fmt.Errorf("invalid string id %q", id)
+	}
+	return fn, k, nil
+}
+
+// List returns all string constants of c, in order of functions and constant indexes
+func List(c *chunk.Chunk) []Entry {
+	var entries []Entry
+	_ = c.Walk(func(id string, f *chunk.Function) error {
+		names := nameConstants(f)
+		for k, constant := range f.Constants {
+			if constant.Type == chunk.TypeString {
+				entries = append(entries, Entry{ID: ID(id, k), Text: constant.Text, Name: names[k]})
+			}
+		}
+		return nil
+	})
+	return entries
+}
+
+// nameConstants marks constants used as names of globals, table keys, or methods,
+// and constants compared with values, like "menu" in `if state == "menu"`
+func nameConstants(f *chunk.Function) map[int]bool {
+	names := map[int]bool{}
+	mark := func(rk int) {
+		if chunk.IsConstant(rk) {
+			names[chunk.ConstantIndex(rk)] = true
+		}
+	}
+	for _, i := range f.Code {
+		switch i.OpCode() {
+		case chunk.OpGetGlobal, chunk.OpSetGlobal:
+			names[i.Bx()] = true
+		case chunk.OpGetTable, chunk.OpSelf:
+			mark(i.C())
+		case chunk.OpSetTable:
+			mark(i.B())
+		case chunk.OpEq, chunk.OpLt, chunk.OpLe:
+			mark(i.B())
+			mark(i.C())
+		}
+	}
+	return names
+}
+
+// Apply replaces strings of c with translations of entries. Entries without a translation
+// are skipped, entries with Text different from the string in c are rejected, as they
+// were exported from another version of the script. Translations of strings used as names
+// by code are rejected too, whether or not the entry is marked as Name.
+// It returns the number of replaced strings
+func Apply(c *chunk.Chunk, entries []Entry) (int, error) {
+	functions := map[string]*chunk.Function{}
+	names := map[string]map[int]bool{}
+	_ = c.Walk(func(id string, f *chunk.Function) error {
+		functions[id] = f
+		names[id] = nameConstants(f)
+		return nil
+	})
+
+	replaced := 0
+	for _, e := range entries {
+		if e.Translation == "" {
+			continue
+		}
+		fn, k, err := ParseID(e.ID)
+		if err != nil {
+			return replaced, err
+		}
+		f, ok := functions[fn]
+		if !ok || k >= len(f.Constants) || f.Constants[k].Type != chunk.TypeString {
+			return replaced, fmt.Errorf("string %s doesn't exist", e.ID)
+		}
+		if f.Constants[k].Text != e.Text {
+			return replaced, fmt.Errorf("string %s is %q, but the translation is for %q", e.ID, f.Constants[k].Text, e.Text)
+		}
+		if e.Translation == e.Text {
+			continue
+		}
+		if names[fn][k] {
+			return replaced, fmt.Errorf("string %s is used as a name by code, it can't be translated", e.ID)
+		}
+		f.Constants[k].Text = e.Translation
+		replaced++
+	}
+	return replaced, nil
+}
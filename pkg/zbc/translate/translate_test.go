@@ -0,0 +1,171 @@
+package translate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/asm"
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+	"github.com/stretchr/testify/require"
+)
+
+// print("Press \"OK\"\nto start"); menu.title = "Quiz\t1"; if menu.state == "intro" then end
+const listing = `.chunk 5.0 little int=4 size_t=4 instruction=4 number=8 float
+.function main
+.maxstack 2
+.const K0 "print"
+.const K1 "Press \"OK\"\nto start"
+.const K2 "menu"
+.const K3 "title"
+.const K4 "Quiz\t1"
+.const K5 2
+.const K6 "state"
+.const K7 "intro"
+.code
+     1 GETGLOBAL  R0 K0
+     2 LOADK      R1 K1
+     3 CALL       R0 2 1
+     4 GETGLOBAL  R0 K2
+     5 SETTABLE   R0 K3 K4
+     6 GETTABLE   R1 R0 K6
+     7 EQ         0 R1 K7
+     8 JMP        0 @9
+     9 RETURN     R0 1 0
+.end
+`
+
+func assemble(t *testing.T) *chunk.Chunk {
+	t.Helper()
+	c, err := asm.Assemble(strings.NewReader(listing))
+	require.NoError(t, err)
+	return c
+}
+
+func TestList(t *testing.T) {
+	t.Parallel()
+	require.Equal(t, []Entry{
+		{ID: "main:K0", Text: "print", Name: true},
+		{ID: "main:K1", Text: "Press \"OK\"\nto start"},
+		{ID: "main:K2", Text: "menu", Name: true},
+		{ID: "main:K3", Text: "title", Name: true},
+		{ID: "main:K4", Text: "Quiz\t1"},
+		{ID: "main:K6", Text: "state", Name: true},
+		{ID: "main:K7", Text: "intro", Name: true},
+	}, List(assemble(t)))
+}
+
+func TestFormats(t *testing.T) {
+	t.Parallel()
+	entries := List(assemble(t))
+	entries[1].Translation = "Naciśnij \"OK\"\naby zacząć\x01"
+
+	po := bytes.Buffer{}
+	require.NoError(t, WritePO(&po, entries, "quiz.zbc"))
+	require.Contains(t, po.String(), `#. used as a name by code, don't translate
+msgctxt "main:K0"
+msgid "print"
+msgstr ""
+`)
+	require.Contains(t, po.String(), `msgctxt "main:K1"
+msgid ""
+"Press \"OK\"\n"
+"to start"
+msgstr ""
+"Naciśnij \"OK\"\n"
+"aby zacząć\001"
+`)
+	fromPO, err := ReadPO(&po)
+	require.NoError(t, err)
+	require.Len(t, fromPO, len(entries))
+	for k := range entries {
+		require.Equal(t, entries[k].ID, fromPO[k].ID)
+		require.Equal(t, entries[k].Text, fromPO[k].Text)
+		require.Equal(t, entries[k].Translation, fromPO[k].Translation)
+	}
+
+	js := bytes.Buffer{}
+	require.NoError(t, WriteJSON(&js, entries))
+	fromJSON, err := ReadJSON(&js)
+	require.NoError(t, err)
+	require.Equal(t, entries, fromJSON)
+}
+
+func TestReadPO(t *testing.T) {
+	t.Parallel()
+	entries, err := ReadPO(strings.NewReader(`msgid ""
+msgstr "Language: pl\n"
+
+#, fuzzy
+msgctxt "main:K1"
+msgid "Start"
+msgstr "Zacznij?"
+msgctxt "main/0:K2"
+msgid "\x41\102"
+msgstr "ok"
+#~ msgctxt "main:K9"
+#~ msgid "old"
+#~ msgstr "stary"
+`))
+	require.NoError(t, err)
+	require.Equal(t, []Entry{{ID: "main/0:K2", Text: "AB", Translation: "ok"}}, entries)
+
+	_, err = ReadPO(strings.NewReader("msgid \"\\q\"\n"))
+	require.EqualError(t, err, `line 1: invalid escape sequence \q`)
+}
+
+func TestApply(t *testing.T) {
+	t.Parallel()
+	c := assemble(t)
+	n, err := Apply(c, []Entry{
+		{ID: "main:K1", Text: "Press \"OK\"\nto start", Translation: "Naciśnij OK"},
+		{ID: "main:K4", Text: "Quiz\t1"},
+		{ID: "main:K3", Text: "title", Translation: "title"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	// the chunk is serialized again with the new string lengths
+	buf := bytes.Buffer{}
+	require.NoError(t, chunk.Encode(&buf, c))
+	decoded, err := chunk.Decode(&buf)
+	require.NoError(t, err)
+	require.Equal(t, chunk.StringConstant("Naciśnij OK"), decoded.Main.Constants[1])
+
+	cases := []struct {
+		entry    Entry
+		expected string
+	}{
+		{Entry{ID: "main:K1", Text: "Press", Translation: "x"}, `string main:K1 is "Naciśnij OK", but the translation is for "Press"`},
+		{Entry{ID: "main:K5", Text: "2", Translation: "x"}, "string main:K5 doesn't exist"},
+		{Entry{ID: "main/3:K0", Text: "x", Translation: "x"}, "string main/3:K0 doesn't exist"},
+		{Entry{ID: "K0", Text: "x", Translation: "x"}, `invalid string id "K0"`},
+		{Entry{ID: "main:K3", Text: "title", Translation: "tytuł"}, "string main:K3 is used as a name by code, it can't be translated"},
+		{Entry{ID: "main:K7", Text: "intro", Translation: "wstęp"}, "string main:K7 is used as a name by code, it can't be translated"},
+	}
+	for _, tt := range cases {
+		_, err := Apply(c, []Entry{tt.entry})
+		require.EqualError(t, err, tt.expected)
+	}
+}
+
+func TestJSONCodepage(t *testing.T) {
+	t.Parallel()
+	// strings in single-byte codepages aren't valid UTF-8
+	entries := []Entry{{ID: "main:K0", Text: "caf\xe9", Translation: "kawiarni\xea"}, {ID: "main:K1", Text: "tea"}}
+	js := bytes.Buffer{}
+	require.NoError(t, WriteJSON(&js, entries))
+	require.Contains(t, js.String(), `"text": "caf`+"�"+`",
+    "text_raw": "Y2Fm6Q==",`)
+	require.NotContains(t, js.String(), `"text_raw": "dGVh"`)
+	fromJSON, err := ReadJSON(bytes.NewReader(js.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, entries, fromJSON)
+
+	// edited strings replace their raw bytes
+	edited := strings.Replace(js.String(), `"translation": "kawiarni`+"�"+`"`, `"translation": "kawiarnia"`, 1)
+	fromJSON, err = ReadJSON(strings.NewReader(edited))
+	require.NoError(t, err)
+	require.Equal(t, "caf\xe9", fromJSON[0].Text)
+	require.Equal(t, "kawiarnia", fromJSON[0].Translation)
+}
@@ -0,0 +1,317 @@
+package zbc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+)
+
+// A VerifyError reports a problem in a function, that would make the console misbehave or crash
+type VerifyError struct {
+	// Function names the function like chunk.Walk does, e.g. "main/0"
+	Function string
+	// PC is the 0-based index of the instruction, or -1 when the problem is not about a single instruction
+	PC  int
+	Msg string
+}
+
+func (e *VerifyError) Error() string {
+	if e.PC < 0 {
+		return fmt.Sprintf("gamewave zbc error: function %s: %s", e.Function, e.Msg)
+	}
+	return fmt.Sprintf("gamewave zbc error: function %s, instruction %d: %s", e.Function, e.PC+1, e.Msg)
+}
+
+// Verify checks unpacked bytecode before it's loaded by the console. Truncated or malformed
+// prototypes are reported with the error from chunk.Decode, problems with the code are
+// reported as *VerifyError, joined with errors.Join
+func Verify(bytecode []byte) error {
+	c, err := chunk.Decode(bytes.NewReader(bytecode))
+	if err != nil {
+		return err
+	}
+	return VerifyChunk(c)
+}
+
+// VerifyChunk checks all functions of a parsed chunk, like Verify does
+func VerifyChunk(c *chunk.Chunk) error {
+	var errs []error
+	_ = c.Walk(func(id string, f *chunk.Function) error {
+		v := verifier{id: id, f: f}
+		v.function()
+		errs = append(errs, v.errs...)
+		return nil
+	})
+	return errors.Join(errs...)
+}
+
+// verifier follows checks done by luaG_checkcode of Lua 5.0. Uses of results of open calls
+// are checked more strictly, so they can't reach past the top of the stack
+type verifier struct {
+	id   string
+	f    *chunk.Function
+	errs []error
+	// pseudo marks instructions describing upvalues of a preceding CLOSURE
+	pseudo []bool
+	// targets marks instructions reached by jumps, or by skipping the previous instruction
+	targets []bool
+}
+
+func (v *verifier) fail(pc int, format string, args ...any) {
+	err := &VerifyError{Function: v.id, PC: pc, Msg: fmt.Sprintf(format, args...)}
+	// instructions like CONCAT R2 R2 R3 would report the same register twice
+	if n := len(v.errs); n > 0 && *v.errs[n-1].(*VerifyError) == *err {
+		return
+	}
+	v.errs = append(v.errs, err)
+}
+
+func (v *verifier) function() {
+	f := v.f
+	maxStack := int(f.MaxStackSize)
+	if maxStack > chunk.MaxStack {
+		v.fail(-1, "stack size %d exceeds the limit of %d", maxStack, chunk.MaxStack)
+	}
+	params := int(f.NumParams)
+	if f.IsVararg {
+		params++
+	}
+	if params > maxStack {
+		v.fail(-1, "stack size %d is too small for %d parameters", maxStack, params)
+	}
+	if len(f.LineInfo) != 0 && len(f.LineInfo) != len(f.Code) {
+		v.fail(-1, "line info has %d entries for %d instructions", len(f.LineInfo), len(f.Code))
+	}
+	if len(f.Upvalues) != 0 && len(f.Upvalues) != int(f.NumUpvalues) {
+		v.fail(-1, "%d upvalue names for %d upvalues", len(f.Upvalues), f.NumUpvalues)
+	}
+	for _, l := range f.Locals {
+		if l.StartPC < 0 || l.StartPC > l.EndPC || l.EndPC > len(f.Code) {
+			v.fail(-1, "local %q has invalid range %d-%d", l.Name, l.StartPC, l.EndPC)
+		}
+	}
+
+	if len(f.Code) == 0 {
+		v.fail(-1, "function has no code")
+		return
+	}
+	if last := f.Code[len(f.Code)-1]; last.OpCode() != chunk.OpReturn {
+		v.fail(len(f.Code)-1, "function doesn't end with RETURN")
+	}
+
+	v.pseudo = make([]bool, len(f.Code))
+	for pc := 0; pc < len(f.Code); pc++ {
+		i := f.Code[pc]
+		if i.OpCode() != chunk.OpClosure || i.Bx() >= len(f.Functions) {
+			continue
+		}
+		nups := int(f.Functions[i.Bx()].NumUpvalues)
+		for j := 1; j <= nups; j++ {
+			if pc+j >= len(f.Code) {
+				v.fail(pc, "CLOSURE is missing %d upvalue instructions", nups-j+1)
+				break
+			}
+			v.pseudo[pc+j] = true
+			v.upvalueSource(pc+j, f.Code[pc+j])
+		}
+		pc += nups
+	}
+
+	v.targets = make([]bool, len(f.Code))
+	for pc, i := range f.Code {
+		op := i.OpCode()
+		if v.pseudo[pc] || !op.Valid() {
+			continue
+		}
+		target := -1
+		if info := op.Info(); info.Mode == chunk.ModeAsBx && info.B == chunk.ArgJump {
+			target = pc + 1 + i.SBx()
+		} else if info.Test || op == chunk.OpTForLoop || (op == chunk.OpLoadBool && i.C() != 0) {
+			target = pc + 2
+		}
+		if target >= 0 && target < len(f.Code) {
+			v.targets[target] = true
+		}
+	}
+
+	for pc, i := range f.Code {
+		if !v.pseudo[pc] {
+			v.instruction(pc, i)
+		}
+	}
+}
+
+// upvalueSource checks instruction following CLOSURE, which names the value captured by the closure
+func (v *verifier) upvalueSource(pc int, i chunk.Instruction) {
+	switch i.OpCode() {
+	case chunk.OpMove:
+		v.register(pc, i.B())
+	case chunk.OpGetUpval:
+		v.operand(pc, chunk.ArgUpvalue, i.B())
+	default:
+		v.fail(pc, "%s can't describe an upvalue of CLOSURE", i.OpCode())
+	}
+}
+
+func (v *verifier) instruction(pc int, i chunk.Instruction) {
+	op := i.OpCode()
+	if !op.Valid() {
+		v.fail(pc, "invalid opcode %d", op)
+		return
+	}
+	info := op.Info()
+	v.operand(pc, info.A, i.A())
+	switch info.Mode {
+	case chunk.ModeABC:
+		v.operand(pc, info.B, i.B())
+		v.operand(pc, info.C, i.C())
+	case chunk.ModeABx:
+		v.operand(pc, info.B, i.Bx())
+	case chunk.ModeAsBx:
+		v.operand(pc, info.B, i.SBx())
+	}
+
+	code := v.f.Code
+	if info.Test && (pc+1 >= len(code) || code[pc+1].OpCode() != chunk.OpJmp) {
+		v.fail(pc, "%s isn't followed by JMP", op)
+	}
+
+	switch op {
+	case chunk.OpGetGlobal, chunk.OpSetGlobal:
+		if i.Bx() < len(v.f.Constants) && v.f.Constants[i.Bx()].Type != chunk.TypeString {
+			v.fail(pc, "global name K%d is not a string", i.Bx())
+		}
+	case chunk.OpLoadBool:
+		if i.C() != 0 && pc+2 >= len(code) {
+			v.fail(pc, "LOADBOOL skips past the end of the function")
+		}
+	case chunk.OpLoadNil:
+		if i.B() < i.A() {
+			v.fail(pc, "LOADNIL range R%d-R%d is empty", i.A(), i.B())
+		}
+	case chunk.OpSelf:
+		v.register(pc, i.A()+1)
+	case chunk.OpConcat:
+		if i.B() >= i.C() {
+			v.fail(pc, "CONCAT range R%d-R%d is empty", i.B(), i.C())
+		}
+	case chunk.OpCall, chunk.OpTailCall:
+		if i.B() > 0 {
+			v.register(pc, i.A()+i.B()-1)
+		}
+		if i.C() > 1 {
+			v.register(pc, i.A()+i.C()-2)
+		}
+		if i.B() == 0 {
+			v.openInput(pc)
+		}
+		if i.C() == 0 {
+			v.openOutput(pc)
+		}
+	case chunk.OpReturn:
+		if i.B() > 1 {
+			v.register(pc, i.A()+i.B()-2)
+		}
+		if i.B() == 0 {
+			v.openInput(pc)
+		}
+	case chunk.OpSetListO:
+		v.openInput(pc)
+	case chunk.OpForLoop:
+		v.register(pc, i.A()+2)
+	case chunk.OpTForLoop:
+		// the iterator is called with its state and control variable copied above the loop variables
+		v.register(pc, i.A()+i.C()+5)
+	case chunk.OpTForPrep:
+		v.register(pc, i.A()+1)
+	case chunk.OpSetList:
+		v.register(pc, i.A()+i.Bx()%chunk.FieldsPerFlush+1)
+	}
+}
+
+// isOpenCall reports whether i is a call returning all its results, setting the top of the stack
+func isOpenCall(i chunk.Instruction) bool {
+	op := i.OpCode()
+	return (op == chunk.OpCall || op == chunk.OpTailCall) && i.C() == 0
+}
+
+// openOutput checks that results of the open call at pc are used by the next instruction,
+// like checkopenop does
+func (v *verifier) openOutput(pc int) {
+	code := v.f.Code
+	if pc+1 < len(code) {
+		switch next := code[pc+1]; next.OpCode() {
+		case chunk.OpCall, chunk.OpTailCall, chunk.OpReturn:
+			if next.B() == 0 {
+				return
+			}
+		case chunk.OpSetListO:
+			return
+		}
+	}
+	v.fail(pc, "results of %s aren't used by the next instruction", code[pc].OpCode())
+}
+
+// openInput checks that the instruction at pc, using values up to the top of the stack,
+// follows an open call setting it, with results of the call being the last of these values
+func (v *verifier) openInput(pc int) {
+	i := v.f.Code[pc]
+	op := i.OpCode()
+	if pc == 0 || !isOpenCall(v.f.Code[pc-1]) {
+		v.fail(pc, "%s uses the top of the stack, but doesn't follow a call setting it", op)
+		return
+	}
+	if v.targets[pc] {
+		v.fail(pc, "%s uses the top of the stack, but can be reached without the call setting it", op)
+	}
+	// the first register of values used by the instruction
+	first := i.A() + 1
+	if op == chunk.OpReturn {
+		first = i.A()
+	}
+	if call := v.f.Code[pc-1]; first > call.A() {
+		v.fail(pc, "%s uses values from R%d, but results of the call start at R%d", op, first, call.A())
+	}
+}
+
+func (v *verifier) operand(pc int, mode chunk.ArgMode, x int) {
+	f := v.f
+	switch mode {
+	case chunk.ArgRegister:
+		v.register(pc, x)
+	case chunk.ArgRK:
+		if !chunk.IsConstant(x) {
+			v.register(pc, x)
+			return
+		}
+		v.operand(pc, chunk.ArgConstant, chunk.ConstantIndex(x))
+	case chunk.ArgConstant:
+		if x >= len(f.Constants) {
+			v.fail(pc, "constant K%d is out of range, function has %d constants", x, len(f.Constants))
+		}
+	case chunk.ArgUpvalue:
+		if x >= int(f.NumUpvalues) {
+			v.fail(pc, "upvalue U%d is out of range, function has %d upvalues", x, f.NumUpvalues)
+		}
+	case chunk.ArgFunction:
+		if x >= len(f.Functions) {
+			v.fail(pc, "function F%d is out of range, function has %d nested functions", x, len(f.Functions))
+		}
+	case chunk.ArgJump:
+		target := pc + 1 + x
+		switch {
+		case target < 0 || target >= len(f.Code):
+			v.fail(pc, "jump target %d is outside of the function", target+1)
+		case v.pseudo[target]:
+			v.fail(pc, "jump target %d is in the middle of CLOSURE", target+1)
+		}
+	}
+}
+
+func (v *verifier) register(pc, r int) {
+	if r >= int(v.f.MaxStackSize) {
+		v.fail(pc, "register R%d is out of range, stack size is %d", r, v.f.MaxStackSize)
+	}
+}
@@ -0,0 +1,213 @@
+package zbc
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/asm"
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+	"github.com/stretchr/testify/require"
+)
+
+// local t = {}; function f(a) t[a] = print(a .. "!") end
+const verifyListing = `.chunk 5.0 little int=4 size_t=4 instruction=4 number=8 float
+.function main
+.maxstack 2
+.const K0 "f"
+.code
+     1 NEWTABLE   R0 0 0
+     2 CLOSURE    R1 F0
+     3 MOVE       0 R0 0
+     4 SETGLOBAL  R1 K0
+     5 RETURN     R0 1 0
+.end
+
+.function main/0
+.upvalues 1
+.params 1
+.maxstack 4
+.const K0 "print"
+.const K1 "!"
+.code
+     1 GETGLOBAL  R1 K0
+     2 MOVE       R2 R0 0
+     3 LOADK      R3 K1
+     4 CONCAT     R2 R2 R3
+     5 CALL       R1 2 2
+     6 GETUPVAL   R2 U0 0
+     7 SETTABLE   R2 R0 R1
+     8 EQ         1 R0 K1
+     9 JMP        0 @1
+    10 RETURN     R0 1 0
+.end
+`
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name     string
+		modify   func(c *chunk.Chunk)
+		expected []string
+	}{
+		{
+			name:   "valid",
+			modify: func(c *chunk.Chunk) {},
+		},
+		{
+			name: "register out of range",
+			modify: func(c *chunk.Chunk) {
+				c.Main.Functions[0].Code[1] = chunk.NewABC(chunk.OpMove, 2, 4, 0)
+			},
+			expected: []string{"function main/0, instruction 2: register R4 is out of range, stack size is 4"},
+		},
+		{
+			name: "constant out of range",
+			modify: func(c *chunk.Chunk) {
+				c.Main.Functions[0].Code[6] = chunk.NewABC(chunk.OpSetTable, 2, chunk.MaxStack+2, 1)
+			},
+			expected: []string{"function main/0, instruction 7: constant K2 is out of range, function has 2 constants"},
+		},
+		{
+			name: "global name is not a string",
+			modify: func(c *chunk.Chunk) {
+				c.Main.Constants[0] = chunk.NumberConstant(1)
+			},
+			expected: []string{"function main, instruction 4: global name K0 is not a string"},
+		},
+		{
+			name: "bad jump targets",
+			modify: func(c *chunk.Chunk) {
+				c.Main.Functions[0].Code[8] = chunk.NewAsBx(chunk.OpJmp, 0, 1)
+				c.Main.Code[0] = chunk.NewAsBx(chunk.OpJmp, 0, 1)
+			},
+			expected: []string{
+				"function main, instruction 1: jump target 3 is in the middle of CLOSURE",
+				"function main/0, instruction 9: jump target 11 is outside of the function",
+			},
+		},
+		{
+			name: "generic for near the top of the stack",
+			modify: func(c *chunk.Chunk) {
+				c.Main.Functions[0].Code[7] = chunk.NewABC(chunk.OpTForLoop, 0, 0, 0)
+			},
+			expected: []string{"function main/0, instruction 8: register R5 is out of range, stack size is 4"},
+		},
+		{
+			name: "test without jump",
+			modify: func(c *chunk.Chunk) {
+				c.Main.Functions[0].Code[8] = chunk.NewABC(chunk.OpClose, 0, 0, 0)
+			},
+			expected: []string{"function main/0, instruction 8: EQ isn't followed by JMP"},
+		},
+		{
+			name: "stack size overflow",
+			modify: func(c *chunk.Chunk) {
+				c.Main.MaxStackSize = 251
+				c.Main.Functions[0].NumParams = 4
+				c.Main.Functions[0].IsVararg = true
+			},
+			expected: []string{
+				"function main: stack size 251 exceeds the limit of 250",
+				"function main/0: stack size 4 is too small for 5 parameters",
+			},
+		},
+		{
+			name: "missing upvalue and return",
+			modify: func(c *chunk.Chunk) {
+				c.Main.Code[2] = chunk.NewABC(chunk.OpGetUpval, 0, 0, 0)
+				c.Main.Functions[0].Code = c.Main.Functions[0].Code[:9]
+			},
+			expected: []string{
+				"function main, instruction 3: upvalue U0 is out of range, function has 0 upvalues",
+				"function main/0, instruction 9: function doesn't end with RETURN",
+			},
+		},
+		{
+			name: "open call followed by another instruction",
+			modify: func(c *chunk.Chunk) {
+				c.Main.Functions[0].Code[4] = chunk.NewABC(chunk.OpCall, 1, 2, 0)
+			},
+			expected: []string{"function main/0, instruction 5: results of CALL aren't used by the next instruction"},
+		},
+		{
+			name: "open call used by return",
+			modify: func(c *chunk.Chunk) {
+				f := c.Main.Functions[0]
+				f.Code = append(f.Code[:5:5], chunk.NewABC(chunk.OpReturn, 1, 0, 0))
+				f.Code[4] = chunk.NewABC(chunk.OpCall, 1, 2, 0)
+			},
+		},
+		{
+			name: "open instructions without a call",
+			modify: func(c *chunk.Chunk) {
+				c.Main.Code[3] = chunk.NewABx(chunk.OpSetListO, 0, 0)
+				c.Main.Code[4] = chunk.NewABC(chunk.OpReturn, 0, 0, 0)
+				c.Main.Functions[0].Code[4] = chunk.NewABC(chunk.OpCall, 1, 0, 2)
+			},
+			expected: []string{
+				"function main, instruction 4: SETLISTO uses the top of the stack, but doesn't follow a call setting it",
+				"function main, instruction 5: RETURN uses the top of the stack, but doesn't follow a call setting it",
+				"function main/0, instruction 5: CALL uses the top of the stack, but doesn't follow a call setting it",
+			},
+		},
+		{
+			name: "jump to an instruction using results of open call",
+			modify: func(c *chunk.Chunk) {
+				f := c.Main.Functions[0]
+				f.Code[4] = chunk.NewABC(chunk.OpCall, 1, 2, 0)
+				f.Code[5] = chunk.NewABC(chunk.OpReturn, 1, 0, 0)
+				f.Code[8] = chunk.NewAsBx(chunk.OpJmp, 0, -4)
+			},
+			expected: []string{"function main/0, instruction 6: RETURN uses the top of the stack, but can be reached without the call setting it"},
+		},
+		{
+			name: "results of open call below used values",
+			modify: func(c *chunk.Chunk) {
+				f := c.Main.Functions[0]
+				f.Code[4] = chunk.NewABC(chunk.OpCall, 1, 2, 0)
+				f.Code[5] = chunk.NewABC(chunk.OpCall, 2, 0, 1)
+			},
+			expected: []string{"function main/0, instruction 6: CALL uses values from R3, but results of the call start at R1"},
+		},
+	}
+
+	for _, tt := range cases {
+		modify := tt.modify
+		expected := tt.expected
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c, err := asm.Assemble(strings.NewReader(verifyListing))
+			require.NoError(t, err)
+			modify(c)
+			buf := bytes.Buffer{}
+			require.NoError(t, chunk.Encode(&buf, c))
+
+			err = Verify(buf.Bytes())
+			if len(expected) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			var verifyErr *VerifyError
+			require.ErrorAs(t, err, &verifyErr)
+			for _, msg := range expected {
+				require.ErrorContains(t, err, msg)
+			}
+			require.Len(t, strings.Split(err.Error(), "\n"), len(expected))
+		})
+	}
+}
+
+func TestVerifyTruncated(t *testing.T) {
+	t.Parallel()
+	c, err := asm.Assemble(strings.NewReader(verifyListing))
+	require.NoError(t, err)
+	buf := bytes.Buffer{}
+	require.NoError(t, chunk.Encode(&buf, c))
+
+	err = Verify(buf.Bytes()[:buf.Len()-10])
+	var formatErr chunk.FormatError
+	require.True(t, errors.As(err, &formatErr), "expected chunk.FormatError, got %v", err)
+}
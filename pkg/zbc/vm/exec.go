@@ -0,0 +1,360 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+)
+
+// frame holds state of a running Lua function
+type frame struct {
+	cl   *Closure
+	p    *chunk.Function
+	id   string
+	regs []Value
+	// top is the end of values produced by the last multiple results call or vararg
+	top int
+	// pc is the index of the instruction being executed
+	pc   int
+	open []*upvalue
+}
+
+// errorf returns *RuntimeError at the current instruction, or a plain error outside of Lua code
+func (fr *frame) errorf(format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if fr == nil {
+		return errors.New(msg)
+	}
+	return &RuntimeError{Function: fr.id, PC: fr.pc + 1, Line: fr.p.Line(fr.pc), Msg: msg}
+}
+
+// grow makes sure registers up to n exist, multiple results may not fit into the frame
+func (fr *frame) grow(n int) {
+	if n > len(fr.regs) {
+		fr.regs = append(fr.regs, make([]Value, n-len(fr.regs))...)
+	}
+}
+
+func (fr *frame) rk(x int) Value {
+	if chunk.IsConstant(x) {
+		return constant(fr.p.Constants[chunk.ConstantIndex(x)])
+	}
+	return fr.regs[x]
+}
+
+// findUpvalue returns open upvalue for register r, creating it when needed
+func (fr *frame) findUpvalue(r int) *upvalue {
+	for _, u := range fr.open {
+		if u.index == r {
+			return u
+		}
+	}
+	u := &upvalue{frame: fr, index: r}
+	fr.open = append(fr.open, u)
+	return u
+}
+
+// closeUpvalues detaches upvalues of registers from r up, they keep their current values
+func (fr *frame) closeUpvalues(r int) {
+	open := fr.open[:0]
+	for _, u := range fr.open {
+		if u.index >= r {
+			u.value = fr.regs[u.index]
+			u.frame = nil
+			continue
+		}
+		open = append(open, u)
+	}
+	fr.open = open
+}
+
+func constant(k chunk.Constant) Value {
+	switch k.Type {
+	case chunk.TypeNumber:
+		return k.Number
+	case chunk.TypeString:
+		return k.Text
+	}
+	return nil
+}
+
+// execute runs a Lua function, code has to pass zbc.Verify, otherwise it may panic
+func (vm *VM) execute(cl *Closure, args []Value) ([]Value, error) {
+	p := cl.Proto
+	fr := &frame{cl: cl, p: p, id: vm.ids[p]}
+	fr.regs = make([]Value, max(int(p.MaxStackSize), int(p.NumParams)+1))
+	copy(fr.regs[:p.NumParams], args)
+	if p.IsVararg {
+		// extra arguments are passed in the arg table
+		t := NewTable()
+		n := 0
+		if len(args) > int(p.NumParams) {
+			for k, v := range args[p.NumParams:] {
+				_ = t.Set(float64(k+1), v)
+			}
+			n = len(args) - int(p.NumParams)
+		}
+		_ = t.Set("n", float64(n))
+		fr.regs[p.NumParams] = t
+	}
+	defer fr.closeUpvalues(0)
+
+	code := p.Code
+	for next := 0; ; {
+		if next >= len(code) {
+			return nil, fr.errorf("execution fell off the end of the function")
+		}
+		fr.pc = next
+		i := code[next]
+		next++
+
+		vm.steps++
+		if vm.cfg.MaxSteps > 0 && vm.steps > vm.cfg.MaxSteps {
+			e := fr.errorf("%s", ErrStepLimit)
+			e.(*RuntimeError).Err = ErrStepLimit
+			return nil, e
+		}
+		if vm.cfg.Hook != nil {
+			if err := vm.cfg.Hook(vm, fr.id, fr.pc+1); err != nil {
+				return nil, err
+			}
+		}
+
+		a := i.A()
+		regs := fr.regs
+		var err error
+		switch i.OpCode() {
+		case chunk.OpMove:
+			regs[a] = regs[i.B()]
+		case chunk.OpLoadK:
+			regs[a] = constant(p.Constants[i.Bx()])
+		case chunk.OpLoadBool:
+			regs[a] = i.B() != 0
+			if i.C() != 0 {
+				next++
+			}
+		case chunk.OpLoadNil:
+			for r := a; r <= i.B(); r++ {
+				regs[r] = nil
+			}
+		case chunk.OpGetUpval:
+			regs[a] = cl.upvalues[i.B()].get()
+		case chunk.OpGetGlobal:
+			regs[a], err = vm.index(fr, vm.globals, constant(p.Constants[i.Bx()]), -1)
+		case chunk.OpGetTable:
+			regs[a], err = vm.index(fr, regs[i.B()], fr.rk(i.C()), i.B())
+		case chunk.OpSetGlobal:
+			err = vm.setIndex(fr, vm.globals, constant(p.Constants[i.Bx()]), regs[a], -1)
+		case chunk.OpSetUpval:
+			cl.upvalues[i.B()].set(regs[a])
+		case chunk.OpSetTable:
+			err = vm.setIndex(fr, regs[a], fr.rk(i.B()), fr.rk(i.C()), a)
+		case chunk.OpNewTable:
+			regs[a] = NewTable()
+		case chunk.OpSelf:
+			obj := regs[i.B()]
+			regs[a+1] = obj
+			if name := vm.stubName(fr, obj, i.B()); name != "" {
+				if key, ok := fr.rk(i.C()).(string); ok {
+					regs[a] = vm.stub(name + ":" + key)
+					break
+				}
+			}
+			regs[a], err = vm.index(fr, obj, fr.rk(i.C()), i.B())
+		case chunk.OpAdd, chunk.OpSub, chunk.OpMul, chunk.OpDiv, chunk.OpPow:
+			regs[a], err = vm.arith(fr, i.OpCode(), i.B(), i.C())
+		case chunk.OpUnm:
+			regs[a], err = vm.arith(fr, i.OpCode(), i.B(), i.B())
+		case chunk.OpNot:
+			regs[a] = !Truthy(regs[i.B()])
+		case chunk.OpConcat:
+			regs[a], err = vm.concat(fr, i.B(), i.C())
+		case chunk.OpJmp:
+			next += i.SBx()
+		case chunk.OpEq, chunk.OpLt, chunk.OpLe:
+			var ok bool
+			ok, err = vm.compare(fr, i.OpCode(), i.B(), i.C())
+			if err == nil && ok != (a != 0) {
+				// skip the jump
+				next++
+			}
+		case chunk.OpTest:
+			if v := regs[i.B()]; Truthy(v) == (i.C() != 0) {
+				regs[a] = v
+			} else {
+				next++
+			}
+		case chunk.OpCall, chunk.OpTailCall:
+			// tail calls are executed as normal calls, RETURN following them passes the results
+			c := i.C()
+			if i.OpCode() == chunk.OpTailCall {
+				c = 0
+			}
+			err = vm.callInstruction(fr, a, i.B(), c)
+		case chunk.OpReturn:
+			end := fr.top
+			if i.B() != 0 {
+				end = a + i.B() - 1
+			}
+			return append([]Value(nil), fr.regs[a:end]...), nil
+		case chunk.OpForLoop:
+			next, err = vm.forLoop(fr, a, next, i.SBx())
+		case chunk.OpTForLoop:
+			nvars := i.C() + 1
+			var results []Value
+			results, err = vm.call(regs[a], []Value{regs[a+1], regs[a+2]}, fr)
+			regs = fr.regs
+			for k := 0; k < nvars; k++ {
+				regs[a+2+k] = nil
+				if k < len(results) {
+					regs[a+2+k] = results[k]
+				}
+			}
+			if err == nil {
+				if regs[a+2] == nil {
+					next++
+				} else {
+					next += code[next].SBx() + 1
+				}
+			}
+		case chunk.OpTForPrep:
+			if t, ok := regs[a].(*Table); ok {
+				// for k, v in t do is the same as for k, v in next, t do
+				regs[a+1] = t
+				regs[a] = vm.globals.Get("next")
+				if regs[a] == nil {
+					regs[a] = vm.next
+				}
+			}
+			next += i.SBx()
+		case chunk.OpSetList, chunk.OpSetListO:
+			err = vm.setList(fr, i)
+		case chunk.OpClose:
+			fr.closeUpvalues(a)
+		case chunk.OpClosure:
+			proto := p.Functions[i.Bx()]
+			nc := &Closure{Proto: proto, upvalues: make([]*upvalue, proto.NumUpvalues)}
+			for k := range nc.upvalues {
+				pseudo := code[next]
+				next++
+				if pseudo.OpCode() == chunk.OpGetUpval {
+					nc.upvalues[k] = cl.upvalues[pseudo.B()]
+				} else {
+					nc.upvalues[k] = fr.findUpvalue(pseudo.B())
+				}
+			}
+			regs[a] = nc
+		default:
+			err = fr.errorf("invalid opcode %d", i.OpCode())
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// builtinNext implements next, which generic for uses for tables, when the host doesn't define it
+func builtinNext(_ *VM, args []Value) ([]Value, error) {
+	t, ok := arg(args, 0).(*Table)
+	if !ok {
+		return nil, fmt.Errorf("bad argument #1 to next (table expected, got %s)", TypeName(arg(args, 0)))
+	}
+	k, v, ok, err := t.Next(arg(args, 1))
+	if err != nil || !ok {
+		return []Value{nil}, err
+	}
+	return []Value{k, v}, nil
+}
+
+func arg(args []Value, n int) Value {
+	if n < len(args) {
+		return args[n]
+	}
+	return nil
+}
+
+func (vm *VM) callInstruction(fr *frame, a, b, c int) error {
+	end := fr.top
+	if b != 0 {
+		end = a + b
+	}
+	fn := fr.regs[a]
+	args := append([]Value(nil), fr.regs[a+1:end]...)
+
+	if fn == nil && vm.cfg.Unknown != nil {
+		if name := vm.path(fr, fr.pc, a); name != "" {
+			fn = vm.stub(name)
+		}
+	}
+	if !callable(fn) {
+		return fr.errorf("attempt to call %s", vm.describe(fr, a, fn))
+	}
+	results, err := vm.call(fn, args, fr)
+	if err != nil {
+		return err
+	}
+
+	if c == 0 {
+		fr.grow(a + len(results))
+		copy(fr.regs[a:], results)
+		fr.top = a + len(results)
+		return nil
+	}
+	for k := 0; k < c-1; k++ {
+		fr.regs[a+k] = arg(results, k)
+	}
+	return nil
+}
+
+func callable(fn Value) bool {
+	switch fn := fn.(type) {
+	case *Closure, *HostFunction, *Stub:
+		return true
+	case *Table:
+		return fn.metamethod("__call") != nil
+	}
+	return false
+}
+
+func (vm *VM) forLoop(fr *frame, a, next, jump int) (int, error) {
+	regs := fr.regs
+	idx, ok := regs[a].(float64)
+	if !ok {
+		return next, fr.errorf("`for' initial value must be a number")
+	}
+	limit, ok := ToNumber(regs[a+1])
+	if !ok {
+		return next, fr.errorf("`for' limit must be a number")
+	}
+	step, ok := ToNumber(regs[a+2])
+	if !ok {
+		return next, fr.errorf("`for' step must be a number")
+	}
+	idx += step
+	if (step > 0 && idx <= limit) || (step <= 0 && idx >= limit) {
+		regs[a] = idx
+		return next + jump, nil
+	}
+	return next, nil
+}
+
+func (vm *VM) setList(fr *frame, i chunk.Instruction) error {
+	a := i.A()
+	t, ok := fr.regs[a].(*Table)
+	if !ok {
+		return fr.errorf("SETLIST on a %s value", TypeName(fr.regs[a]))
+	}
+	bx := i.Bx()
+	n := bx%chunk.FieldsPerFlush + 1
+	if i.OpCode() == chunk.OpSetListO {
+		n = fr.top - a - 1
+	}
+	first := bx - bx%chunk.FieldsPerFlush
+	for k := 1; k <= n; k++ {
+		if err := t.Set(float64(first+k), fr.regs[a+k]); err != nil {
+			return fr.errorf("%s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+)
+
+// protoInfo holds results of analysis of function code, used to name values in registers
+type protoInfo struct {
+	// targets marks instructions, that can be reached from other places than the previous instruction
+	targets []bool
+	// pseudo marks instructions describing upvalues of a preceding CLOSURE
+	pseudo []bool
+}
+
+func (vm *VM) protoInfo(p *chunk.Function) *protoInfo {
+	if info, ok := vm.infos[p]; ok {
+		return info
+	}
+	info := &protoInfo{targets: make([]bool, len(p.Code)+2), pseudo: make([]bool, len(p.Code))}
+	for pc := 0; pc < len(p.Code); pc++ {
+		i := p.Code[pc]
+		switch op := i.OpCode(); {
+		case op.Info().B == chunk.ArgJump:
+			if t := pc + 1 + i.SBx(); t >= 0 && t < len(info.targets) {
+				info.targets[t] = true
+			}
+		case op == chunk.OpLoadBool && i.C() != 0:
+			info.targets[pc+2] = true
+		case op == chunk.OpClosure && i.Bx() < len(p.Functions):
+			for k := 0; k < int(p.Functions[i.Bx()].NumUpvalues) && pc+1 < len(p.Code); k++ {
+				pc++
+				info.pseudo[pc] = true
+			}
+		}
+	}
+	vm.infos[p] = info
+	return info
+}
+
+// writes reports whether instruction i sets register r
+func writes(i chunk.Instruction, r int) bool {
+	a := i.A()
+	switch i.OpCode() {
+	case chunk.OpMove, chunk.OpLoadK, chunk.OpLoadBool, chunk.OpGetUpval, chunk.OpGetGlobal, chunk.OpGetTable,
+		chunk.OpNewTable, chunk.OpAdd, chunk.OpSub, chunk.OpMul, chunk.OpDiv, chunk.OpPow, chunk.OpUnm,
+		chunk.OpNot, chunk.OpConcat, chunk.OpTest, chunk.OpForLoop, chunk.OpClosure:
+		return r == a
+	case chunk.OpLoadNil:
+		return r >= a && r <= i.B()
+	case chunk.OpSelf, chunk.OpTForPrep:
+		return r == a || r == a+1
+	case chunk.OpCall, chunk.OpTailCall:
+		return r >= a
+	case chunk.OpTForLoop:
+		return r >= a+2
+	}
+	return false
+}
+
+// producer returns the instruction, that set register r before pc, or -1 if it's not known
+func (vm *VM) producer(p *chunk.Function, pc, r int) int {
+	info := vm.protoInfo(p)
+	for k := pc - 1; k >= 0; k-- {
+		if info.targets[k+1] {
+			return -1
+		}
+		if !info.pseudo[k] && writes(p.Code[k], r) {
+			return k
+		}
+	}
+	return -1
+}
+
+func stringConstant(p *chunk.Function, rk int) (string, bool) {
+	if !chunk.IsConstant(rk) {
+		return "", false
+	}
+	k := p.Constants[chunk.ConstantIndex(rk)]
+	return k.Text, k.Type == chunk.TypeString
+}
+
+// symbol returns kind and name of the variable, that register r holds at pc, like getobjname does
+func (vm *VM) symbol(p *chunk.Function, pc, r int) (kind, name string) {
+	if name := p.LocalName(r, pc); name != "" {
+		return "local", name
+	}
+	k := vm.producer(p, pc, r)
+	if k < 0 {
+		return "", ""
+	}
+	i := p.Code[k]
+	switch i.OpCode() {
+	case chunk.OpGetGlobal:
+		if name, ok := stringConstant(p, i.Bx()+chunk.MaxStack); ok {
+			return "global", name
+		}
+	case chunk.OpMove:
+		if i.B() < i.A() {
+			return vm.symbol(p, k, i.B())
+		}
+	case chunk.OpGetTable:
+		if name, ok := stringConstant(p, i.C()); ok {
+			return "field", name
+		}
+	case chunk.OpSelf:
+		if name, ok := stringConstant(p, i.C()); ok && r == i.A() {
+			return "method", name
+		}
+	case chunk.OpGetUpval:
+		if i.B() < len(p.Upvalues) {
+			return "upvalue", p.Upvalues[i.B()]
+		}
+	}
+	return "", ""
+}
+
+// path returns dotted name of the global or field, that register r holds at pc, like "gw.video",
+// or an empty string if it's not known
+func (vm *VM) path(fr *frame, pc, r int) string {
+	p := fr.p
+	k := vm.producer(p, pc, r)
+	if k < 0 {
+		return ""
+	}
+	i := p.Code[k]
+	switch i.OpCode() {
+	case chunk.OpGetGlobal:
+		name, _ := stringConstant(p, i.Bx()+chunk.MaxStack)
+		return name
+	case chunk.OpMove:
+		return vm.path(fr, k, i.B())
+	case chunk.OpGetTable:
+		key, ok := stringConstant(p, i.C())
+		if parent := vm.path(fr, k, i.B()); ok && parent != "" {
+			return parent + "." + key
+		}
+	}
+	return ""
+}
+
+// describe returns description of value v held in register r for error messages,
+// like "global `foo' (a nil value)", r is -1 when v is not held in a register
+func (vm *VM) describe(fr *frame, r int, v Value) string {
+	if r >= 0 && !chunk.IsConstant(r) {
+		if kind, name := vm.symbol(fr.p, fr.pc, r); name != "" {
+			return fmt.Sprintf("%s `%s' (a %s value)", kind, name, TypeName(v))
+		}
+	}
+	return fmt.Sprintf("a %s value", TypeName(v))
+}
@@ -0,0 +1,277 @@
+package vm
+
+import (
+	"strings"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+)
+
+// maxTagLoop limits chains of __index and __newindex tables, like MAXTAGLOOP does
+const maxTagLoop = 100
+
+func metamethod(v Value, event string) Value {
+	if t, ok := v.(*Table); ok {
+		return t.metamethod(event)
+	}
+	return nil
+}
+
+func first(results []Value, err error) (Value, error) {
+	return arg(results, 0), err
+}
+
+// stubName returns name of a stub standing for obj, which is held in register reg,
+// or an empty string if obj is a real value
+func (vm *VM) stubName(fr *frame, obj Value, reg int) string {
+	switch obj := obj.(type) {
+	case *Stub:
+		return obj.Name
+	case nil:
+		if vm.cfg.Unknown != nil && reg >= 0 {
+			return vm.path(fr, fr.pc, reg)
+		}
+	}
+	return ""
+}
+
+// index returns obj[key], reg is the register holding obj, or -1
+func (vm *VM) index(fr *frame, obj, key Value, reg int) (Value, error) {
+	for range maxTagLoop {
+		t, ok := obj.(*Table)
+		if !ok {
+			if name := vm.stubName(fr, obj, reg); name != "" {
+				if key, ok := key.(string); ok {
+					return vm.stub(name + "." + key), nil
+				}
+			}
+			return nil, fr.errorf("attempt to index %s", vm.describe(fr, reg, obj))
+		}
+
+		if v := t.Get(key); v != nil {
+			return v, nil
+		}
+		h := t.metamethod("__index")
+		switch h.(type) {
+		case nil:
+			return nil, nil
+		case *Table:
+			obj, reg = h, -1
+		default:
+			return first(vm.call(h, []Value{t, key}, fr))
+		}
+	}
+	return nil, fr.errorf("loop in gettable")
+}
+
+// setIndex sets obj[key] to value, reg is the register holding obj, or -1
+func (vm *VM) setIndex(fr *frame, obj, key, value Value, reg int) error {
+	for range maxTagLoop {
+		t, ok := obj.(*Table)
+		if !ok {
+			return fr.errorf("attempt to index %s", vm.describe(fr, reg, obj))
+		}
+
+		h := t.metamethod("__newindex")
+		if h == nil || t.Get(key) != nil {
+			if err := t.Set(key, value); err != nil {
+				return fr.errorf("%s", err)
+			}
+			return nil
+		}
+		if _, ok := h.(*Table); !ok {
+			_, err := vm.call(h, []Value{t, key, value}, fr)
+			return err
+		}
+		obj, reg = h, -1
+	}
+	return fr.errorf("loop in settable")
+}
+
+var arithEvents = map[chunk.OpCode]string{
+	chunk.OpAdd: "__add",
+	chunk.OpSub: "__sub",
+	chunk.OpMul: "__mul",
+	chunk.OpDiv: "__div",
+	chunk.OpPow: "__pow",
+	chunk.OpUnm: "__unm",
+}
+
+// arith performs an arithmetic instruction on RK operands b and c, numeric strings are converted to numbers
+func (vm *VM) arith(fr *frame, op chunk.OpCode, b, c int) (Value, error) {
+	rb, rc := fr.rk(b), fr.rk(c)
+	x, okX := ToNumber(rb)
+	y, okY := ToNumber(rc)
+	if okX && okY {
+		switch op {
+		case chunk.OpAdd:
+			return x + y, nil
+		case chunk.OpSub:
+			return x - y, nil
+		case chunk.OpMul:
+			return x * y, nil
+		case chunk.OpDiv:
+			return x / y, nil
+		case chunk.OpUnm:
+			return -x, nil
+		case chunk.OpPow:
+			// Lua 5.0 has no built-in power, the math library defines it as global __pow
+			h := vm.globals.Get("__pow")
+			if h == nil {
+				return nil, fr.errorf("`__pow' (`^' operator) is not defined")
+			}
+			return first(vm.call(h, []Value{rb, rc}, fr))
+		}
+	}
+
+	event := arithEvents[op]
+	h := metamethod(rb, event)
+	if h == nil {
+		h = metamethod(rc, event)
+	}
+	if h != nil {
+		return first(vm.call(h, []Value{rb, rc}, fr))
+	}
+
+	bad, reg := rc, c
+	if !okX {
+		bad, reg = rb, b
+	}
+	return nil, fr.errorf("attempt to perform arithmetic on %s", vm.describe(fr, reg, bad))
+}
+
+func isStringLike(v Value) bool {
+	switch v.(type) {
+	case string, float64:
+		return true
+	}
+	return false
+}
+
+// concat joins registers from b to c, pairs that are not strings or numbers are joined by __concat
+func (vm *VM) concat(fr *frame, b, c int) (Value, error) {
+	values := append([]Value(nil), fr.regs[b:c+1]...)
+	merged := false
+	for len(values) > 1 {
+		n := len(values)
+		x, y := values[n-2], values[n-1]
+		if !isStringLike(x) || !isStringLike(y) {
+			h := metamethod(x, "__concat")
+			if h == nil {
+				h = metamethod(y, "__concat")
+			}
+			if h == nil {
+				bad, reg := x, b+n-2
+				if isStringLike(x) {
+					bad, reg = y, b+n-1
+					if merged {
+						reg = -1
+					}
+				}
+				return nil, fr.errorf("attempt to concatenate %s", vm.describe(fr, reg, bad))
+			}
+			v, err := first(vm.call(h, []Value{x, y}, fr))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values[:n-2], v)
+			merged = true
+			continue
+		}
+
+		k := n - 2
+		for k > 0 && isStringLike(values[k-1]) {
+			k--
+		}
+		sb := strings.Builder{}
+		for _, v := range values[k:] {
+			sb.WriteString(ToString(v))
+		}
+		values = append(values[:k], sb.String())
+		merged = true
+	}
+	return values[0], nil
+}
+
+// compare evaluates EQ, LT or LE on RK operands b and c
+func (vm *VM) compare(fr *frame, op chunk.OpCode, b, c int) (bool, error) {
+	x, y := fr.rk(b), fr.rk(c)
+	switch op {
+	case chunk.OpEq:
+		return vm.equal(fr, x, y)
+	case chunk.OpLt:
+		return vm.lessThan(fr, x, y)
+	}
+	return vm.lessEqual(fr, x, y)
+}
+
+func (vm *VM) equal(fr *frame, x, y Value) (bool, error) {
+	if RawEqual(x, y) {
+		return true, nil
+	}
+	tx, okX := x.(*Table)
+	ty, okY := y.(*Table)
+	if !okX || !okY {
+		return false, nil
+	}
+	h := tx.metamethod("__eq")
+	if h == nil || (tx.meta != ty.meta && !RawEqual(h, ty.metamethod("__eq"))) {
+		return false, nil
+	}
+	v, err := first(vm.call(h, []Value{x, y}, fr))
+	return Truthy(v), err
+}
+
+// order calls metamethod event shared by x and y, ok is false if they don't share it
+func (vm *VM) order(fr *frame, x, y Value, event string) (result, ok bool, err error) {
+	h := metamethod(x, event)
+	if h == nil || !RawEqual(h, metamethod(y, event)) {
+		return false, false, nil
+	}
+	v, err := first(vm.call(h, []Value{x, y}, fr))
+	return Truthy(v), true, err
+}
+
+func (vm *VM) lessThan(fr *frame, x, y Value) (bool, error) {
+	switch x := x.(type) {
+	case float64:
+		if y, ok := y.(float64); ok {
+			return x < y, nil
+		}
+	case string:
+		if y, ok := y.(string); ok {
+			return x < y, nil
+		}
+	}
+	if result, ok, err := vm.order(fr, x, y, "__lt"); ok {
+		return result, err
+	}
+	return false, orderError(fr, x, y)
+}
+
+func (vm *VM) lessEqual(fr *frame, x, y Value) (bool, error) {
+	switch x := x.(type) {
+	case float64:
+		if y, ok := y.(float64); ok {
+			return x <= y, nil
+		}
+	case string:
+		if y, ok := y.(string); ok {
+			return x <= y, nil
+		}
+	}
+	if result, ok, err := vm.order(fr, x, y, "__le"); ok {
+		return result, err
+	}
+	if result, ok, err := vm.order(fr, y, x, "__lt"); ok {
+		return !result, err
+	}
+	return false, orderError(fr, x, y)
+}
+
+func orderError(fr *frame, x, y Value) error {
+	t1, t2 := TypeName(x), TypeName(y)
+	if t1 == t2 {
+		return fr.errorf("attempt to compare two %s values", t1)
+	}
+	return fr.errorf("attempt to compare %s with %s", t1, t2)
+}
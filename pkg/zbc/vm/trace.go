@@ -0,0 +1,29 @@
+package vm
+
+import (
+	"fmt"
+)
+
+// Recorder returns a Trace function, that appends every host call to records
+func Recorder(records *[]Record) func(r Record) {
+	return func(r Record) {
+		*records = append(*records, r)
+	}
+}
+
+// Replay returns an Unknown handler, that answers calls with results from a recorded trace.
+// Calls have to come in the recorded order, arguments are not compared
+func Replay(records []Record) UnknownFunction {
+	next := 0
+	return func(_ *VM, name string, _ []Value) ([]Value, error) {
+		if next >= len(records) {
+			return nil, fmt.Errorf("unexpected call of %s after the end of the trace", name)
+		}
+		r := records[next]
+		if r.Name != name {
+			return nil, fmt.Errorf("expected call of %s at position %d of the trace, got %s", r.Name, next, name)
+		}
+		next++
+		return r.Results, nil
+	}
+}
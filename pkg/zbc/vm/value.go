@@ -0,0 +1,278 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+)
+
+// Value is a Lua value. It's one of nil, bool, float64, string, *Table, *Closure,
+// *HostFunction or *Stub
+type Value any
+
+// GoFunction implements a host function, it gets arguments of the call and returns its results
+type GoFunction func(vm *VM, args []Value) ([]Value, error)
+
+// HostFunction is a function implemented in Go, that can be called by scripts
+type HostFunction struct {
+	// Name is used in traces and error messages
+	Name string
+	Fn   GoFunction
+}
+
+// Closure is a Lua function with its upvalues
+type Closure struct {
+	Proto    *chunk.Function
+	upvalues []*upvalue
+}
+
+// Stub stands for a native function or namespace, that scripts use, but the host doesn't define.
+// Calls of stubs are passed to Config.Unknown
+type Stub struct {
+	// Name is the dotted name used by the script, like "gw.video.play"
+	Name string
+}
+
+// upvalue is a variable captured by a closure. While the function declaring the variable runs,
+// upvalue points at its register, later it holds the value itself
+type upvalue struct {
+	frame *frame
+	index int
+	value Value
+}
+
+func (u *upvalue) get() Value {
+	if u.frame != nil {
+		return u.frame.regs[u.index]
+	}
+	return u.value
+}
+
+func (u *upvalue) set(v Value) {
+	if u.frame != nil {
+		u.frame.regs[u.index] = v
+		return
+	}
+	u.value = v
+}
+
+// Table is a Lua table. Keys are kept in order of insertion, so iteration is deterministic
+type Table struct {
+	entries []entry
+	index   map[Value]int
+	// dead counts entries set to nil, they are kept so next works while fields are cleared
+	dead int
+	meta *Table
+}
+
+type entry struct {
+	key, value Value
+}
+
+// NewTable returns an empty table
+func NewTable() *Table {
+	return &Table{index: map[Value]int{}}
+}
+
+// Get returns value stored at key without calling metamethods, nil if there's none
+func (t *Table) Get(key Value) Value {
+	if key, ok := key.(float64); ok && math.IsNaN(key) {
+		return nil
+	}
+	if i, ok := t.index[key]; ok {
+		return t.entries[i].value
+	}
+	return nil
+}
+
+// Set stores value at key without calling metamethods, nil value removes the key
+func (t *Table) Set(key, value Value) error {
+	switch k := key.(type) {
+	case nil:
+		return errors.New("table index is nil")
+	case float64:
+		if math.IsNaN(k) {
+			return errors.New("table index is NaN")
+		}
+	}
+
+	if i, ok := t.index[key]; ok {
+		old := t.entries[i].value
+		t.entries[i].value = value
+		switch {
+		case old != nil && value == nil:
+			t.dead++
+		case old == nil && value != nil:
+			t.dead--
+		}
+		return nil
+	}
+	if value == nil {
+		return nil
+	}
+	// like in Lua, adding keys during traversal is not allowed, so removed entries can be dropped here
+	if t.dead > 16 && t.dead*2 > len(t.entries) {
+		t.compact()
+	}
+	t.index[key] = len(t.entries)
+	t.entries = append(t.entries, entry{key, value})
+	return nil
+}
+
+func (t *Table) compact() {
+	live := t.entries[:0]
+	for _, e := range t.entries {
+		if e.value == nil {
+			delete(t.index, e.key)
+			continue
+		}
+		t.index[e.key] = len(live)
+		live = append(live, e)
+	}
+	clear(t.entries[len(live):])
+	t.entries = live
+	t.dead = 0
+}
+
+// Next returns the key and value following key, or the first one for nil key.
+// ok is false at the end of the table
+func (t *Table) Next(key Value) (k, v Value, ok bool, err error) {
+	i := 0
+	if key != nil {
+		pos, found := t.index[key]
+		if !found {
+			return nil, nil, false, errors.New("invalid key to next")
+		}
+		i = pos + 1
+	}
+	for ; i < len(t.entries); i++ {
+		if e := t.entries[i]; e.value != nil {
+			return e.key, e.value, true, nil
+		}
+	}
+	return nil, nil, false, nil
+}
+
+// Len returns n, such that t[n] is not nil and t[n+1] is nil, like table.getn
+func (t *Table) Len() int {
+	n := 0
+	for t.Get(float64(n+1)) != nil {
+		n++
+	}
+	return n
+}
+
+// Metatable returns metatable of t, or nil
+func (t *Table) Metatable() *Table {
+	return t.meta
+}
+
+// SetMetatable sets metatable of t, nil removes it
+func (t *Table) SetMetatable(meta *Table) {
+	t.meta = meta
+}
+
+func (t *Table) metamethod(event string) Value {
+	if t.meta == nil {
+		return nil
+	}
+	return t.meta.Get(event)
+}
+
+// TypeName returns Lua type of v, stubs are reported as functions
+func TypeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *Table:
+		return "table"
+	case *Closure, *HostFunction, *Stub:
+		return "function"
+	}
+	return "userdata"
+}
+
+// Truthy reports whether v counts as true in conditions, only nil and false don't
+func Truthy(v Value) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+// ToString converts v like tostring does, numbers are formatted like in Lua 5.0
+func ToString(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return FormatNumber(v)
+	case string:
+		return v
+	case *Stub:
+		return "stub: " + v.Name
+	case *HostFunction:
+		return "function: builtin " + v.Name
+	}
+	return fmt.Sprintf("%s: %p", TypeName(v), v)
+}
+
+// FormatNumber formats n with %.14g, which Lua 5.0 uses for converting numbers to strings
+func FormatNumber(n float64) string {
+	switch {
+	case math.IsInf(n, 1):
+		return "inf"
+	case math.IsInf(n, -1):
+		return "-inf"
+	case math.IsNaN(n):
+		return "nan"
+	}
+	return fmt.Sprintf("%.14g", n)
+}
+
+// ToNumber converts numbers and numeric strings to a number, like tonumber does
+func ToNumber(v Value) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		return parseNumber(v)
+	}
+	return 0, false
+}
+
+func parseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.Contains(s, "_") {
+		return 0, false
+	}
+	if hex, ok := strings.CutPrefix(strings.ToLower(s), "0x"); ok {
+		n, err := strconv.ParseUint(hex, 16, 32)
+		return float64(n), err == nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return 0, false
+	}
+	return n, true
+}
+
+// RawEqual compares values without calling metamethods
+func RawEqual(a, b Value) bool {
+	return a == b
+}
@@ -0,0 +1,220 @@
+// Package vm executes Lua 5.0 chunks used by Gamewave games, without the console.
+//
+// The VM provides no standard library. Everything a script can call besides its own
+// functions comes from the host, through Config.Functions. Natives the host doesn't
+// know about can be stubbed with Config.Unknown, which gets calls like gw.video.play(...)
+// by their dotted name, and all host calls can be logged with Config.Trace and replayed
+// from a recorded trace with Replay.
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+)
+
+// maxDepth limits nested calls, like LUA_MAXCCALLS does
+const maxDepth = 200
+
+// ErrStepLimit is returned when a script runs longer than Config.MaxSteps
+var ErrStepLimit = errors.New("step limit exceeded")
+
+// UnknownFunction handles calls of natives, that are not defined by the host. name is the
+// dotted name used by the script, like "print" or "gw.video.play"
+type UnknownFunction func(vm *VM, name string, args []Value) ([]Value, error)
+
+// Record is a call of a host function
+type Record struct {
+	Name    string
+	Args    []Value
+	Results []Value
+}
+
+// Config configures the host environment of a VM
+type Config struct {
+	// Functions are host functions by their dotted name, like "print" or "gw.video.play".
+	// Tables are created in globals for namespaces
+	Functions map[string]GoFunction
+	// Unknown is called instead of failing, when a script calls an undefined global function,
+	// or a function from an undefined namespace. Undefined globals still read as nil
+	Unknown UnknownFunction
+	// Trace is called after every call of a host function or Unknown
+	Trace func(r Record)
+	// Hook is called before every instruction, with 1-based pc like in zbc_disasm listings.
+	// Returning an error stops the script
+	Hook func(vm *VM, function string, pc int) error
+	// MaxSteps stops scripts after executing this many instructions, 0 means no limit
+	MaxSteps int
+}
+
+// A RuntimeError reports an error raised while running a script
+type RuntimeError struct {
+	// Function names the function like chunk.Walk does, e.g. "main/0"
+	Function string
+	// PC is the 1-based instruction number
+	PC int
+	// Line is the source line, or 0 in stripped chunks
+	Line int
+	Msg  string
+	// Err is the error returned by a host function, if it caused this error
+	Err error
+}
+
+func (e *RuntimeError) Error() string {
+	where := fmt.Sprintf("%s:%d", e.Function, e.PC)
+	if e.Line > 0 {
+		where += fmt.Sprintf(" (line %d)", e.Line)
+	}
+	return "lua runtime error: " + where + ": " + e.Msg
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// VM runs a single chunk, its globals are kept between calls
+type VM struct {
+	cfg     Config
+	main    *Closure
+	globals *Table
+	ids     map[*chunk.Function]string
+	infos   map[*chunk.Function]*protoInfo
+	stubs   map[string]*Stub
+	// next is used by generic for over tables, when the host doesn't define next
+	next  *HostFunction
+	steps int
+	depth int
+}
+
+// New prepares c to be run with host functions from cfg
+func New(c *chunk.Chunk, cfg Config) (*VM, error) {
+	vm := &VM{
+		cfg:     cfg,
+		main:    &Closure{Proto: c.Main},
+		globals: NewTable(),
+		ids:     map[*chunk.Function]string{},
+		infos:   map[*chunk.Function]*protoInfo{},
+		stubs:   map[string]*Stub{},
+	}
+	vm.next = &HostFunction{Name: "next", Fn: builtinNext}
+	_ = c.Walk(func(id string, f *chunk.Function) error {
+		vm.ids[f] = id
+		return nil
+	})
+	if c.Main.NumUpvalues != 0 {
+		return nil, fmt.Errorf("main function can't have upvalues, it has %d", c.Main.NumUpvalues)
+	}
+
+	for name, fn := range cfg.Functions {
+		if err := vm.Register(name, fn); err != nil {
+			return nil, err
+		}
+	}
+	return vm, nil
+}
+
+// Register defines host function under a dotted name, creating tables for its namespace
+func (vm *VM) Register(name string, fn GoFunction) error {
+	parts := strings.Split(name, ".")
+	t := vm.globals
+	for k, part := range parts[:len(parts)-1] {
+		switch v := t.Get(part).(type) {
+		case *Table:
+			t = v
+		case nil:
+			namespace := NewTable()
+			_ = t.Set(part, namespace)
+			t = namespace
+		default:
+			return fmt.Errorf("can't register %s, %s is not a table", name, strings.Join(parts[:k+1], "."))
+		}
+	}
+	return t.Set(parts[len(parts)-1], &HostFunction{Name: name, Fn: fn})
+}
+
+// Globals returns the table of global variables
+func (vm *VM) Globals() *Table {
+	return vm.globals
+}
+
+// Steps returns the number of instructions executed so far
+func (vm *VM) Steps() int {
+	return vm.steps
+}
+
+// Run runs the main function of the chunk, it returns values returned by the chunk
+func (vm *VM) Run(args ...Value) ([]Value, error) {
+	return vm.Call(vm.main, args...)
+}
+
+// Call calls a function value with args, like a script would. It can be used by host
+// functions, e.g. to call callbacks given by the script
+func (vm *VM) Call(fn Value, args ...Value) (results []Value, err error) {
+	if vm.depth == 0 {
+		// the VM trusts code, that passed zbc.Verify, corrupt code makes it panic
+		defer func() {
+			if r := recover(); r != nil {
+				results, err = nil, fmt.Errorf("invalid bytecode: %v", r)
+			}
+		}()
+	}
+	return vm.call(fn, args, nil)
+}
+
+// stub returns a stub with name, the same one is returned for the same name,
+// so scripts can compare them
+func (vm *VM) stub(name string) *Stub {
+	s, ok := vm.stubs[name]
+	if !ok {
+		s = &Stub{Name: name}
+		vm.stubs[name] = s
+	}
+	return s
+}
+
+// call calls fn, site is the frame calling it, or nil for calls from the host
+func (vm *VM) call(fn Value, args []Value, site *frame) ([]Value, error) {
+	if vm.depth >= maxDepth {
+		return nil, site.errorf("stack overflow")
+	}
+	vm.depth++
+	defer func() { vm.depth-- }()
+
+	switch f := fn.(type) {
+	case *Closure:
+		return vm.execute(f, args)
+	case *HostFunction:
+		results, err := f.Fn(vm, args)
+		// the built-in next is a part of the VM, it's not traced
+		return vm.hostResults(f.Name, args, results, err, site, f != vm.next)
+	case *Stub:
+		if vm.cfg.Unknown == nil {
+			return nil, site.errorf("attempt to call undefined native %s", f.Name)
+		}
+		results, err := vm.cfg.Unknown(vm, f.Name, args)
+		return vm.hostResults(f.Name, args, results, err, site, true)
+	case *Table:
+		if h := f.metamethod("__call"); h != nil {
+			return vm.call(h, append([]Value{f}, args...), site)
+		}
+	}
+	return nil, site.errorf("attempt to call a %s value", TypeName(fn))
+}
+
+func (vm *VM) hostResults(name string, args, results []Value, err error, site *frame, trace bool) ([]Value, error) {
+	if trace && vm.cfg.Trace != nil {
+		vm.cfg.Trace(Record{Name: name, Args: args, Results: results})
+	}
+	if err == nil {
+		return results, nil
+	}
+	var runtimeErr *RuntimeError
+	if errors.As(err, &runtimeErr) || site == nil {
+		return nil, err
+	}
+	e := site.errorf("%s: %s", name, err)
+	e.(*RuntimeError).Err = err
+	return nil, e
+}
@@ -0,0 +1,454 @@
+package vm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/asm"
+	"github.com/stretchr/testify/require"
+)
+
+const header = ".chunk 5.0 little int=4 size_t=4 instruction=4 number=8 float\n"
+
+func newVM(t *testing.T, listing string, cfg Config) *VM {
+	t.Helper()
+	c, err := asm.Assemble(strings.NewReader(header + listing))
+	require.NoError(t, err)
+	vm, err := New(c, cfg)
+	require.NoError(t, err)
+	return vm
+}
+
+// local t = {x = "y", 1, 2}
+// local function add(a, b) return a + b + t[1] end
+// for i = 1, 3 do print(add(i, 2)) end
+const tableAndLoop = `
+.function main
+.maxstack 9
+.const K0 1
+.const K1 2
+.const K2 "x"
+.const K3 "y"
+.const K4 3
+.const K5 "print"
+.code
+     1 NEWTABLE   R0 2 1
+     2 LOADK      R1 K0
+     3 LOADK      R2 K1
+     4 SETTABLE   R0 K2 K3
+     5 SETLIST    R0 1
+     6 CLOSURE    R1 F0
+     7 MOVE       0 R0 0
+     8 LOADK      R2 K0
+     9 LOADK      R3 K4
+    10 LOADK      R4 K0
+    11 SUB        R2 R2 R4
+    12 JMP        0 @19
+    13 GETGLOBAL  R5 K5
+    14 MOVE       R6 R1 0
+    15 MOVE       R7 R2 0
+    16 LOADK      R8 K1
+    17 CALL       R6 3 0
+    18 CALL       R5 0 1
+    19 FORLOOP    R2 @13
+    20 RETURN     R0 2 0
+    21 RETURN     R0 1 0
+.end
+
+.function main/0
+.upvalues 1
+.params 2
+.maxstack 4
+.const K0 1
+.code
+     1 ADD        R2 R0 R1
+     2 GETUPVAL   R3 U0 0
+     3 GETTABLE   R3 R3 K0
+     4 ADD        R2 R2 R3
+     5 RETURN     R2 2 0
+     6 RETURN     R0 1 0
+.end
+`
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	var printed []Value
+	vm := newVM(t, tableAndLoop, Config{Functions: map[string]GoFunction{
+		"print": func(_ *VM, args []Value) ([]Value, error) {
+			printed = append(printed, args...)
+			return nil, nil
+		},
+	}})
+
+	results, err := vm.Run()
+	require.NoError(t, err)
+	require.Equal(t, []Value{4.0, 5.0, 6.0}, printed)
+
+	require.Len(t, results, 1)
+	table := results[0].(*Table)
+	require.Equal(t, "y", table.Get("x"))
+	require.Equal(t, 2, table.Len())
+	require.Equal(t, 2.0, table.Get(2.0))
+}
+
+const controlFlow = `; function check(n, list)
+;   local total = 0
+;   for k, v in pairs(list) do
+;     if v > n and k ~= "skip" then total = total + v
+;     elseif v == 0 then break
+;     else total = total - 1 end
+;   end
+;   while total > 10 do total = total / 2 end
+;   repeat total = total + 1 until total >= n or done
+;   return total < n, list.name or "none", total
+; end
+.function main
+.maxstack 2
+.const K0 "check"
+.code
+     1 CLOSURE    R0 F0
+     2 SETGLOBAL  R0 K0
+     3 RETURN     R0 1 0
+.end
+
+.function main/0
+.params 2
+.maxstack 7
+.const K0 0
+.const K1 "pairs"
+.const K2 "skip"
+.const K3 1
+.const K4 2
+.const K5 10
+.const K6 "done"
+.const K7 "name"
+.const K8 "none"
+.code
+     1 LOADK      R2 K0
+     2 GETGLOBAL  R3 K1
+     3 MOVE       R4 R1 0
+     4 CALL       R3 2 5
+     5 TFORPREP   R3 @17
+     6 LT         0 R0 R6
+     7 JMP        0 @12
+     8 EQ         1 R5 K2
+     9 JMP        0 @12
+    10 ADD        R2 R2 R6
+    11 JMP        0 @17
+    12 EQ         0 R6 K0
+    13 JMP        0 @16
+    14 JMP        0 @19
+    15 JMP        0 @17
+    16 SUB        R2 R2 K3
+    17 TFORLOOP   R3 0 1
+    18 JMP        0 @6
+    19 JMP        0 @21
+    20 DIV        R2 R2 K4
+    21 LT         1 K5 R2
+    22 JMP        0 @20
+    23 ADD        R2 R2 K3
+    24 LE         1 R0 R2
+    25 JMP        0 @29
+    26 GETGLOBAL  R3 K6
+    27 TEST       R3 R3 0
+    28 JMP        0 @23
+    29 LT         1 R2 R0
+    30 JMP        0 @32
+    31 LOADBOOL   R3 0 1
+    32 LOADBOOL   R3 1 0
+    33 GETTABLE   R4 R1 K7
+    34 TEST       R4 R4 1
+    35 JMP        0 @37
+    36 LOADK      R4 K8
+    37 MOVE       R5 R2 0
+    38 RETURN     R3 4 0
+    39 RETURN     R0 1 0
+.end
+`
+
+func TestControlFlow(t *testing.T) {
+	t.Parallel()
+	vm := newVM(t, controlFlow, Config{Functions: map[string]GoFunction{
+		"pairs": func(vm *VM, args []Value) ([]Value, error) {
+			// generic for calls the built-in next for tables
+			return []Value{args[0], nil, nil}, nil
+		},
+	}})
+	_, err := vm.Run()
+	require.NoError(t, err)
+	check := vm.Globals().Get("check")
+
+	list := func(name Value, values ...float64) *Table {
+		t := NewTable()
+		for k, v := range values {
+			_ = t.Set(float64(k+1), v)
+		}
+		// the name is inherited, so pairs doesn't see it
+		meta := NewTable()
+		fields := NewTable()
+		_ = fields.Set("name", name)
+		_ = meta.Set("__index", fields)
+		t.SetMetatable(meta)
+		return t
+	}
+
+	cases := []struct {
+		name     string
+		n        float64
+		list     *Table
+		expected []Value
+	}{
+		{"break", 5, list(nil, 3, 7, 0, 9), []Value{false, "none", 7.0}},
+		{"while", 1, list("list", 30), []Value{false, "list", 8.5}},
+		{"repeat", 4, list(nil), []Value{false, "none", 4.0}},
+	}
+	for _, tt := range cases {
+		results, err := vm.Call(check, tt.n, tt.list)
+		require.NoError(t, err, tt.name)
+		require.Equal(t, tt.expected, results, tt.name)
+	}
+
+	_ = vm.Globals().Set("done", true)
+	results, err := vm.Call(check, 4.0, list(nil))
+	require.NoError(t, err)
+	require.Equal(t, []Value{true, "none", 1.0}, results)
+}
+
+// gw.video.play("intro", 1); menu:show(); return lookup(2)
+const natives = `
+.function main
+.maxstack 4
+.const K0 "gw"
+.const K1 "video"
+.const K2 "play"
+.const K3 "intro"
+.const K4 1
+.const K5 "menu"
+.const K6 "show"
+.const K7 "lookup"
+.const K8 2
+.code
+     1 GETGLOBAL  R0 K0
+     2 GETTABLE   R0 R0 K1
+     3 GETTABLE   R0 R0 K2
+     4 LOADK      R1 K3
+     5 LOADK      R2 K4
+     6 CALL       R0 3 1
+     7 GETGLOBAL  R0 K5
+     8 SELF       R0 R0 K6
+     9 CALL       R0 2 1
+    10 GETGLOBAL  R0 K7
+    11 LOADK      R1 K8
+    12 TAILCALL   R0 2 0
+    13 RETURN     R0 0 0
+    14 RETURN     R0 1 0
+.end
+`
+
+func TestUnknownAndReplay(t *testing.T) {
+	t.Parallel()
+	var records []Record
+	vm := newVM(t, natives, Config{
+		Unknown: func(_ *VM, name string, args []Value) ([]Value, error) {
+			if name == "lookup" {
+				return []Value{args[0].(float64) * 21}, nil
+			}
+			return nil, nil
+		},
+		Trace: Recorder(&records),
+	})
+	results, err := vm.Run()
+	require.NoError(t, err)
+	require.Equal(t, []Value{42.0}, results)
+	require.Equal(t, []Record{
+		{Name: "gw.video.play", Args: []Value{"intro", 1.0}},
+		{Name: "menu:show", Args: []Value{nil}},
+		{Name: "lookup", Args: []Value{2.0}, Results: []Value{42.0}},
+	}, records)
+
+	replayed := newVM(t, natives, Config{Unknown: Replay(records)})
+	results, err = replayed.Run()
+	require.NoError(t, err)
+	require.Equal(t, []Value{42.0}, results)
+
+	diverged := newVM(t, natives, Config{Unknown: Replay(records[1:])})
+	_, err = diverged.Run()
+	require.EqualError(t, err, "lua runtime error: main:6: gw.video.play: "+
+		"expected call of menu:show at position 0 of the trace, got gw.video.play")
+}
+
+// return obj.missing, obj + 1, "n=" .. 1.5
+const metatables = `
+.function main
+.maxstack 4
+.const K0 "obj"
+.const K1 "missing"
+.const K2 1
+.const K3 "n="
+.const K4 1.5
+.code
+     1 GETGLOBAL  R0 K0
+     2 GETTABLE   R0 R0 K1
+     3 GETGLOBAL  R1 K0
+     4 ADD        R1 R1 K2
+     5 LOADK      R2 K3
+     6 LOADK      R3 K4
+     7 CONCAT     R2 R2 R3
+     8 RETURN     R0 4 0
+     9 RETURN     R0 1 0
+.end
+`
+
+func TestMetatables(t *testing.T) {
+	t.Parallel()
+	vm := newVM(t, metatables, Config{})
+
+	fallback := NewTable()
+	_ = fallback.Set("missing", "found")
+	meta := NewTable()
+	_ = meta.Set("__index", fallback)
+	_ = meta.Set("__add", &HostFunction{Name: "add", Fn: func(_ *VM, args []Value) ([]Value, error) {
+		return []Value{"added " + ToString(args[1])}, nil
+	}})
+	obj := NewTable()
+	obj.SetMetatable(meta)
+	_ = vm.Globals().Set("obj", obj)
+
+	results, err := vm.Run()
+	require.NoError(t, err)
+	require.Equal(t, []Value{"found", "added 1", "n=1.5"}, results)
+}
+
+func TestErrors(t *testing.T) {
+	t.Parallel()
+	boom := errors.New("boom")
+	cases := []struct {
+		name     string
+		listing  string
+		cfg      Config
+		expected string
+		is       error
+	}{
+		{
+			name: "call nil global",
+			listing: `
+.function main
+.maxstack 2
+.const K0 "foo"
+.code
+     1 [   7] GETGLOBAL  R0 K0
+     2 [   7] CALL       R0 1 1
+     3 [   8] RETURN     R0 1 0
+.end
+`,
+			expected: "lua runtime error: main:2 (line 7): attempt to call global `foo' (a nil value)",
+		},
+		{
+			name: "arithmetic on field",
+			listing: `
+.function main
+.maxstack 2
+.const K0 "x"
+.const K1 1
+.code
+     1 NEWTABLE   R0 0 0
+     2 GETTABLE   R1 R0 K0
+     3 ADD        R1 R1 K1
+     4 RETURN     R0 1 0
+.end
+`,
+			expected: "lua runtime error: main:3: attempt to perform arithmetic on field `x' (a nil value)",
+		},
+		{
+			name: "host error",
+			listing: `
+.function main
+.maxstack 2
+.const K0 "fail"
+.code
+     1 GETGLOBAL  R0 K0
+     2 CALL       R0 1 1
+     3 RETURN     R0 1 0
+.end
+`,
+			cfg: Config{Functions: map[string]GoFunction{"fail": func(*VM, []Value) ([]Value, error) {
+				return nil, boom
+			}}},
+			expected: "lua runtime error: main:2: fail: boom",
+			is:       boom,
+		},
+		{
+			name: "step limit",
+			listing: `
+.function main
+.maxstack 2
+.code
+     1 JMP        0 @1
+     2 RETURN     R0 1 0
+.end
+`,
+			cfg:      Config{MaxSteps: 100},
+			expected: "lua runtime error: main:1: step limit exceeded",
+			is:       ErrStepLimit,
+		},
+		{
+			name: "compare",
+			listing: `
+.function main
+.maxstack 2
+.const K0 1
+.const K1 "1"
+.code
+     1 LT         1 K0 K1
+     2 JMP        0 @3
+     3 RETURN     R0 1 0
+.end
+`,
+			expected: "lua runtime error: main:1: attempt to compare number with string",
+		},
+	}
+
+	for _, tt := range cases {
+		listing, cfg, expected, is := tt.listing, tt.cfg, tt.expected, tt.is
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := newVM(t, listing, cfg).Run()
+			require.EqualError(t, err, expected)
+			var runtimeErr *RuntimeError
+			require.ErrorAs(t, err, &runtimeErr)
+			if is != nil {
+				require.ErrorIs(t, err, is)
+			}
+		})
+	}
+}
+
+func TestTable(t *testing.T) {
+	t.Parallel()
+	table := NewTable()
+	for k := range 40 {
+		require.NoError(t, table.Set(float64(k), k))
+	}
+	// clearing fields during traversal is allowed
+	key, _, ok, err := table.Next(nil)
+	for ; ok && err == nil; key, _, ok, err = table.Next(key) {
+		if key.(float64) >= 2 {
+			require.NoError(t, table.Set(key, nil))
+		}
+	}
+	require.NoError(t, err)
+	require.NoError(t, table.Set("new", true))
+
+	var keys []Value
+	key, _, ok, _ = table.Next(nil)
+	for ; ok; key, _, ok, _ = table.Next(key) {
+		keys = append(keys, key)
+	}
+	require.Equal(t, []Value{0.0, 1.0, "new"}, keys)
+	require.Equal(t, 1, table.Len())
+
+	require.EqualError(t, table.Set(nil, 1), "table index is nil")
+	_, _, _, err = table.Next("missing")
+	require.EqualError(t, err, "invalid key to next")
+}
@@ -0,0 +1,43 @@
+package zbc
+
+import (
+	"io"
+
+	"github.com/namgo/GameWaveFans/pkg/common"
+)
+
+// write header of zbc file, before packed data is written
+func writeHeader(w io.Writer, h Header) error {
+	if _, err := w.Write(h.Magic[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(h.Unknown[:]); err != nil {
+		return err
+	}
+	if _, err := common.WriteUint32(w, h.UnpackedSize); err != nil {
+		return err
+	}
+	_, err := common.WriteUint32(w, h.PackedSize)
+	return err
+}
+
+// Pack writes ZBC bytecode zlib-packed, with a header expected by the console
+func Pack(w io.Writer, bytecode []byte) error {
+	packedData, err := common.WriteZlibToBuffer(bytecode)
+	if err != nil {
+		return err
+	}
+
+	// meaning of the two bytes at 0x6 is unknown, they're written as zeroes
+	h := Header{
+		UnpackedSize: uint32(len(bytecode)),
+		PackedSize:   uint32(len(packedData)),
+	}
+	copy(h.Magic[:], packedHeader)
+
+	if err = writeHeader(w, h); err != nil {
+		return err
+	}
+	_, err = w.Write(packedData)
+	return err
+}
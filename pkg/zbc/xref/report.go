@@ -0,0 +1,74 @@
+package xref
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// Report aggregates indexes of many files, e.g. of a whole disc
+type Report struct {
+	Files   []*Index `json:"files"`
+	Globals []Usage  `json:"globals"`
+}
+
+// NewReport creates a report of indexes, with their summary
+func NewReport(indexes []*Index) *Report {
+	if indexes == nil {
+		indexes = []*Index{}
+	}
+	return &Report{Files: indexes, Globals: Summarize(indexes)}
+}
+
+// WriteJSON writes r to w as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// csvHeader lists columns written by WriteCSV. Kind is one of string, read, write, call, tailcall;
+// name holds the value of strings, and the callee of calls
+var csvHeader = []string{"file", "function", "kind", "pc", "line", "index", "name", "args"}
+
+// WriteCSV writes every record of r as a row, summary is left out, as it can be computed from the rows
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	number := func(n int) string {
+		if n == 0 {
+			return ""
+		}
+		return strconv.Itoa(n)
+	}
+	for _, idx := range r.Files {
+		for _, s := range idx.Strings {
+			if err := cw.Write([]string{idx.File, s.Function, "string", "", "", strconv.Itoa(s.Index), s.Value, ""}); err != nil {
+				return err
+			}
+		}
+		for _, g := range idx.Globals {
+			kind := "read"
+			if g.Write {
+				kind = "write"
+			}
+			if err := cw.Write([]string{idx.File, g.Function, kind, strconv.Itoa(g.PC), number(g.Line), "", g.Name, ""}); err != nil {
+				return err
+			}
+		}
+		for _, c := range idx.Calls {
+			kind := "call"
+			if c.Tail {
+				kind = "tailcall"
+			}
+			if err := cw.Write([]string{idx.File, c.Function, kind, strconv.Itoa(c.PC), number(c.Line), "", c.Callee, strconv.Itoa(c.Args)}); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,262 @@
+// Package xref builds cross-reference indexes of Lua 5.0 chunks used by Gamewave games.
+//
+// An index lists string constants, global variables read and written, and call sites
+// of every function in a chunk. Callees are named by following the registers they
+// were loaded to, so calls like gw.video.play(x) or obj:show() are reported with their
+// names, which helps mapping the native API of the console.
+package xref
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+)
+
+// Unknown is the callee name used when the called value can't be named
+const Unknown = "?"
+
+// VarArgs is the argument count of calls passing a variable number of arguments, like f(g())
+const VarArgs = -1
+
+// Location points at an instruction in a chunk
+type Location struct {
+	// Function names the function like chunk.Walk does, e.g. "main/0"
+	Function string `json:"function"`
+	// PC is the 1-based instruction number, as printed by zbc_disasm
+	PC int `json:"pc"`
+	// Line is the source line, or 0 in stripped chunks
+	Line int `json:"line,omitempty"`
+}
+
+// String is a string constant of a function
+type String struct {
+	Function string `json:"function"`
+	// Index is the position in the constant table, K in listings
+	Index int    `json:"index"`
+	Value string `json:"value"`
+}
+
+// Global is a read or a write of a global variable
+type Global struct {
+	Location
+	Name  string `json:"name"`
+	Write bool   `json:"write,omitempty"`
+}
+
+// Call is a call site
+type Call struct {
+	Location
+	// Callee is the dotted name of the called value, like "gw.video.play" or "obj:show",
+	// or Unknown
+	Callee string `json:"callee"`
+	// Args is the number of arguments, not counting self of method calls, or VarArgs
+	Args int `json:"args"`
+	// Method is set for calls using : syntax
+	Method bool `json:"method,omitempty"`
+	// Tail is set for return f(...)
+	Tail bool `json:"tail,omitempty"`
+}
+
+// Index is the cross-reference of a single chunk
+type Index struct {
+	File    string   `json:"file"`
+	Strings []String `json:"strings"`
+	Globals []Global `json:"globals"`
+	Calls   []Call   `json:"calls"`
+}
+
+// Build indexes all functions of c, file is stored in the index as given
+func Build(c *chunk.Chunk, file string) *Index {
+	idx := &Index{File: file, Strings: []String{}, Globals: []Global{}, Calls: []Call{}}
+	_ = c.Walk(func(id string, f *chunk.Function) error {
+		idx.function(id, f)
+		return nil
+	})
+	return idx
+}
+
+func (idx *Index) function(id string, f *chunk.Function) {
+	for k, c := range f.Constants {
+		if c.Type == chunk.TypeString {
+			idx.Strings = append(idx.Strings, String{Function: id, Index: k, Value: c.Text})
+		}
+	}
+
+	// names of values held in registers, they are forgotten at jump targets,
+	// where registers could have been set by other paths
+	targets := jumpTargets(f)
+	names := map[int]string{}
+	constName := func(rk int) string {
+		if !chunk.IsConstant(rk) {
+			return ""
+		}
+		k := chunk.ConstantIndex(rk)
+		if k >= len(f.Constants) || f.Constants[k].Type != chunk.TypeString {
+			return ""
+		}
+		return f.Constants[k].Text
+	}
+	clearFrom := func(r int) {
+		for reg := range names {
+			if reg >= r {
+				delete(names, reg)
+			}
+		}
+	}
+
+	for pc := 0; pc < len(f.Code); pc++ {
+		if targets[pc] {
+			clear(names)
+		}
+		i := f.Code[pc]
+		loc := Location{Function: id, PC: pc + 1, Line: f.Line(pc)}
+		switch op := i.OpCode(); op {
+		case chunk.OpGetGlobal, chunk.OpSetGlobal:
+			name := constName(i.Bx() + chunk.MaxStack)
+			if name == "" {
+				delete(names, i.A())
+				continue
+			}
+			idx.Globals = append(idx.Globals, Global{Location: loc, Name: name, Write: op == chunk.OpSetGlobal})
+			if op == chunk.OpGetGlobal {
+				names[i.A()] = name
+			}
+		case chunk.OpGetTable:
+			key := constName(i.C())
+			if parent, ok := names[i.B()]; ok && key != "" {
+				names[i.A()] = parent + "." + key
+			} else {
+				delete(names, i.A())
+			}
+		case chunk.OpSelf:
+			key := constName(i.C())
+			parent, ok := names[i.B()]
+			if !ok {
+				parent = Unknown
+			}
+			if key == "" {
+				key = Unknown
+			}
+			names[i.A()+1] = parent
+			names[i.A()] = parent + ":" + key
+		case chunk.OpMove:
+			if name, ok := names[i.B()]; ok {
+				names[i.A()] = name
+			} else {
+				delete(names, i.A())
+			}
+		case chunk.OpCall, chunk.OpTailCall:
+			call := Call{Location: loc, Callee: Unknown, Args: i.B() - 1, Tail: op == chunk.OpTailCall}
+			if name, ok := names[i.A()]; ok {
+				call.Callee = name
+				// only SELF names values with :
+				call.Method = strings.Contains(name, ":")
+			}
+			if i.B() == 0 {
+				call.Args = VarArgs
+			} else if call.Method {
+				call.Args--
+			}
+			idx.Calls = append(idx.Calls, call)
+			clearFrom(i.A())
+		case chunk.OpLoadNil:
+			for r := i.A(); r <= i.B(); r++ {
+				delete(names, r)
+			}
+		case chunk.OpTForLoop:
+			clearFrom(i.A() + 2)
+		case chunk.OpClosure:
+			delete(names, i.A())
+			if i.Bx() < len(f.Functions) {
+				// skip instructions describing upvalues
+				pc += int(f.Functions[i.Bx()].NumUpvalues)
+			}
+		case chunk.OpSetUpval, chunk.OpSetTable, chunk.OpReturn, chunk.OpSetList, chunk.OpSetListO,
+			chunk.OpClose, chunk.OpJmp, chunk.OpEq, chunk.OpLt, chunk.OpLe:
+			// no registers are written
+		default:
+			delete(names, i.A())
+		}
+	}
+}
+
+// jumpTargets marks instructions, that can be reached from other places than the previous instruction
+func jumpTargets(f *chunk.Function) []bool {
+	targets := make([]bool, len(f.Code)+2)
+	for pc, i := range f.Code {
+		info := i.OpCode().Info()
+		switch {
+		case info.B == chunk.ArgJump:
+			if t := pc + 1 + i.SBx(); t >= 0 && t < len(targets) {
+				targets[t] = true
+			}
+		case i.OpCode() == chunk.OpLoadBool && i.C() != 0:
+			targets[pc+2] = true
+		}
+	}
+	return targets[:len(f.Code)]
+}
+
+// Usage sums up how a global name is used across indexes
+type Usage struct {
+	Name   string `json:"name"`
+	Reads  int    `json:"reads"`
+	Writes int    `json:"writes"`
+	Calls  int    `json:"calls"`
+	// Args lists distinct argument counts of calls, VarArgs included
+	Args  []int    `json:"args,omitempty"`
+	Files []string `json:"files"`
+}
+
+// Summarize aggregates globals and callees of all indexes, sorted by name. Callees are counted
+// under their full dotted name, so gw.video.play is listed separately from the gw global
+func Summarize(indexes []*Index) []Usage {
+	usages := map[string]*Usage{}
+	files := map[string]map[string]bool{}
+	args := map[string]map[int]bool{}
+	get := func(name, file string) *Usage {
+		u, ok := usages[name]
+		if !ok {
+			u = &Usage{Name: name}
+			usages[name] = u
+			files[name] = map[string]bool{}
+			args[name] = map[int]bool{}
+		}
+		if !files[name][file] {
+			files[name][file] = true
+			u.Files = append(u.Files, file)
+		}
+		return u
+	}
+
+	for _, idx := range indexes {
+		for _, g := range idx.Globals {
+			u := get(g.Name, idx.File)
+			if g.Write {
+				u.Writes++
+			} else {
+				u.Reads++
+			}
+		}
+		for _, c := range idx.Calls {
+			if c.Callee == Unknown {
+				continue
+			}
+			u := get(c.Callee, idx.File)
+			u.Calls++
+			args[c.Callee][c.Args] = true
+		}
+	}
+
+	result := make([]Usage, 0, len(usages))
+	for name, u := range usages {
+		for n := range args[name] {
+			u.Args = append(u.Args, n)
+		}
+		sort.Ints(u.Args)
+		result = append(result, *u)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
@@ -0,0 +1,130 @@
+package xref
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/namgo/GameWaveFans/pkg/zbc/asm"
+	"github.com/namgo/GameWaveFans/pkg/zbc/chunk"
+	"github.com/stretchr/testify/require"
+)
+
+// score = gw.video.play("intro.m2v", 1); menu:show(); print(f()); if x then return g(score) end
+const listing = `.chunk 5.0 little int=4 size_t=4 instruction=4 number=8 float
+.function main
+.maxstack 4
+.const K0 "score"
+.const K1 "gw"
+.const K2 "video"
+.const K3 "play"
+.const K4 "intro.m2v"
+.const K5 1
+.const K6 "menu"
+.const K7 "show"
+.const K8 "print"
+.const K9 "f"
+.const K10 "x"
+.const K11 "g"
+.code
+     1 [   1] GETGLOBAL  R0 K1
+     2 [   1] GETTABLE   R0 R0 K2
+     3 [   1] GETTABLE   R0 R0 K3
+     4 [   1] LOADK      R1 K4
+     5 [   1] LOADK      R2 K5
+     6 [   1] CALL       R0 3 2
+     7 [   1] SETGLOBAL  R0 K0
+     8 [   2] GETGLOBAL  R0 K6
+     9 [   2] SELF       R0 R0 K7
+    10 [   2] CALL       R0 2 1
+    11 [   3] GETGLOBAL  R0 K8
+    12 [   3] GETGLOBAL  R1 K9
+    13 [   3] CALL       R1 1 0
+    14 [   3] CALL       R0 0 1
+    15 [   4] GETGLOBAL  R0 K10
+    16 [   4] TEST       R0 R0 0
+    17 [   4] JMP        0 @19
+    18 [   4] GETGLOBAL  R0 K11
+    19 [   4] GETGLOBAL  R1 K0
+    20 [   4] TAILCALL   R0 2 0
+    21 [   4] RETURN     R0 0 0
+    22 [   5] RETURN     R0 1 0
+.end
+`
+
+func build(t *testing.T) *Index {
+	t.Helper()
+	c, err := asm.Assemble(strings.NewReader(listing))
+	require.NoError(t, err)
+	return Build(c, "game/main.zbc")
+}
+
+func TestBuild(t *testing.T) {
+	t.Parallel()
+	idx := build(t)
+
+	require.Len(t, idx.Strings, 11)
+	require.Equal(t, String{Function: "main", Index: 4, Value: "intro.m2v"}, idx.Strings[4])
+
+	at := func(pc, line int) Location { return Location{Function: "main", PC: pc, Line: line} }
+	require.Equal(t, []Global{
+		{at(1, 1), "gw", false},
+		{at(7, 1), "score", true},
+		{at(8, 2), "menu", false},
+		{at(11, 3), "print", false},
+		{at(12, 3), "f", false},
+		{at(15, 4), "x", false},
+		{at(18, 4), "g", false},
+		{at(19, 4), "score", false},
+	}, idx.Globals)
+
+	require.Equal(t, []Call{
+		{Location: at(6, 1), Callee: "gw.video.play", Args: 2},
+		{Location: at(10, 2), Callee: "menu:show", Args: 0, Method: true},
+		{Location: at(13, 3), Callee: "f", Args: 0},
+		{Location: at(14, 3), Callee: "print", Args: VarArgs},
+		// GETGLOBAL R0 K11 is skipped by the jump to @19, so R0 can't be named
+		{Location: at(20, 4), Callee: Unknown, Args: 1, Tail: true},
+	}, idx.Calls)
+}
+
+func TestSummarize(t *testing.T) {
+	t.Parallel()
+	idx := build(t)
+	other := &Index{File: "game/menu.zbc", Calls: []Call{{Callee: "gw.video.play", Args: VarArgs}}}
+
+	usages := Summarize([]*Index{idx, other})
+	names := make([]string, len(usages))
+	for k, u := range usages {
+		names[k] = u.Name
+	}
+	require.Equal(t, []string{"f", "g", "gw", "gw.video.play", "menu", "menu:show", "print", "score", "x"}, names)
+	require.Equal(t, Usage{
+		Name:  "gw.video.play",
+		Calls: 2,
+		Args:  []int{VarArgs, 2},
+		Files: []string{"game/main.zbc", "game/menu.zbc"},
+	}, usages[3])
+	require.Equal(t, Usage{Name: "score", Reads: 1, Writes: 1, Files: []string{"game/main.zbc"}}, usages[7])
+}
+
+func TestWriteCSV(t *testing.T) {
+	t.Parallel()
+	c := &chunk.Chunk{Main: &chunk.Function{
+		Constants: []chunk.Constant{chunk.StringConstant("say \"hi\""), chunk.StringConstant("print")},
+		Code: []chunk.Instruction{
+			chunk.NewABx(chunk.OpGetGlobal, 0, 1),
+			chunk.NewABx(chunk.OpLoadK, 1, 0),
+			chunk.NewABC(chunk.OpCall, 0, 2, 1),
+			chunk.NewABC(chunk.OpReturn, 0, 1, 0),
+		},
+	}}
+	out := bytes.Buffer{}
+	require.NoError(t, NewReport([]*Index{Build(c, "a.zbc")}).WriteCSV(&out))
+	require.Equal(t, `file,function,kind,pc,line,index,name,args
+a.zbc,main,string,,,0,"say ""hi""",
+a.zbc,main,string,,,1,print,
+a.zbc,main,read,1,,,print,
+a.zbc,main,call,3,,,print,1
+`, out.String())
+}
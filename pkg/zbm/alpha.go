@@ -0,0 +1,112 @@
+package zbm
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// AlphaMode selects how alpha of pixels is stored in textures, and read from them
+type AlphaMode int
+
+// Available alpha modes
+const (
+	// AlphaFull keeps 4 bits of alpha
+	AlphaFull AlphaMode = iota
+	// AlphaNone makes all pixels opaque. Textures are still stored in CrCbYA 3364 format,
+	// with all alpha bits set, as no format without alpha is known
+	AlphaNone
+	// AlphaKey makes pixels with alpha below the threshold fully transparent, and the others opaque,
+	// like a color key of OSD overlays
+	AlphaKey
+	// AlphaPremultiplied keeps 4 bits of alpha, with colors stored premultiplied by it
+	AlphaPremultiplied
+)
+
+// DefaultAlphaThreshold is the threshold used by AlphaKey mode, when Options don't set it
+const DefaultAlphaThreshold = 0x80
+
+var alphaModeNames = []string{"full", "none", "key", "premultiplied"}
+
+func (m AlphaMode) String() string {
+	if m >= 0 && int(m) < len(alphaModeNames) {
+		return alphaModeNames[m]
+	}
+	return fmt.Sprintf("AlphaMode(%d)", int(m))
+}
+
+// AlphaModeNames returns names of all alpha modes accepted by ParseAlphaMode
+func AlphaModeNames() []string {
+	return append([]string(nil), alphaModeNames...)
+}
+
+// ParseAlphaMode returns the alpha mode with the given name
+func ParseAlphaMode(name string) (AlphaMode, error) {
+	for m, n := range alphaModeNames {
+		if strings.EqualFold(name, n) {
+			return AlphaMode(m), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown alpha mode %q, expected one of: %s", name, strings.Join(alphaModeNames, ", "))
+}
+
+// threshold returns the alpha threshold of AlphaKey mode
+func (o *Options) threshold() uint8 {
+	if o.AlphaThreshold == 0 {
+		return DefaultAlphaThreshold
+	}
+	return o.AlphaThreshold
+}
+
+// keyAlpha returns 0xFF for alpha a at or above the threshold, and 0 below it
+func (o *Options) keyAlpha(a uint8) uint8 {
+	if a < o.threshold() {
+		return 0
+	}
+	return 0xFF
+}
+
+// encodeAlpha returns c with alpha mode of o applied, before it's quantized
+func (o *Options) encodeAlpha(c rgba) rgba {
+	switch o.Alpha {
+	case AlphaNone:
+		c[3] = 0xFF
+	case AlphaKey:
+		c[3] = float64(o.keyAlpha(uint8(c[3])))
+	case AlphaPremultiplied:
+		for k := range c[:3] {
+			c[k] *= c[3] / 0xFF
+		}
+	}
+	return c
+}
+
+// encodeAlphaWord returns CrCbYA 3364 word with alpha mode of o applied. Colors of words
+// are stored as they are, so premultiplied ones are kept
+func (o *Options) encodeAlphaWord(word uint16) uint16 {
+	switch o.Alpha {
+	case AlphaNone:
+		return word | 0xF000
+	case AlphaKey:
+		return word&0xFFF | uint16(o.keyAlpha(uint8(word>>12)*17)&0xF)<<12
+	}
+	return word
+}
+
+// decodeAlpha returns decoded color c with alpha mode of o applied
+func (o *Options) decodeAlpha(c color.NRGBA) color.NRGBA {
+	switch o.Alpha {
+	case AlphaNone:
+		c.A = 0xFF
+	case AlphaKey:
+		c.A = o.keyAlpha(c.A)
+	case AlphaPremultiplied:
+		if c.A != 0 {
+			a := int(c.A)
+			c.R = uint8(min((int(c.R)*0xFF+a/2)/a, 0xFF))
+			c.G = uint8(min((int(c.G)*0xFF+a/2)/a, 0xFF))
+			c.B = uint8(min((int(c.B)*0xFF+a/2)/a, 0xFF))
+		}
+	}
+	return c
+}
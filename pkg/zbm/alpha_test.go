@@ -0,0 +1,96 @@
+package zbm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAlphaMode(t *testing.T) {
+	t.Parallel()
+	for _, name := range AlphaModeNames() {
+		m, err := ParseAlphaMode(name)
+		require.NoError(t, err)
+		require.Equal(t, name, m.String())
+	}
+	_, err := ParseAlphaMode("binary")
+	require.EqualError(t, err, `unknown alpha mode "binary", expected one of: full, none, key, premultiplied`)
+}
+
+func TestEncodeAlpha(t *testing.T) {
+	t.Parallel()
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	for x, a := range []uint8{0x40, 0xC0, 0xFF} {
+		img.SetNRGBA(x, 0, color.NRGBA{200, 100, 50, a})
+	}
+	native := NewCrCbYA(image.Rect(0, 0, 3, 1))
+	copy(native.Pix, []uint16{0x3ABC, 0xCABC, 0xFABC})
+
+	cases := []struct {
+		name      string
+		options   Options
+		alphas    []uint16
+		nativePix []uint16
+	}{
+		{"full", Options{Quantizer: Nearest}, []uint16{4, 11, 15}, []uint16{0x3ABC, 0xCABC, 0xFABC}},
+		{"none", Options{Quantizer: Nearest, Alpha: AlphaNone}, []uint16{15, 15, 15}, []uint16{0xFABC, 0xFABC, 0xFABC}},
+		{"key", Options{Quantizer: Nearest, Alpha: AlphaKey}, []uint16{0, 15, 15}, []uint16{0x0ABC, 0xFABC, 0xFABC}},
+		{"key threshold", Options{Quantizer: Nearest, Alpha: AlphaKey, AlphaThreshold: 0xC1}, []uint16{0, 0, 15}, []uint16{0x0ABC, 0xFABC, 0xFABC}},
+		{"truncate key", Options{Alpha: AlphaKey}, []uint16{0, 15, 15}, []uint16{0x0ABC, 0xFABC, 0xFABC}},
+		{"premultiplied", Options{Quantizer: Nearest, Alpha: AlphaPremultiplied}, []uint16{4, 11, 15}, []uint16{0x3ABC, 0xCABC, 0xFABC}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			pixels := convertImage(img, &tt.options)
+			for x, word := range pixels {
+				require.Equal(t, tt.alphas[x], word>>12, "pixel %d", x)
+			}
+			require.Equal(t, tt.nativePix, convertImage(native, &tt.options))
+
+			// colors of opaque pixels don't depend on alpha mode
+			opaque := tt.options
+			opaque.Alpha = AlphaFull
+			require.Equal(t, convertImage(img, &opaque)[2], pixels[2])
+		})
+	}
+
+	// colors are premultiplied before they are quantized
+	pixels := convertImage(img, &Options{Quantizer: Nearest, Alpha: AlphaPremultiplied})
+	require.Equal(t, nearestWord(rgba{50, 25, 12.5, 0x40}), pixels[0])
+}
+
+func TestDecodeAlpha(t *testing.T) {
+	t.Parallel()
+	// gray with alpha 8, and opaque white
+	data := texture(t, FormatCrCbYA3364, 2, 2, 1, 0xFFE4<<16|0x8824)
+	white := color.NRGBA{0xFC, 0xFC, 0xFC, 0xFF}
+	cases := []struct {
+		name     string
+		options  *Options
+		expected []color.NRGBA
+	}{
+		{"default", nil, []color.NRGBA{{0x80, 0x80, 0x80, 0x88}, white}},
+		{"none", &Options{Alpha: AlphaNone}, []color.NRGBA{{0x80, 0x80, 0x80, 0xFF}, white}},
+		{"key", &Options{Alpha: AlphaKey}, []color.NRGBA{{0x80, 0x80, 0x80, 0xFF}, white}},
+		{"key threshold", &Options{Alpha: AlphaKey, AlphaThreshold: 0x89}, []color.NRGBA{{0x80, 0x80, 0x80, 0}, white}},
+		{"premultiplied", &Options{Alpha: AlphaPremultiplied}, []color.NRGBA{{0xF0, 0xF0, 0xF0, 0x88}, white}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			img, err := DecodeOptions(bytes.NewReader(data), tt.options)
+			require.NoError(t, err)
+			for x, c := range tt.expected {
+				require.Equal(t, c, img.At(x, 0), "pixel %d", x)
+			}
+
+			tex, err := DecodeTexture(bytes.NewReader(data), tt.options)
+			require.NoError(t, err)
+			require.Equal(t, img, tex.Levels[0])
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package zbm
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"slices"
+	"strings"
+)
+
+/*
+Atlases pack many small sprites into a few textures of legal sizes. Sprites are placed on shelves:
+rows as high as their tallest sprite, filled from left to right, with the tallest sprites placed first.
+*/
+
+// Sprite is a named image packed into an atlas
+type Sprite struct {
+	Name  string
+	Image image.Image
+}
+
+// Placement is the position of a sprite in an atlas
+type Placement struct {
+	Name string `json:"name"`
+	// Atlas is the index of the atlas, counted from 0
+	Atlas  int `json:"atlas"`
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Atlas is an image with sprites packed into it
+type Atlas struct {
+	Image      *image.NRGBA
+	Placements []Placement
+}
+
+// AtlasOptions are the packing parameters
+type AtlasOptions struct {
+	// Sizes lists legal sizes of atlases
+	Sizes SizeTable
+	// MaxWidth and MaxHeight limit the size of atlases, 0 means the largest legal size
+	MaxWidth  int
+	MaxHeight int
+	// Spacing is the number of transparent pixels between sprites, so they don't bleed into each other
+	Spacing int
+}
+
+// shelf is a row of sprites in an atlas
+type shelf struct {
+	y, height, width int
+}
+
+// atlasLayout is an atlas being filled with sprites
+type atlasLayout struct {
+	shelves    []shelf
+	placements []Placement
+	// size of the area covered by sprites
+	width, height int
+}
+
+// place puts a sprite of the given size on a shelf of the atlas, or on a new shelf,
+// and reports whether it fits
+func (l *atlasLayout) place(p *Placement, maxWidth, maxHeight, spacing int) bool {
+	for i := range l.shelves {
+		s := &l.shelves[i]
+		x := s.width
+		if x > 0 {
+			x += spacing
+		}
+		if p.Height <= s.height && x+p.Width <= maxWidth {
+			p.X, p.Y = x, s.y
+			s.width = x + p.Width
+			l.add(*p)
+			return true
+		}
+	}
+	y := l.height
+	if y > 0 {
+		y += spacing
+	}
+	if y+p.Height > maxHeight || p.Width > maxWidth {
+		return false
+	}
+	l.shelves = append(l.shelves, shelf{y: y, height: p.Height, width: p.Width})
+	p.X, p.Y = 0, y
+	l.add(*p)
+	return true
+}
+
+func (l *atlasLayout) add(p Placement) {
+	l.placements = append(l.placements, p)
+	l.width = max(l.width, p.X+p.Width)
+	l.height = max(l.height, p.Y+p.Height)
+}
+
+// largestSize returns the largest of sorted sizes not larger than limit, or the largest one if limit is 0
+func largestSize(sizes []int, limit int) (int, bool) {
+	if limit == 0 {
+		return sizes[len(sizes)-1], true
+	}
+	i, found := slices.BinarySearch(sizes, limit)
+	if found {
+		return sizes[i], true
+	}
+	if i == 0 {
+		return 0, false
+	}
+	return sizes[i-1], true
+}
+
+// PackAtlases packs sprites into as few atlases as possible, each of them has the smallest
+// legal size fitting its sprites. Placements of every atlas are sorted by name
+func PackAtlases(sprites []Sprite, o AtlasOptions) ([]Atlas, error) {
+	maxWidth, okWidth := largestSize(o.Sizes.Widths, o.MaxWidth)
+	maxHeight, okHeight := largestSize(o.Sizes.Heights, o.MaxHeight)
+	if !okWidth || !okHeight {
+		return nil, FormatError(fmt.Sprintf("no legal atlas size fits in %dx%d", o.MaxWidth, o.MaxHeight))
+	}
+
+	images := map[string]image.Image{}
+	placements := make([]Placement, len(sprites))
+	for i, s := range sprites {
+		if _, ok := images[s.Name]; ok {
+			return nil, FormatError(fmt.Sprintf("duplicate sprite name %s", s.Name))
+		}
+		images[s.Name] = s.Image
+		b := s.Image.Bounds()
+		placements[i] = Placement{Name: s.Name, Width: b.Dx(), Height: b.Dy()}
+	}
+	slices.SortStableFunc(placements, func(a, b Placement) int {
+		return cmp.Or(cmp.Compare(b.Height, a.Height), cmp.Compare(b.Width, a.Width), strings.Compare(a.Name, b.Name))
+	})
+
+	var layouts []*atlasLayout
+	for _, p := range placements {
+		placed := false
+		for i, l := range layouts {
+			p.Atlas = i
+			if placed = l.place(&p, maxWidth, maxHeight, o.Spacing); placed {
+				break
+			}
+		}
+		if placed {
+			continue
+		}
+		l := &atlasLayout{}
+		p.Atlas = len(layouts)
+		if !l.place(&p, maxWidth, maxHeight, o.Spacing) {
+			return nil, FormatError(fmt.Sprintf("sprite %s of size %dx%d doesn't fit in %dx%d atlas", p.Name, p.Width, p.Height, maxWidth, maxHeight))
+		}
+		layouts = append(layouts, l)
+	}
+
+	atlases := make([]Atlas, len(layouts))
+	for i, l := range layouts {
+		width, height, err := o.Sizes.Padded(l.width, l.height)
+		if err != nil {
+			return nil, err
+		}
+		img := image.NewNRGBA(image.Rect(0, 0, width, height))
+		for _, p := range l.placements {
+			m := images[p.Name]
+			draw.Draw(img, image.Rect(p.X, p.Y, p.X+p.Width, p.Y+p.Height), m, m.Bounds().Min, draw.Src)
+		}
+		slices.SortFunc(l.placements, func(a, b Placement) int { return strings.Compare(a.Name, b.Name) })
+		atlases[i] = Atlas{Image: img, Placements: l.placements}
+	}
+	return atlases, nil
+}
+
+// AtlasFile describes a texture of an atlas in a manifest
+type AtlasFile struct {
+	File   string `json:"file"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Manifest lists textures of atlases and placements of sprites in them, so scripts can find sprites
+type Manifest struct {
+	Atlases []AtlasFile `json:"atlases"`
+	Sprites []Placement `json:"sprites"`
+}
+
+// NewManifest returns manifest of atlases stored in the given files
+func NewManifest(atlases []Atlas, files []string) *Manifest {
+	m := &Manifest{Atlases: []AtlasFile{}, Sprites: []Placement{}}
+	for i, a := range atlases {
+		b := a.Image.Bounds()
+		m.Atlases = append(m.Atlases, AtlasFile{File: files[i], Width: b.Dx(), Height: b.Dy()})
+		m.Sprites = append(m.Sprites, a.Placements...)
+	}
+	slices.SortFunc(m.Sprites, func(a, b Placement) int { return strings.Compare(a.Name, b.Name) })
+	return m
+}
+
+// WriteJSON writes m as indented JSON
+func (m *Manifest) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// WriteLua writes m as a Lua chunk returning a table with atlases list, and sprites keyed by name.
+// Lua lists are counted from 1, so are atlas indexes of sprites
+func (m *Manifest) WriteLua(w io.Writer) error {
+	b := strings.Builder{}
+	b.WriteString("return {\n\tatlases = {\n")
+	for _, a := range m.Atlases {
+		fmt.Fprintf(&b, "\t\t{ file = %s, width = %d, height = %d },\n", luaQuote(a.File), a.Width, a.Height)
+	}
+	b.WriteString("\t},\n\tsprites = {\n")
+	for _, p := range m.Sprites {
+		fmt.Fprintf(&b, "\t\t[%s] = { atlas = %d, x = %d, y = %d, width = %d, height = %d },\n",
+			luaQuote(p.Name), p.Atlas+1, p.X, p.Y, p.Width, p.Height)
+	}
+	b.WriteString("\t},\n}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// luaQuote formats a string as Lua literal, control characters are written as decimal escapes
+func luaQuote(s string) string {
+	b := strings.Builder{}
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\%03d`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,130 @@
+package zbm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sprite returns sprite of the given size filled with color c
+func sprite(name string, width, height int, c color.NRGBA) Sprite {
+	m := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			m.SetNRGBA(x, y, c)
+		}
+	}
+	return Sprite{Name: name, Image: m}
+}
+
+func TestPackAtlases(t *testing.T) {
+	t.Parallel()
+	sizes, err := NewSizeTable([]int{8, 16, 32}, []int{8, 16, 32})
+	require.NoError(t, err)
+	red := color.NRGBA{0xFF, 0, 0, 0xFF}
+	sprites := []Sprite{
+		sprite("c", 8, 8, red),
+		sprite("a", 16, 8, red),
+		sprite("b", 8, 8, color.NRGBA{0, 0, 0xFF, 0x80}),
+		sprite("big", 32, 32, red),
+	}
+
+	cases := []struct {
+		name    string
+		spacing int
+		sizes   []image.Rectangle
+		// placements of all atlases
+		placements []Placement
+	}{
+		{
+			name:  "no spacing",
+			sizes: []image.Rectangle{image.Rect(0, 0, 32, 32), image.Rect(0, 0, 32, 8)},
+			placements: []Placement{
+				{Name: "big", Atlas: 0, Width: 32, Height: 32},
+				{Name: "a", Atlas: 1, Width: 16, Height: 8},
+				{Name: "b", Atlas: 1, X: 16, Width: 8, Height: 8},
+				{Name: "c", Atlas: 1, X: 24, Width: 8, Height: 8},
+			},
+		},
+		{
+			name:    "spacing",
+			spacing: 1,
+			sizes:   []image.Rectangle{image.Rect(0, 0, 32, 32), image.Rect(0, 0, 32, 32)},
+			placements: []Placement{
+				{Name: "big", Atlas: 0, Width: 32, Height: 32},
+				{Name: "a", Atlas: 1, Width: 16, Height: 8},
+				{Name: "b", Atlas: 1, X: 17, Width: 8, Height: 8},
+				{Name: "c", Atlas: 1, Y: 9, Width: 8, Height: 8},
+			},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			atlases, err := PackAtlases(sprites, AtlasOptions{Sizes: sizes, Spacing: tt.spacing})
+			require.NoError(t, err)
+			require.Len(t, atlases, len(tt.sizes))
+			var placements []Placement
+			for i, a := range atlases {
+				require.Equal(t, tt.sizes[i], a.Image.Bounds())
+				placements = append(placements, a.Placements...)
+				for _, p := range a.Placements {
+					for _, s := range sprites {
+						if s.Name == p.Name {
+							require.Equal(t, s.Image.At(0, 0), a.Image.At(p.X+p.Width-1, p.Y+p.Height-1))
+						}
+					}
+				}
+			}
+			require.ElementsMatch(t, tt.placements, placements)
+			if tt.spacing > 0 {
+				// pixels between sprites stay transparent
+				require.Equal(t, color.NRGBA{}, atlases[1].Image.NRGBAAt(16, 0))
+				require.Equal(t, color.NRGBA{}, atlases[1].Image.NRGBAAt(0, 8))
+			}
+		})
+	}
+
+	_, err = PackAtlases(sprites, AtlasOptions{Sizes: sizes, MaxWidth: 16})
+	require.EqualError(t, err, "gamewave zbm error:sprite big of size 32x32 doesn't fit in 16x32 atlas")
+	_, err = PackAtlases(sprites, AtlasOptions{Sizes: sizes, MaxWidth: 4})
+	require.EqualError(t, err, "gamewave zbm error:no legal atlas size fits in 4x0")
+	_, err = PackAtlases(append(sprites, sprites[0]), AtlasOptions{Sizes: sizes})
+	require.EqualError(t, err, "gamewave zbm error:duplicate sprite name c")
+}
+
+func TestManifest(t *testing.T) {
+	t.Parallel()
+	atlases := []Atlas{
+		{Image: image.NewNRGBA(image.Rect(0, 0, 16, 8)), Placements: []Placement{{Name: `ui/"ok"`, Atlas: 0, X: 8, Width: 8, Height: 8}}},
+		{Image: image.NewNRGBA(image.Rect(0, 0, 8, 8)), Placements: []Placement{{Name: "icon", Atlas: 1, Width: 4, Height: 2}}},
+	}
+	m := NewManifest(atlases, []string{"ui_0.zbm", "ui_1.zbm"})
+
+	buf := bytes.Buffer{}
+	require.NoError(t, m.WriteJSON(&buf))
+	require.JSONEq(t, `{
+		"atlases": [{"file": "ui_0.zbm", "width": 16, "height": 8}, {"file": "ui_1.zbm", "width": 8, "height": 8}],
+		"sprites": [
+			{"name": "icon", "atlas": 1, "x": 0, "y": 0, "width": 4, "height": 2},
+			{"name": "ui/\"ok\"", "atlas": 0, "x": 8, "y": 0, "width": 8, "height": 8}
+		]
+	}`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, m.WriteLua(&buf))
+	require.Equal(t, `return {
+	atlases = {
+		{ file = "ui_0.zbm", width = 16, height = 8 },
+		{ file = "ui_1.zbm", width = 8, height = 8 },
+	},
+	sprites = {
+		["icon"] = { atlas = 2, x = 0, y = 0, width = 4, height = 2 },
+		["ui/\"ok\""] = { atlas = 1, x = 8, y = 0, width = 8, height = 8 },
+	},
+}
+`, buf.String())
+}
@@ -1,24 +1,6 @@
 // Package zbm helps interfacing with zbm files, un unpack and repack them
 package zbm
 
-import "io"
-
-type config struct {
-	r            io.Reader
-	unknown1     uint32
-	unknown2     uint32
-	unknown3     uint32
-	unknown4     uint32
-	width        uint32
-	height       uint32
-	unknown5     uint32
-	unknown6     uint32
-	unknown7     uint32
-	sizePacked   uint32
-	sizeUnpacked uint32
-	unknown8     uint32
-}
-
 // A FormatError reports that the input is not a valid Gamewave texture.
 type FormatError string
 
@@ -26,4 +8,9 @@ func (e FormatError) Error() string { return "gamewave zbm error:" + string(e) }
 
 // FormatName is the name of the registered texture format
 const FormatName = "zbm"
-const textureHeader = ""
+
+// textureHeader is the magic string used by image.Decode to detect textures: type 1, values
+// of OSD, format and bytes per pixel fields smaller than 256, and zlib stream with the default
+// window size following the header. '?' matches any byte
+const textureHeader = "\x01\x00\x00\x00?\x00\x00\x00?\x00\x00\x00?\x00\x00\x00" +
+	"????????????????????????????????" + "\x78"
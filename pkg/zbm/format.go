@@ -0,0 +1,83 @@
+package zbm
+
+import (
+	"fmt"
+	"image/color"
+)
+
+/*
+Pixel data is stored as big endian 32-bit words, 16-bit pixels are packed into words starting
+from the least significant bits, which looks like every two pixels being swapped. Pixel data
+is padded to whole words, so the last pixel of odd count is stored in the low half of the last
+word, with zero high half.
+
+Only 3364 CrCbYA format, with id 4, is used by official games, and it's the only one decoded.
+Ids and layouts of other formats are unknown, textures declaring them are rejected with
+FormatError instead of being decoded as garbage. Textures without alpha are stored in 3364 format
+with opaque alpha, see AlphaNone.
+*/
+
+// Format is the pixel format of a texture, as stored in its header
+type Format uint32
+
+// Known pixel formats
+const (
+	// FormatCrCbYA3364 is 16-bit colors, with 3 bits of Cr, 3 bits of Cb, 6 bits of Y and 4 bits of alpha
+	FormatCrCbYA3364 Format = 4
+)
+
+type formatInfo struct {
+	name string
+	// bits per pixel
+	bits int
+}
+
+var formats = map[Format]formatInfo{
+	FormatCrCbYA3364: {"CrCbYA3364", 16},
+}
+
+func (f Format) String() string {
+	if info, ok := formats[f]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("Format(%d)", uint32(f))
+}
+
+// Known reports whether pixels of format f can be decoded
+func (f Format) Known() bool {
+	_, ok := formats[f]
+	return ok
+}
+
+// BitsPerPixel returns size of a single pixel in bits, or 0 for unknown formats
+func (f Format) BitsPerPixel() int {
+	return formats[f].bits
+}
+
+// BytesPerPixel returns the value of BytesPerPixel header field used with format f
+func (f Format) BytesPerPixel() uint32 {
+	return uint32(formats[f].bits / 8)
+}
+
+// imageSize returns the number of bytes needed to store width*height pixels
+func (f Format) imageSize(width, height int) int {
+	return (width*height*f.BitsPerPixel() + 7) / 8
+}
+
+// convertYCbCr converts YCrCb colors to RGB with clamping to avoid overflows when converting to uint8
+func convertYCbCr(y, cb, cr, a uint8) color.NRGBA {
+	cb1 := int32(cb) - 128
+	cr1 := int32(cr) - 128
+	return color.NRGBA{
+		R: clampUint8(int32(y) + (int32(45*cr1) / 32)),
+		G: clampUint8(int32(y) - (int32(11*cb1+23*cr1) / 32)),
+		B: clampUint8(int32(y) + (int32(113*cb1) / 64)),
+		A: a,
+	}
+}
+
+// convertCrCbYA3364 converts a 16-bit CrCbYA word to RGB
+func convertCrCbYA3364(value uint16) color.NRGBA {
+	cr, cb, y, a := getPixelValue(value)
+	return convertYCbCr(y, cb, cr, a)
+}
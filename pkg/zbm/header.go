@@ -0,0 +1,136 @@
+package zbm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// HeaderSize is the size of zbm header, packed pixel data follows it
+const HeaderSize = 0x30
+
+// MaxLevels is the largest number of levels of a texture
+const MaxLevels = 16
+
+// TextureOSD is the value of OSD field of textures drawn by on screen display
+const TextureOSD = 1
+
+// Header is the header of zbm file, all fields are stored as little endian uint32.
+// Fields with unknown meaning are kept, so headers of original textures can be written back unchanged
+type Header struct {
+	// Type is 1 in all known textures
+	Type uint32
+	// OSD is TextureOSD for textures drawn by on screen display
+	OSD uint32
+	// FormatID is the pixel format
+	FormatID Format
+	// BytesPerPixel is the size of a single pixel, 2 in all known textures
+	BytesPerPixel uint32
+	Width         uint32
+	Height        uint32
+	// Unknown5 and Unknown6 are 0 in all known textures
+	Unknown5 uint32
+	Unknown6 uint32
+	// Levels is the number of images, 1 in all known textures.
+	// Following images are treated as mip levels, each half the size of the previous one,
+	// which is a guess, reading this field as a mip count wasn't verified on the console
+	Levels uint32
+	// SizePacked is the size of zlib stream following the header
+	SizePacked uint32
+	// SizeUnpacked is the size of pixel data after unpacking
+	SizeUnpacked uint32
+	// Unknown8 is 0 in all known textures
+	Unknown8 uint32
+}
+
+// NewHeader returns header of a texture in CrCbYA 3364 format, like the ones used by official games.
+// Sizes of data have to be set before writing it
+func NewHeader(width, height int) Header {
+	return Header{
+		Type:          1,
+		OSD:           TextureOSD,
+		FormatID:      FormatCrCbYA3364,
+		BytesPerPixel: FormatCrCbYA3364.BytesPerPixel(),
+		Width:         uint32(width),
+		Height:        uint32(height),
+		Levels:        1,
+	}
+}
+
+func (h *Header) fields() []*uint32 {
+	return []*uint32{
+		&h.Type, &h.OSD, (*uint32)(&h.FormatID), &h.BytesPerPixel, &h.Width, &h.Height,
+		&h.Unknown5, &h.Unknown6, &h.Levels, &h.SizePacked, &h.SizeUnpacked, &h.Unknown8,
+	}
+}
+
+// ReadHeader reads header of zbm file. Values aren't validated, so headers of unsupported
+// textures can be inspected too
+func ReadHeader(r io.Reader) (Header, error) {
+	buf := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Header{}, err
+	}
+
+	var h Header
+	for i, field := range h.fields() {
+		*field = binary.LittleEndian.Uint32(buf[4*i : 4*i+4])
+	}
+	return h, nil
+}
+
+// WriteHeader writes h to w
+func WriteHeader(w io.Writer, h Header) error {
+	buf := make([]byte, HeaderSize)
+	for i, field := range h.fields() {
+		binary.LittleEndian.PutUint32(buf[4*i:4*i+4], *field)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// LevelCount returns the number of images of the texture, textures with 0 levels have a single image
+func (h *Header) LevelCount() int {
+	return max(int(h.Levels), 1)
+}
+
+// LevelSize returns width and height of image at the given level, each level is half the size
+// of the previous one, and at least 1x1
+func (h *Header) LevelSize(level int) (width, height int) {
+	return max(int(h.Width)>>level, 1), max(int(h.Height)>>level, 1)
+}
+
+// dataSize returns the size of pixels of all levels. Pixels of each level are
+// padded to whole words, older versions of this package didn't pad the last level,
+// so both sizes are valid
+func (h *Header) dataSize() (minimum, padded int) {
+	for level := 0; level < h.LevelCount(); level++ {
+		size := h.FormatID.imageSize(h.LevelSize(level))
+		padded = minimum + (size+3)/4*4
+		minimum += size
+		if level < h.LevelCount()-1 {
+			minimum = padded
+		}
+	}
+	return minimum, padded
+}
+
+// check returns an error if texture with header h can't be decoded
+func (h *Header) check() error {
+	if h.Width == 0 || h.Height == 0 {
+		return FormatError(fmt.Sprintf("unsupported size: %dx%d\n", h.Width, h.Height))
+	}
+	if !h.FormatID.Known() {
+		return FormatError(fmt.Sprintf("unsupported pixel format %d", uint32(h.FormatID)))
+	}
+	if h.BytesPerPixel != h.FormatID.BytesPerPixel() {
+		return FormatError(fmt.Sprintf("%d bytes per pixel don't match %s format", h.BytesPerPixel, h.FormatID))
+	}
+	if h.Levels > MaxLevels {
+		return FormatError(fmt.Sprintf("unsupported number of levels %d", h.Levels))
+	}
+	return nil
+}
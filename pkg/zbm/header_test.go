@@ -0,0 +1,67 @@
+package zbm
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeader(t *testing.T) {
+	t.Parallel()
+	// header of a 16x8 texture, with unknown fields set
+	data := []byte{
+		0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00,
+		0x10, 0x00, 0x00, 0x00, 0x08, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x00, 0x00, 0x34, 0x12, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x08, 0x00, 0x00, 0x80,
+	}
+	h, err := ReadHeader(bytes.NewReader(data))
+	require.NoError(t, err)
+	expected := NewHeader(16, 8)
+	expected.Unknown5 = 5
+	expected.Unknown6 = 6
+	expected.SizePacked = 0x1234
+	expected.SizeUnpacked = 0x100
+	expected.Unknown8 = 0x80000008
+	require.Equal(t, expected, h)
+
+	buf := bytes.Buffer{}
+	require.NoError(t, WriteHeader(&buf, h))
+	require.Equal(t, data, buf.Bytes())
+
+	_, err = ReadHeader(bytes.NewReader(data[:HeaderSize-1]))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestLevels(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name            string
+		width, height   int
+		format          Format
+		levels          uint32
+		sizes           [][2]int
+		minimum, padded int
+	}{
+		{"no levels", 3, 1, FormatCrCbYA3364, 0, [][2]int{{3, 1}}, 6, 8},
+		{"single level", 4, 2, FormatCrCbYA3364, 1, [][2]int{{4, 2}}, 16, 16},
+		{"odd levels", 5, 3, FormatCrCbYA3364, 3, [][2]int{{5, 3}, {2, 1}, {1, 1}}, 32 + 4 + 2, 32 + 4 + 4},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			h := NewHeader(tt.width, tt.height)
+			h.FormatID = tt.format
+			h.Levels = tt.levels
+			require.Equal(t, len(tt.sizes), h.LevelCount())
+			for level, size := range tt.sizes {
+				width, height := h.LevelSize(level)
+				require.Equal(t, size, [2]int{width, height})
+			}
+			minimum, padded := h.dataSize()
+			require.Equal(t, tt.minimum, minimum)
+			require.Equal(t, tt.padded, padded)
+		})
+	}
+}
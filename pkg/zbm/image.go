@@ -0,0 +1,126 @@
+package zbm
+
+import (
+	"image"
+	"image/color"
+	"io"
+)
+
+// CrCbYAColor is a 16-bit CrCbYA 3364 color, as stored in textures
+type CrCbYAColor uint16
+
+// RGBA returns the alpha-premultiplied color, converted the way the console does it
+func (c CrCbYAColor) RGBA() (r, g, b, a uint32) {
+	return convertCrCbYA3364(uint16(c)).RGBA()
+}
+
+// CrCbYAModel is the color model of CrCbYA 3364 colors
+var CrCbYAModel = color.ModelFunc(crCbYAModel)
+
+func crCbYAModel(c color.Color) color.Color {
+	if c, ok := c.(CrCbYAColor); ok {
+		return c
+	}
+	return CrCbYAColor(convertColorToCrCbYA(c))
+}
+
+// CrCbYA is an in-memory image of CrCbYA 3364 pixels, which can be encoded without conversion
+type CrCbYA struct {
+	// Pix holds pixels of the image, the pixel at (x, y) is at Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)]
+	Pix []uint16
+	// Stride is the Pix distance between vertically adjacent pixels
+	Stride int
+	// Rect is the image's bounds
+	Rect image.Rectangle
+}
+
+// NewCrCbYA returns a new CrCbYA image with the given bounds
+func NewCrCbYA(r image.Rectangle) *CrCbYA {
+	return &CrCbYA{
+		Pix:    make([]uint16, r.Dx()*r.Dy()),
+		Stride: r.Dx(),
+		Rect:   r,
+	}
+}
+
+// ColorModel returns CrCbYAModel
+func (p *CrCbYA) ColorModel() color.Model { return CrCbYAModel }
+
+// Bounds returns the image's bounds
+func (p *CrCbYA) Bounds() image.Rectangle { return p.Rect }
+
+// At returns the color of the pixel at (x, y)
+func (p *CrCbYA) At(x, y int) color.Color {
+	return p.CrCbYAAt(x, y)
+}
+
+// CrCbYAAt returns the color of the pixel at (x, y), without converting it
+func (p *CrCbYA) CrCbYAAt(x, y int) CrCbYAColor {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return 0
+	}
+	return CrCbYAColor(p.Pix[p.PixOffset(x, y)])
+}
+
+// PixOffset returns the index of the pixel at (x, y) in Pix
+func (p *CrCbYA) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x - p.Rect.Min.X)
+}
+
+// Set sets the pixel at (x, y), converting c to CrCbYA
+func (p *CrCbYA) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	p.Pix[p.PixOffset(x, y)] = uint16(CrCbYAModel.Convert(c).(CrCbYAColor))
+}
+
+// SetCrCbYA sets the pixel at (x, y)
+func (p *CrCbYA) SetCrCbYA(x, y int, c CrCbYAColor) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	p.Pix[p.PixOffset(x, y)] = uint16(c)
+}
+
+// SubImage returns an image representing the portion of p visible through r,
+// sharing pixels with p
+func (p *CrCbYA) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	if r.Empty() {
+		return &CrCbYA{}
+	}
+	return &CrCbYA{
+		Pix:    p.Pix[p.PixOffset(r.Min.X, r.Min.Y):],
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque
+func (p *CrCbYA) Opaque() bool {
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		i := p.PixOffset(p.Rect.Min.X, y)
+		for _, c := range p.Pix[i : i+p.Rect.Dx()] {
+			if c>>12 != 0xF {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// DecodeCrCbYA reads zbm file in CrCbYA 3364 format as CrCbYA image, without converting its pixels.
+// Decode still returns NRGBA images, as other image encoders lose colors of transparent pixels
+// of images with other color models
+func DecodeCrCbYA(r io.Reader) (*CrCbYA, error) {
+	h, pixels, err := DecodeRaw(r)
+	if err != nil {
+		return nil, err
+	}
+	return &CrCbYA{
+		Pix:    pixels,
+		Stride: int(h.Width),
+		Rect:   image.Rect(0, 0, int(h.Width), int(h.Height)),
+	}, nil
+}
@@ -0,0 +1,42 @@
+package zbm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCrCbYA(t *testing.T) {
+	t.Parallel()
+	white := CrCbYAColor(0xFFE4)
+	img := NewCrCbYA(image.Rect(1, 1, 4, 3))
+	require.Equal(t, CrCbYAModel, img.ColorModel())
+	require.False(t, img.Opaque())
+
+	img.SetCrCbYA(3, 2, white)
+	require.Equal(t, white, img.At(3, 2))
+	require.Equal(t, uint16(white), img.Pix[5])
+	require.Equal(t, CrCbYAColor(0), img.CrCbYAAt(0, 0))
+
+	// colors are converted when set, and when read through color.Color
+	img.Set(1, 1, color.NRGBA{0x80, 0x80, 0x80, 0xFF})
+	require.Equal(t, CrCbYAColor(0xF7E4), img.At(1, 1))
+	require.Equal(t, color.NRGBA{0xFC, 0xFC, 0xFC, 0xFF}, color.NRGBAModel.Convert(white))
+
+	// drawing over a sub-image changes pixels of the parent image
+	sub := img.SubImage(image.Rect(2, 1, 4, 3)).(*CrCbYA)
+	draw.Draw(sub, sub.Bounds(), image.NewUniform(white), image.Point{}, draw.Src)
+	require.Equal(t, []uint16{0xF7E4, 0xFFE4, 0xFFE4, 0, 0xFFE4, 0xFFE4}, img.Pix)
+	require.True(t, sub.Opaque())
+
+	// sub-images are encoded without conversion
+	buf := bytes.Buffer{}
+	require.NoError(t, EncodeOptions(&buf, img.SubImage(image.Rect(1, 1, 3, 3)), &Options{Quantizer: FloydSteinberg}))
+	decoded, err := DecodeCrCbYA(&buf)
+	require.NoError(t, err)
+	require.Equal(t, &CrCbYA{Pix: []uint16{0xF7E4, 0xFFE4, 0, 0xFFE4}, Stride: 2, Rect: image.Rect(0, 0, 2, 2)}, decoded)
+}
@@ -0,0 +1,61 @@
+package zbm
+
+import "math"
+
+/*
+Perceptual quantizer compares colors in CIELAB space, where distances match differences seen
+by people better than in RGB. Colors are converted from sRGB, with D65 white point.
+*/
+
+// labSpace measures distance between colors in CIELAB
+var labSpace = &colorSpace{
+	grid:    32,
+	convert: rgbToLab,
+	bounds:  labBounds,
+}
+
+// linear converts sRGB component in range 0-255 to linear light
+func linear(v float64) float64 {
+	v /= 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// rgbToXYZ converts sRGB color to CIE XYZ, scaled so the white point is 1 in all components
+func rgbToXYZ(c [3]float64) [3]float64 {
+	r, g, b := linear(c[0]), linear(c[1]), linear(c[2])
+	return [3]float64{
+		(0.4124*r + 0.3576*g + 0.1805*b) / 0.95047,
+		0.2126*r + 0.7152*g + 0.0722*b,
+		(0.0193*r + 0.1192*g + 0.9505*b) / 1.08883,
+	}
+}
+
+func labF(t float64) float64 {
+	const delta = 6.0 / 29
+	if t > delta*delta*delta {
+		return math.Cbrt(t)
+	}
+	return t/(3*delta*delta) + 4.0/29
+}
+
+// rgbToLab converts sRGB color to CIELAB
+func rgbToLab(c [3]float64) [3]float64 {
+	xyz := rgbToXYZ(c)
+	fx, fy, fz := labF(xyz[0]), labF(xyz[1]), labF(xyz[2])
+	return [3]float64{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+// labBounds returns bounds of CIELAB colors of sRGB colors between lo and hi. X, Y and Z
+// grow with every sRGB component, so their bounds are the converted lo and hi,
+// bounds of a and b are computed from them
+func labBounds(lo, hi [3]float64) (minimum, maximum [3]float64) {
+	xyzLo, xyzHi := rgbToXYZ(lo), rgbToXYZ(hi)
+	fxLo, fyLo, fzLo := labF(xyzLo[0]), labF(xyzLo[1]), labF(xyzLo[2])
+	fxHi, fyHi, fzHi := labF(xyzHi[0]), labF(xyzHi[1]), labF(xyzHi[2])
+	minimum = [3]float64{116*fyLo - 16, 500 * (fxLo - fyHi), 200 * (fyLo - fzHi)}
+	maximum = [3]float64{116*fyHi - 16, 500 * (fxHi - fyLo), 200 * (fyHi - fzLo)}
+	return minimum, maximum
+}
@@ -0,0 +1,102 @@
+package zbm
+
+import (
+	"image"
+	"image/color"
+	"io"
+)
+
+/*
+Planes show components of pixels the way they are stored, before they are converted to RGB.
+Components narrower than 8 bits are shifted to the high bits, like the console does it, so 3-bit
+Cr and Cb have 8 levels from 0 to 0xE0, and 6-bit Y has 64 levels from 0 to 0xFC. 4-bit alpha
+is scaled to the full range. Formats without alpha have opaque alpha plane.
+*/
+
+// components are Y, Cb, Cr and alpha of a pixel, scaled to 8 bits
+type components struct {
+	y, cb, cr, a uint8
+}
+
+// components3364 returns components of a 16-bit CrCbYA word
+func components3364(value uint16) components {
+	cr, cb, y, a := getPixelValue(value)
+	return components{y, cb, cr, a}
+}
+
+// color returns the RGB color of components
+func (c components) color() color.NRGBA {
+	return convertYCbCr(c.y, c.cb, c.cr, c.a)
+}
+
+// Planes holds components of pixels of a texture level, each in its own grayscale image
+type Planes struct {
+	Y, Cb, Cr, A *image.Gray
+}
+
+// NewPlanes returns planes of an image with the given bounds
+func NewPlanes(r image.Rectangle) *Planes {
+	return &Planes{
+		Y:  image.NewGray(r),
+		Cb: image.NewGray(r),
+		Cr: image.NewGray(r),
+		A:  image.NewGray(r),
+	}
+}
+
+// PlaneNames returns names of planes, in the order of Planes.Images
+func PlaneNames() []string {
+	return []string{"Y", "Cb", "Cr", "A"}
+}
+
+// Images returns images of planes Y, Cb, Cr and A
+func (p *Planes) Images() []*image.Gray {
+	return []*image.Gray{p.Y, p.Cb, p.Cr, p.A}
+}
+
+// DecodePlanes reads components of pixels of the first level of a texture from r
+func DecodePlanes(r io.Reader) (*Planes, error) {
+	levels, err := decodePlanes(r, 1)
+	if err != nil {
+		return nil, err
+	}
+	return levels[0], nil
+}
+
+// DecodeTexturePlanes reads components of pixels of all levels of a texture from r
+func DecodeTexturePlanes(r io.Reader) ([]*Planes, error) {
+	return decodePlanes(r, MaxLevels)
+}
+
+// decodePlanes reads planes of up to count levels of a texture
+func decodePlanes(r io.Reader, count int) ([]*Planes, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	var levels []*Planes
+	for {
+		_, width, height := d.Level()
+		p := NewPlanes(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			row, err := d.nextPlanesRow()
+			if err != nil {
+				return nil, err
+			}
+			for x, c := range row {
+				i := p.Y.PixOffset(x, y)
+				p.Y.Pix[i], p.Cb.Pix[i], p.Cr.Pix[i], p.A.Pix[i] = c.y, c.cb, c.cr, c.a
+			}
+		}
+		levels = append(levels, p)
+		if len(levels) == count {
+			return levels, nil
+		}
+
+		if err = d.NextLevel(); err == io.EOF {
+			return levels, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
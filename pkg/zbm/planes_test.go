@@ -0,0 +1,49 @@
+package zbm
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodePlanes(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name   string
+		data   []byte
+		planes [][]uint8
+	}{
+		// 0x1234 is Cr 4, Cb 6, Y 8 and alpha 1
+		{"3364", texture(t, FormatCrCbYA3364, 2, 1, 1, 0x1234), [][]uint8{{0x20}, {0xC0}, {0x80}, {0x11}}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			p, err := DecodePlanes(bytes.NewReader(tt.data))
+			require.NoError(t, err)
+			for i, m := range p.Images() {
+				require.Equal(t, tt.planes[i], m.Pix, "plane %s", PlaneNames()[i])
+			}
+		})
+	}
+}
+
+func TestDecodeTexturePlanes(t *testing.T) {
+	t.Parallel()
+	levels := []image.Image{NewCrCbYA(image.Rect(0, 0, 3, 2)), NewCrCbYA(image.Rect(0, 0, 1, 1))}
+	levels[0].(*CrCbYA).Pix[5] = 0xFFC0
+	levels[1].(*CrCbYA).Pix[0] = 0x8007
+	buf := bytes.Buffer{}
+	require.NoError(t, EncodeTexture(&buf, levels, nil))
+
+	planes, err := DecodeTexturePlanes(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, planes, 2)
+	require.Equal(t, image.Rect(0, 0, 3, 2), planes[0].Y.Bounds())
+	require.Equal(t, []uint8{0, 0, 0, 0, 0, 0xFC}, planes[0].Y.Pix)
+	require.Equal(t, []uint8{0, 0, 0, 0, 0, 0xFF}, planes[0].A.Pix)
+	require.Equal(t, []uint8{0xE0}, planes[1].Cr.Pix)
+	require.Equal(t, []uint8{0x88}, planes[1].A.Pix)
+}
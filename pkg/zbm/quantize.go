@@ -0,0 +1,304 @@
+package zbm
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/namgo/GameWaveFans/pkg/common"
+)
+
+// Quantizer selects how colors are converted to CrCbYA 3364 words
+type Quantizer int
+
+// Available quantizers
+const (
+	// Truncate drops lower bits of Y, Cb and Cr, like the original encoder
+	Truncate Quantizer = iota
+	// Nearest picks the word with decoded color closest to the source color in RGB
+	Nearest
+	// FloydSteinberg picks nearest colors, and diffuses their error to neighbouring pixels
+	FloydSteinberg
+	// Bayer adds 4x4 ordered dither pattern to Y, Cb, Cr and alpha before rounding them
+	Bayer
+	// Perceptual picks the word with decoded color closest to the source color in CIELAB
+	Perceptual
+)
+
+var quantizerNames = []string{"truncate", "nearest", "floyd-steinberg", "bayer", "perceptual"}
+
+func (q Quantizer) String() string {
+	if q >= 0 && int(q) < len(quantizerNames) {
+		return quantizerNames[q]
+	}
+	return fmt.Sprintf("Quantizer(%d)", int(q))
+}
+
+// QuantizerNames returns names of all quantizers accepted by ParseQuantizer
+func QuantizerNames() []string {
+	return append([]string(nil), quantizerNames...)
+}
+
+// ParseQuantizer returns the quantizer with the given name
+func ParseQuantizer(name string) (Quantizer, error) {
+	for q, n := range quantizerNames {
+		if strings.EqualFold(name, n) {
+			return Quantizer(q), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown quantizer %q, expected one of: %s", name, strings.Join(quantizerNames, ", "))
+}
+
+// Options are the encoding parameters, nil Options use Truncate quantizer, AlphaFull mode
+// and the default compression. Alpha options are used by decoding too
+type Options struct {
+	Quantizer Quantizer
+	// Alpha selects how alpha is stored
+	Alpha AlphaMode
+	// AlphaThreshold is the lowest alpha of opaque pixels in AlphaKey mode, 0 means DefaultAlphaThreshold
+	AlphaThreshold uint8
+	// Compression of pixel data, nil means common.DefaultCompression
+	Compression *common.Compression
+}
+
+// rgba is a non-premultiplied color with components in range 0-255
+type rgba [4]float64
+
+func toRGBA(c color.Color) rgba {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return rgba{float64(n.R), float64(n.G), float64(n.B), float64(n.A)}
+}
+
+// decodedColors holds RGB colors of the lower 12 bits of CrCbYA 3364 words
+var decodedColors = func() (colors [4096][3]float64) {
+	for i := range colors {
+		c := convertCrCbYA3364(uint16(i))
+		colors[i] = [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+	}
+	return colors
+}()
+
+// colorSpace finds words with decoded colors nearest to source colors, measuring distance
+// in a space colors are converted to
+type colorSpace struct {
+	// number of cells of RGB space in each dimension, a power of 2
+	grid int
+	// convert converts RGB color to the space
+	convert func(c [3]float64) [3]float64
+	// bounds returns bounds in the space of all RGB colors between lo and hi
+	bounds func(lo, hi [3]float64) (minimum, maximum [3]float64)
+
+	once sync.Once
+	// decoded colors of words, in the space
+	colors [4096][3]float64
+	// words which may be the nearest to colors of each cell of RGB space
+	candidates [][]uint16
+}
+
+// rgbSpace measures distance between RGB colors
+var rgbSpace = &colorSpace{
+	grid:    8,
+	convert: func(c [3]float64) [3]float64 { return c },
+	bounds:  func(lo, hi [3]float64) (minimum, maximum [3]float64) { return lo, hi },
+}
+
+// build lists for every cell the words which may be the nearest to a color inside of it:
+// words closer to the cell than the farthest point of the word which is the closest
+// to all points of the cell. Cells are split in halves until there are grid of them
+// in each dimension, candidates of a cell are searched only among candidates of its parent
+func (s *colorSpace) build() {
+	// words with the same decoded color as a word with lower index are never picked
+	var unique []uint16
+	seen := map[[3]float64]bool{}
+	for i, d := range decodedColors {
+		s.colors[i] = s.convert(d)
+		if !seen[d] {
+			seen[d] = true
+			unique = append(unique, uint16(i))
+		}
+	}
+
+	cells := [][]uint16{unique}
+	for grid := 2; grid <= s.grid; grid *= 2 {
+		step := 256.0 / float64(grid)
+		next := make([][]uint16, grid*grid*grid)
+		for cell := range next {
+			r, g, b := cell/grid/grid, cell/grid%grid, cell%grid
+			parent := cells[(r/2*grid/2+g/2)*grid/2+b/2]
+			lo := [3]float64{float64(r) * step, float64(g) * step, float64(b) * step}
+			next[cell] = s.filter(parent, lo, [3]float64{lo[0] + step, lo[1] + step, lo[2] + step})
+		}
+		cells = next
+	}
+	s.candidates = cells
+}
+
+// filter returns words which may be the nearest to RGB colors between lo and hi
+func (s *colorSpace) filter(words []uint16, lo, hi [3]float64) []uint16 {
+	minimum, maximum := s.bounds(lo, hi)
+	nearest := make([]float64, len(words))
+	threshold := math.Inf(1)
+	for k, word := range words {
+		minDistance, maxDistance := 0.0, 0.0
+		for ch, v := range s.colors[word] {
+			near, far := 0.0, v-minimum[ch]
+			if v < minimum[ch] {
+				near = minimum[ch] - v
+				far = maximum[ch] - v
+			} else if v > maximum[ch] {
+				near = v - maximum[ch]
+			} else if maximum[ch]-v > far {
+				far = maximum[ch] - v
+			}
+			minDistance += near * near
+			maxDistance += far * far
+		}
+		nearest[k] = minDistance
+		threshold = min(threshold, maxDistance)
+	}
+
+	var result []uint16
+	for k, word := range words {
+		if nearest[k] <= threshold {
+			result = append(result, word)
+		}
+	}
+	return result
+}
+
+// nearest returns the CrCbYA 3364 word with decoded color closest to c in the space,
+// components of c have to be in range 0-255
+func (s *colorSpace) nearest(c rgba) uint16 {
+	s.once.Do(s.build)
+	cell := 0
+	for _, v := range c[:3] {
+		cell = cell*s.grid + min(int(v)*s.grid/256, s.grid-1)
+	}
+
+	target := s.convert([3]float64{c[0], c[1], c[2]})
+	best, bestDistance := uint16(0), math.Inf(1)
+	for _, word := range s.candidates[cell] {
+		d := &s.colors[word]
+		distance := (d[0]-target[0])*(d[0]-target[0]) + (d[1]-target[1])*(d[1]-target[1]) + (d[2]-target[2])*(d[2]-target[2])
+		if distance < bestDistance {
+			best, bestDistance = word, distance
+		}
+	}
+	return quantizeAlpha(c[3])<<12 | best
+}
+
+// nearestWord returns the CrCbYA 3364 word with decoded color closest to c in RGB
+func nearestWord(c rgba) uint16 {
+	return rgbSpace.nearest(c)
+}
+
+func quantizeAlpha(a float64) uint16 {
+	return uint16(math.Max(math.Min(math.Round(a/17), 15), 0))
+}
+
+// wordColor returns decoded color of CrCbYA 3364 word
+func wordColor(word uint16) rgba {
+	d := decodedColors[word&0xFFF]
+	return rgba{d[0], d[1], d[2], float64(word>>12) * 17}
+}
+
+// bayer is the 4x4 ordered dither matrix
+var bayer = [4][4]float64{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// ditherWord returns CrCbYA 3364 word of c, with threshold t between -0.5 and 0.5 added
+// to all components before rounding them
+func ditherWord(c rgba, t float64) uint16 {
+	// inverse of the conversion done by the console
+	luma := 0.299*c[0] + 0.587*c[1] + 0.114*c[2]
+	cb := (c[2]-luma)*64/113 + 128
+	cr := (c[0]-luma)*32/45 + 128
+	component := func(value, step, maxValue float64) uint16 {
+		return uint16(math.Max(math.Min(math.Round(value/step+t), maxValue), 0))
+	}
+	return component(c[3], 17, 15)<<12 | component(luma, 4, 63)<<6 | component(cb, 32, 7)<<3 | component(cr, 32, 7)
+}
+
+// rowQuantizer converts pixels of an image to CrCbYA 3364 words row by row,
+// keeping errors diffused to the next row
+type rowQuantizer struct {
+	m   image.Image
+	o   *Options
+	y   int
+	row []uint16
+	// errors of the current and the next row, with a pixel of margin on both sides
+	current, next []rgba
+}
+
+func newRowQuantizer(m image.Image, o *Options) *rowQuantizer {
+	width := m.Bounds().Dx()
+	rq := &rowQuantizer{m: m, o: o, row: make([]uint16, width)}
+	if o.Quantizer == FloydSteinberg {
+		rq.current = make([]rgba, width+2)
+		rq.next = make([]rgba, width+2)
+	}
+	return rq
+}
+
+// nextRow returns words of the next row, the slice is reused by following calls
+func (rq *rowQuantizer) nextRow() []uint16 {
+	b := rq.m.Bounds()
+	y := rq.y
+	rq.y++
+	at := func(x int) rgba {
+		return rq.o.encodeAlpha(toRGBA(rq.m.At(b.Min.X+x, b.Min.Y+y)))
+	}
+
+	switch rq.o.Quantizer {
+	case Nearest:
+		for x := range rq.row {
+			rq.row[x] = nearestWord(at(x))
+		}
+	case Perceptual:
+		for x := range rq.row {
+			rq.row[x] = labSpace.nearest(at(x))
+		}
+	case FloydSteinberg:
+		current, next := rq.current, rq.next
+		for x := range rq.row {
+			c := at(x)
+			for k := range c {
+				c[k] = math.Max(math.Min(c[k]+current[x+1][k], 255), 0)
+			}
+			word := nearestWord(c)
+			rq.row[x] = word
+			decoded := wordColor(word)
+			for k := range c {
+				e := c[k] - decoded[k]
+				current[x+2][k] += e * 7 / 16
+				next[x][k] += e * 3 / 16
+				next[x+1][k] += e * 5 / 16
+				next[x+2][k] += e * 1 / 16
+			}
+		}
+		clear(current)
+		rq.current, rq.next = next, current
+	case Bayer:
+		for x := range rq.row {
+			rq.row[x] = ditherWord(at(x), (bayer[y%4][x%4]+0.5)/16-0.5)
+		}
+	default:
+		for x := range rq.row {
+			// the original encoder premultiplies colors, only the other alpha modes change them
+			c := rq.m.At(b.Min.X+x, b.Min.Y+y)
+			if rq.o.Alpha == AlphaNone || rq.o.Alpha == AlphaKey {
+				n := at(x)
+				c = color.NRGBA{uint8(n[0]), uint8(n[1]), uint8(n[2]), uint8(n[3])}
+			}
+			rq.row[x] = convertColorToCrCbYA(c)
+		}
+	}
+	return rq.row
+}
@@ -0,0 +1,119 @@
+package zbm
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuantizer(t *testing.T) {
+	t.Parallel()
+	for _, name := range QuantizerNames() {
+		q, err := ParseQuantizer(name)
+		require.NoError(t, err)
+		require.Equal(t, name, q.String())
+	}
+	q, err := ParseQuantizer("Floyd-Steinberg")
+	require.NoError(t, err)
+	require.Equal(t, FloydSteinberg, q)
+	_, err = ParseQuantizer("median")
+	require.EqualError(t, err, `unknown quantizer "median", expected one of: truncate, nearest, floyd-steinberg, bayer, perceptual`)
+}
+
+func TestQuantize(t *testing.T) {
+	t.Parallel()
+	// gray between two levels of Y, which can be shown only with dithering
+	single := image.NewRGBA(image.Rect(3, 3, 4, 4))
+	single.Set(3, 3, color.NRGBA{0x82, 0x82, 0x82, 0xFF})
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = 0x82
+		if i%4 == 3 {
+			img.Pix[i] = 0xFF
+		}
+	}
+
+	cases := []struct {
+		quantizer Quantizer
+		// expected average gray of the decoded image
+		gray float64
+		// expected number of different words
+		words int
+	}{
+		{Truncate, 0x80, 1},
+		{Nearest, 0x80, 1},
+		{FloydSteinberg, 0x82, 2},
+		{Bayer, 0x82, 2},
+		{Perceptual, 0x84, 1},
+	}
+	for _, tt := range cases {
+		q := tt.quantizer
+		gray := tt.gray
+		words := tt.words
+		t.Run(q.String(), func(t *testing.T) {
+			t.Parallel()
+			pixels := convertImage(img, &Options{Quantizer: q})
+			counts := map[uint16]int{}
+			sum := 0.0
+			for _, p := range pixels {
+				counts[p]++
+				c := wordColor(p)
+				require.Equal(t, c[0], c[1])
+				require.Equal(t, c[0], c[2])
+				require.Equal(t, 255.0, c[3])
+				sum += c[0]
+			}
+			require.Len(t, counts, words)
+			require.InDelta(t, gray, sum/float64(len(pixels)), 0.25)
+
+			// the source image doesn't have to be NRGBA
+			require.Equal(t, pixels[:1], convertImage(single, &Options{Quantizer: q}))
+		})
+	}
+}
+
+func TestNearestWord(t *testing.T) {
+	t.Parallel()
+	// every decoded color is encoded back to the same word
+	for i := 0; i < 4096; i++ {
+		word := uint16(0xF000 | i)
+		c := wordColor(word)
+		require.Equal(t, c, wordColor(nearestWord(c)), "word %04x", word)
+	}
+}
+
+func TestColorSpaces(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name  string
+		space *colorSpace
+	}{
+		{"rgb", rgbSpace},
+		{"lab", labSpace},
+	}
+	for _, tt := range cases {
+		space := tt.space
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			distance := func(a, b [3]float64) float64 {
+				return (a[0]-b[0])*(a[0]-b[0]) + (a[1]-b[1])*(a[1]-b[1]) + (a[2]-b[2])*(a[2]-b[2])
+			}
+			// the word found with candidates is as close as the one found by checking all words
+			r := rand.New(rand.NewSource(1))
+			for n := 0; n < 1000; n++ {
+				c := rgba{r.Float64() * 255, r.Float64() * 255, r.Float64() * 255, 255}
+				target := space.convert([3]float64{c[0], c[1], c[2]})
+				best := distance(space.convert(decodedColors[0]), target)
+				for _, d := range decodedColors {
+					best = min(best, distance(space.convert(d), target))
+				}
+				word := space.nearest(c)
+				require.Equal(t, uint16(0xF000), word&0xF000)
+				require.Equal(t, best, distance(space.colors[word&0xFFF], target), "color %v", c)
+			}
+		})
+	}
+}
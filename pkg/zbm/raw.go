@@ -0,0 +1,134 @@
+package zbm
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+/*
+Raw functions work on CrCbYA 3364 words the way they are stored in textures, without converting
+them to RGB and back, which loses precision. Textures can be repacked keeping their original header
+and pixels, with only the edited pixels converted again.
+*/
+
+// DecodeRaw reads zbm file in CrCbYA 3364 format, and returns its header and pixels of the first
+// level, row by row, as stored in the file. Other levels are skipped, DecodeRawLevels returns them
+func DecodeRaw(r io.Reader) (Header, []uint16, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	h := d.Header()
+	pixels := make([]uint16, 0, h.Width*h.Height)
+	for y := 0; y < int(h.Height); y++ {
+		row, err := d.NextRawRow()
+		if err != nil {
+			return h, nil, err
+		}
+		pixels = append(pixels, row...)
+	}
+	return h, pixels, nil
+}
+
+// DecodeRawLevels reads zbm file in CrCbYA 3364 format, and returns its header
+// and words of pixels of every level, as returned by Decoder.NextRawRow
+func DecodeRawLevels(r io.Reader) (Header, [][]uint16, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	h := d.Header()
+	var levels [][]uint16
+	for {
+		_, width, height := d.Level()
+		pixels := make([]uint16, 0, width*height)
+		for y := 0; y < height; y++ {
+			row, err := d.NextRawRow()
+			if err != nil {
+				return h, nil, err
+			}
+			pixels = append(pixels, row...)
+		}
+		levels = append(levels, pixels)
+
+		if err = d.NextLevel(); err == io.EOF {
+			return h, levels, nil
+		} else if err != nil {
+			return h, nil, err
+		}
+	}
+}
+
+// EncodeRaw writes zbm file with header h and CrCbYA 3364 pixels. All fields of h are kept,
+// except for sizes of data, which are updated. h has to have a single level, textures with more
+// levels are written by EncodeRawLevels
+func EncodeRaw(w io.Writer, h Header, pixels []uint16) error {
+	return EncodeRawOptions(w, h, pixels, nil)
+}
+
+// EncodeRawOptions writes zbm file like EncodeRaw, with pixel data packed using compression of o,
+// or the default one if o is nil
+func EncodeRawOptions(w io.Writer, h Header, pixels []uint16, o *Options) error {
+	return EncodeRawLevels(w, h, [][]uint16{pixels}, o)
+}
+
+// EncodeRawLevels writes zbm file with header h and CrCbYA 3364 pixels of every level, as returned
+// by DecodeRawLevels, with pixel data packed like by EncodeRawOptions
+func EncodeRawLevels(w io.Writer, h Header, levels [][]uint16, o *Options) error {
+	e, err := NewEncoder(w, h)
+	if err != nil {
+		return err
+	}
+	if o != nil {
+		e.SetOptions(o)
+	}
+	if len(levels) != h.LevelCount() {
+		return FormatError(fmt.Sprintf("got %d levels for texture with %d levels", len(levels), h.LevelCount()))
+	}
+	for level, pixels := range levels {
+		width, height := h.LevelSize(level)
+		if len(pixels) != width*height && level == 0 {
+			return FormatError(fmt.Sprintf("got %d pixels for %dx%d texture", len(pixels), width, height))
+		} else if len(pixels) != width*height {
+			return FormatError(fmt.Sprintf("got %d pixels for %dx%d level %d", len(pixels), width, height, level))
+		}
+		for y := 0; y < height; y++ {
+			if err = e.WriteRawRow(pixels[y*width : (y+1)*width]); err != nil {
+				return err
+			}
+		}
+	}
+	return e.Close()
+}
+
+// UpdatePixels converts pixels of m which differ from colors of CrCbYA 3364 pixels
+// of a texture with given width, pixels with unchanged colors, and transparent pixels
+// which stayed transparent are kept. Changed pixels are converted with options o,
+// like by EncodeOptions. It returns the number of changed pixels
+func UpdatePixels(pixels []uint16, width int, m image.Image, o *Options) (int, error) {
+	b := m.Bounds()
+	if b.Dx() != width || b.Dx()*b.Dy() != len(pixels) {
+		return 0, FormatError(fmt.Sprintf("image size %dx%d doesn't match the texture", b.Dx(), b.Dy()))
+	}
+
+	var converted []uint16
+	changed := 0
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			i := y*width + x
+			c := color.NRGBAModel.Convert(m.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			original := convertCrCbYA3364(pixels[i])
+			// colors of fully transparent pixels are often lost by image editors
+			if c != original && (c.A != 0 || original.A != 0) {
+				if converted == nil {
+					converted = convertImage(m, o)
+				}
+				pixels[i] = converted[i]
+				changed++
+			}
+		}
+	}
+	return changed, nil
+}
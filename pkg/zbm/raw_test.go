@@ -0,0 +1,115 @@
+package zbm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/namgo/GameWaveFans/pkg/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRawRoundTrip(t *testing.T) {
+	t.Parallel()
+	data := texture(t, FormatCrCbYA3364, 2, 4, 1, 0x1234ABCD, 0xFFE48824)
+	h, pixels, err := DecodeRaw(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, []uint16{0xABCD, 0x1234, 0x8824, 0xFFE4}, pixels)
+
+	h.Unknown8 = 7
+	buf := bytes.Buffer{}
+	require.NoError(t, EncodeRaw(&buf, h, pixels))
+	h2, pixels2, err := DecodeRaw(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, h, h2)
+	require.Equal(t, pixels, pixels2)
+
+	unpacked, err := common.ReadZlibFromBuffer(buf.Bytes()[HeaderSize:])
+	require.NoError(t, err)
+	original, err := common.ReadZlibFromBuffer(data[HeaderSize:])
+	require.NoError(t, err)
+	require.Equal(t, original, unpacked)
+
+	require.EqualError(t, EncodeRaw(&buf, h, pixels[:3]), "gamewave zbm error:got 3 pixels for 4x1 texture")
+}
+
+func TestDecodeRawLevels(t *testing.T) {
+	t.Parallel()
+	// every two words are swapped in the file
+	h, levels, err := DecodeRawLevels(bytes.NewReader(texture(t, FormatCrCbYA3364, 2, 2, 1, 0x44332211)))
+	require.NoError(t, err)
+	require.Equal(t, FormatCrCbYA3364, h.FormatID)
+	require.Equal(t, [][]uint16{{0x2211, 0x4433}}, levels)
+
+	m := NewCrCbYA(image.Rect(0, 0, 3, 1))
+	copy(m.Pix, []uint16{1, 2, 3})
+	small := NewCrCbYA(image.Rect(0, 0, 1, 1))
+	small.Pix[0] = 4
+	buf := bytes.Buffer{}
+	require.NoError(t, EncodeTexture(&buf, []image.Image{m, small}, nil))
+	_, levels, err = DecodeRawLevels(&buf)
+	require.NoError(t, err)
+	require.Equal(t, [][]uint16{{1, 2, 3}, {4}}, levels)
+
+	_, _, err = DecodeRawLevels(bytes.NewReader(texture(t, 5, 4, 1, 1, 0)))
+	require.EqualError(t, err, "gamewave zbm error:unsupported pixel format 5")
+}
+
+func TestEncodeRawLevels(t *testing.T) {
+	t.Parallel()
+	m := NewCrCbYA(image.Rect(0, 0, 3, 1))
+	copy(m.Pix, []uint16{1, 2, 3})
+	small := NewCrCbYA(image.Rect(0, 0, 1, 1))
+	small.Pix[0] = 4
+	data := bytes.Buffer{}
+	require.NoError(t, EncodeTexture(&data, []image.Image{m, small}, nil))
+
+	h, pixels, err := DecodeRaw(bytes.NewReader(data.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, []uint16{1, 2, 3}, pixels)
+	h, levels, err := DecodeRawLevels(bytes.NewReader(data.Bytes()))
+	require.NoError(t, err)
+	levels[0][1] = 5
+
+	buf := bytes.Buffer{}
+	require.NoError(t, EncodeRawLevels(&buf, h, levels, nil))
+	_, levels, err = DecodeRawLevels(&buf)
+	require.NoError(t, err)
+	require.Equal(t, [][]uint16{{1, 5, 3}, {4}}, levels)
+
+	require.EqualError(t, EncodeRaw(&buf, h, pixels), "gamewave zbm error:got 1 levels for texture with 2 levels")
+	require.EqualError(t, EncodeRawLevels(&buf, h, [][]uint16{pixels, {}}, nil), "gamewave zbm error:got 0 pixels for 1x1 level 1")
+}
+
+func TestUpdatePixels(t *testing.T) {
+	t.Parallel()
+	pixels := []uint16{0xABCD, 0x1234, 0x8824, 0x0FE4}
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for i, p := range pixels {
+		img.Set(i%2, i/2, convertCrCbYA3364(p))
+	}
+	// decoding the image again would change the first two pixels
+	require.NotEqual(t, pixels[:2], convertImage(img, nil)[:2])
+
+	white := color.NRGBA{0xFC, 0xFC, 0xFC, 0xFF}
+	img.Set(0, 1, white)
+	// transparent pixel with color lost
+	img.Set(1, 1, color.Transparent)
+	changed, err := UpdatePixels(pixels, 2, img, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, changed)
+	require.Equal(t, []uint16{0xABCD, 0x1234, convertColorToCrCbYA(white), 0x0FE4}, pixels)
+
+	// images not starting at 0, 0 are handled too
+	img.Set(0, 1, convertCrCbYA3364(pixels[2]))
+	moved := image.NewNRGBA(image.Rect(5, 5, 7, 7))
+	draw.Draw(moved, moved.Bounds(), img, image.Point{}, draw.Src)
+	changed, err = UpdatePixels(pixels, 2, moved, nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, changed)
+
+	_, err = UpdatePixels(pixels, 4, img, nil)
+	require.EqualError(t, err, "gamewave zbm error:image size 2x2 doesn't match the texture")
+}
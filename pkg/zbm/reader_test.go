@@ -0,0 +1,189 @@
+package zbm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/namgo/GameWaveFans/pkg/common"
+	"github.com/stretchr/testify/require"
+)
+
+// texture returns a zbm file with given header values and words of pixel data
+func texture(t *testing.T, format Format, bpp uint32, width, height int, words ...uint32) []byte {
+	t.Helper()
+	data := make([]byte, 4*len(words))
+	for i, word := range words {
+		binary.BigEndian.PutUint32(data[4*i:], word)
+	}
+	return textureData(t, format, bpp, width, height, data)
+}
+
+// textureData returns a zbm file with given header values and pixel data
+func textureData(t *testing.T, format Format, bpp uint32, width, height int, data []byte) []byte {
+	t.Helper()
+	packed, err := common.WriteZlibToBuffer(data)
+	require.NoError(t, err)
+
+	h := NewHeader(width, height)
+	h.FormatID = format
+	h.BytesPerPixel = bpp
+	h.SizePacked = uint32(len(packed))
+	h.SizeUnpacked = uint32(len(data))
+	buf := bytes.Buffer{}
+	require.NoError(t, WriteHeader(&buf, h))
+	buf.Write(packed)
+	return buf.Bytes()
+}
+
+func TestDecodeFormats(t *testing.T) {
+	t.Parallel()
+	white := color.NRGBA{0xFC, 0xFC, 0xFC, 0xFF}
+	// zero word decodes to Cr=0, Cb=0, Y=0
+	zero := color.NRGBA{0, 0x88, 0, 0}
+	// Y=0x80, Cb=0x80, Cr=0x80 in 3364, with alpha 8
+	grayWord := uint32(0x8824)
+	cases := []struct {
+		name     string
+		data     []byte
+		expected []color.NRGBA
+	}{
+		{
+			name:     "3364",
+			data:     texture(t, FormatCrCbYA3364, 2, 3, 1, 0xFFE4<<16|grayWord, 0),
+			expected: []color.NRGBA{{0x80, 0x80, 0x80, 0x88}, white, zero},
+		},
+	}
+	for _, tt := range cases {
+		data := tt.data
+		expected := tt.expected
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			img, err := Decode(bytes.NewReader(data))
+			require.NoError(t, err)
+			require.Equal(t, image.Rect(0, 0, len(expected), 1), img.Bounds())
+			for x, c := range expected {
+				require.Equal(t, c, img.At(x, 0), "pixel %d", x)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{
+			name:     "unknown format",
+			data:     texture(t, 9, 2, 1, 1, 0),
+			expected: "gamewave zbm error:unsupported pixel format 9",
+		},
+		{
+			// ids of formats other than 3364 aren't known, even if there are free ones below it
+			name:     "undocumented format",
+			data:     texture(t, 2, 1, 1, 1, 0),
+			expected: "gamewave zbm error:unsupported pixel format 2",
+		},
+		{
+			name:     "wrong bpp",
+			data:     texture(t, FormatCrCbYA3364, 4, 1, 1, 0),
+			expected: "gamewave zbm error:4 bytes per pixel don't match CrCbYA3364 format",
+		},
+		{
+			name:     "short data",
+			data:     texture(t, FormatCrCbYA3364, 2, 3, 1, 0),
+			expected: "gamewave zbm error:CrCbYA3364 pixel data too short: got 4 bytes, expected 6",
+		},
+	}
+	for _, tt := range cases {
+		data := tt.data
+		expected := tt.expected
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := Decode(bytes.NewReader(data))
+			require.EqualError(t, err, expected)
+		})
+	}
+}
+
+func TestSizes(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		width, height int
+	}{
+		{1, 1}, {1, 2}, {2, 1}, {3, 1}, {1, 3}, {3, 3}, {5, 7}, {8, 8}, {17, 3}, {639, 1}, {33, 31},
+	}
+	for _, tt := range cases {
+		width, height := tt.width, tt.height
+		t.Run(fmt.Sprintf("%dx%d", width, height), func(t *testing.T) {
+			t.Parallel()
+			img := NewCrCbYA(image.Rect(0, 0, width, height))
+			for i := range img.Pix {
+				img.Pix[i] = uint16(i*0x1357 + 1)
+			}
+
+			buf := bytes.Buffer{}
+			require.NoError(t, Encode(&buf, img))
+			h, pixels, err := DecodeRaw(bytes.NewReader(buf.Bytes()))
+			require.NoError(t, err)
+			// pixels are stored in whole words
+			require.Equal(t, uint32((width*height+1)/2*4), h.SizeUnpacked)
+			require.Equal(t, img.Pix, pixels)
+
+			decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+			require.NoError(t, err)
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					require.Equal(t, convertCrCbYA3364(img.Pix[y*width+x]), decoded.At(x, y), "pixel %d, %d", x, y)
+				}
+			}
+		})
+	}
+}
+
+func TestOddPixelCount(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name     string
+		data     []byte
+		width    int
+		expected []uint16
+	}{
+		{
+			name:     "1x1",
+			data:     []byte{0x00, 0x00, 0xAB, 0xCD},
+			width:    1,
+			expected: []uint16{0xABCD},
+		},
+		{
+			name:     "3x1",
+			data:     []byte{0x12, 0x34, 0x56, 0x78, 0x00, 0x00, 0x9A, 0xBC},
+			width:    3,
+			expected: []uint16{0x5678, 0x1234, 0x9ABC},
+		},
+		{
+			// written by older versions, without the last pixel
+			name:     "3x1 incomplete",
+			data:     []byte{0x12, 0x34, 0x56, 0x78, 0x00, 0x00},
+			width:    3,
+			expected: []uint16{0x5678, 0x1234, 0},
+		},
+	}
+	for _, tt := range cases {
+		data := tt.data
+		width := tt.width
+		expected := tt.expected
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, pixels, err := DecodeRaw(bytes.NewReader(textureData(t, FormatCrCbYA3364, 2, width, 1, data)))
+			require.NoError(t, err)
+			require.Equal(t, expected, pixels)
+		})
+	}
+}
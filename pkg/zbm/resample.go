@@ -0,0 +1,164 @@
+package zbm
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strings"
+)
+
+// Filter selects how images are resampled to a different size
+type Filter int
+
+// Available filters
+const (
+	// FilterNearest picks the source pixel nearest to the center of each pixel
+	FilterNearest Filter = iota
+	// FilterBilinear interpolates linearly between neighbouring pixels, averaging them when downscaling
+	FilterBilinear
+	// FilterLanczos uses Lanczos kernel with 3 lobes, which keeps images sharp
+	FilterLanczos
+)
+
+var filterNames = []string{"nearest", "bilinear", "lanczos"}
+
+func (f Filter) String() string {
+	if f >= 0 && int(f) < len(filterNames) {
+		return filterNames[f]
+	}
+	return fmt.Sprintf("Filter(%d)", int(f))
+}
+
+// FilterNames returns names of all filters accepted by ParseFilter
+func FilterNames() []string {
+	return append([]string(nil), filterNames...)
+}
+
+// ParseFilter returns the filter with the given name
+func ParseFilter(name string) (Filter, error) {
+	for f, n := range filterNames {
+		if strings.EqualFold(name, n) {
+			return Filter(f), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown filter %q, expected one of: %s", name, strings.Join(filterNames, ", "))
+}
+
+// kernel returns the filter function, and its radius in source pixels when upscaling
+func (f Filter) kernel() (func(x float64) float64, float64) {
+	if f == FilterLanczos {
+		sinc := func(x float64) float64 {
+			if x == 0 {
+				return 1
+			}
+			return math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		return func(x float64) float64 {
+			if math.Abs(x) >= 3 {
+				return 0
+			}
+			return sinc(x) * sinc(x/3)
+		}, 3
+	}
+	return func(x float64) float64 { return math.Max(1-math.Abs(x), 0) }, 1
+}
+
+// weight is a contribution of a source pixel to a resampled one
+type weight struct {
+	index int
+	value float64
+}
+
+// weights returns contributions of source pixels to each of size pixels resampled from
+// source pixels. Pixels past the edges are replaced by the edge ones
+func (f Filter) weights(source, size int) [][]weight {
+	scale := float64(source) / float64(size)
+	result := make([][]weight, size)
+	if f == FilterNearest {
+		for i := range result {
+			result[i] = []weight{{min(int((float64(i)+0.5)*scale), source-1), 1}}
+		}
+		return result
+	}
+
+	kernel, radius := f.kernel()
+	// kernel is stretched when downscaling, so it averages all covered pixels
+	stretch := math.Max(scale, 1)
+	radius *= stretch
+	for i := range result {
+		center := (float64(i)+0.5)*scale - 0.5
+		sum := 0.0
+		for j := int(math.Ceil(center - radius)); j <= int(math.Floor(center+radius)); j++ {
+			value := kernel((float64(j) - center) / stretch)
+			if value == 0 {
+				continue
+			}
+			result[i] = append(result[i], weight{min(max(j, 0), source-1), value})
+			sum += value
+		}
+		for k := range result[i] {
+			result[i][k].value /= sum
+		}
+	}
+	return result
+}
+
+// Resample returns m scaled to the given size with filter f. Colors are mixed premultiplied by alpha,
+// so colors of transparent pixels don't bleed into visible ones
+func Resample(m image.Image, width, height int, f Filter) *image.NRGBA {
+	b := m.Bounds()
+	source := make([]rgba, b.Dx()*b.Dy())
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			r, g, bl, a := m.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			source[y*b.Dx()+x] = rgba{float64(r) / 257, float64(g) / 257, float64(bl) / 257, float64(a) / 257}
+		}
+	}
+
+	// rows are resampled first, then columns of the result
+	resample := func(pixels []rgba, weights [][]weight, count, stride, step int) []rgba {
+		result := make([]rgba, len(weights)*count)
+		for line := 0; line < count; line++ {
+			for i, contributions := range weights {
+				var c rgba
+				for _, w := range contributions {
+					p := pixels[line*stride+w.index*step]
+					for k := range c {
+						c[k] += p[k] * w.value
+					}
+				}
+				result[line*len(weights)+i] = c
+			}
+		}
+		return result
+	}
+	rows := resample(source, f.weights(b.Dx(), width), b.Dy(), b.Dx(), 1)
+	columns := resample(rows, f.weights(b.Dy(), height), width, 1, width)
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// columns are stored one after another
+			c := columns[x*height+y]
+			i := img.PixOffset(x, y)
+			a := math.Max(math.Min(c[3], 255), 0)
+			img.Pix[i+3] = uint8(math.Round(a))
+			if a == 0 {
+				continue
+			}
+			for k := range c[:3] {
+				img.Pix[i+k] = uint8(math.Round(math.Max(math.Min(c[k]*255/a, 255), 0)))
+			}
+		}
+	}
+	return img
+}
+
+// Pad returns m placed at the top left corner of a transparent image of the given size,
+// so coordinates of its pixels don't change
+func Pad(m image.Image, width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), m, m.Bounds().Min, draw.Src)
+	return img
+}
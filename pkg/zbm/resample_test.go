@@ -0,0 +1,75 @@
+package zbm
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFilter(t *testing.T) {
+	t.Parallel()
+	for _, name := range FilterNames() {
+		f, err := ParseFilter(name)
+		require.NoError(t, err)
+		require.Equal(t, name, f.String())
+	}
+	_, err := ParseFilter("bicubic")
+	require.EqualError(t, err, `unknown filter "bicubic", expected one of: nearest, bilinear, lanczos`)
+}
+
+func TestResample(t *testing.T) {
+	t.Parallel()
+	// almost opaque blue pixel next to a transparent red one, in an image not starting at 0,0
+	src := image.NewNRGBA(image.Rect(1, 1, 3, 2))
+	src.SetNRGBA(1, 1, color.NRGBA{0, 0, 0xFF, 0xFE})
+	src.SetNRGBA(2, 1, color.NRGBA{0xFF, 0, 0, 0})
+
+	cases := []struct {
+		filter Filter
+		width  int
+		// expected colors of the first and the last pixel
+		first, last color.NRGBA
+	}{
+		{FilterNearest, 4, color.NRGBA{0, 0, 0xFF, 0xFE}, color.NRGBA{}},
+		{FilterBilinear, 4, color.NRGBA{0, 0, 0xFF, 0xFE}, color.NRGBA{}},
+		// lanczos overshoots at sharp edges
+		{FilterLanczos, 4, color.NRGBA{0, 0, 0xFF, 0xFF}, color.NRGBA{}},
+		// colors of transparent pixels don't bleed into averaged ones
+		{FilterBilinear, 1, color.NRGBA{0, 0, 0xFF, 0x7F}, color.NRGBA{0, 0, 0xFF, 0x7F}},
+		{FilterLanczos, 1, color.NRGBA{0, 0, 0xFF, 0x7F}, color.NRGBA{0, 0, 0xFF, 0x7F}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.filter.String(), func(t *testing.T) {
+			t.Parallel()
+			img := Resample(src, tt.width, 3, tt.filter)
+			require.Equal(t, image.Rect(0, 0, tt.width, 3), img.Bounds())
+			for y := 0; y < 3; y++ {
+				require.Equal(t, tt.first, img.NRGBAAt(0, y))
+				require.Equal(t, tt.last, img.NRGBAAt(tt.width-1, y))
+			}
+		})
+	}
+
+	// uniform images stay uniform
+	gray := image.NewUniform(color.NRGBA{0x80, 0x80, 0x80, 0xFF})
+	uniform := Resample(&image.NRGBA{Pix: []uint8{0x80, 0x80, 0x80, 0xFF}, Stride: 4, Rect: image.Rect(0, 0, 1, 1)}, 5, 7, FilterLanczos)
+	for y := 0; y < 7; y++ {
+		for x := 0; x < 5; x++ {
+			require.Equal(t, gray.C, uniform.NRGBAAt(x, y))
+		}
+	}
+}
+
+func TestPad(t *testing.T) {
+	t.Parallel()
+	src := image.NewRGBA(image.Rect(2, 2, 4, 3))
+	src.Set(2, 2, color.White)
+	src.Set(3, 2, color.White)
+	img := Pad(src, 3, 2)
+	require.Equal(t, image.Rect(0, 0, 3, 2), img.Bounds())
+	require.Equal(t, color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}, img.NRGBAAt(1, 0))
+	require.Equal(t, color.NRGBA{}, img.NRGBAAt(2, 0))
+	require.Equal(t, color.NRGBA{}, img.NRGBAAt(0, 1))
+}
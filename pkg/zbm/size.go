@@ -0,0 +1,87 @@
+package zbm
+
+import (
+	"fmt"
+	"slices"
+)
+
+// SizeTable lists widths and heights of textures accepted by the console, sorted in ascending order
+type SizeTable struct {
+	Widths  []int
+	Heights []int
+}
+
+// sizeRange returns sizes from first to last, with the given step
+func sizeRange(first, step, last int) []int {
+	var sizes []int
+	for size := first; size <= last; size += step {
+		sizes = append(sizes, size)
+	}
+	return sizes
+}
+
+// DefaultSizeTable returns a conservative table of legal sizes: widths are multiples of 8 pixels,
+// so rows of 16-bit pixels are aligned to 16 bytes, and both sizes are at most 1024.
+// These rules weren't verified on the hardware, use a custom table if they turn out to be wrong
+func DefaultSizeTable() SizeTable {
+	return SizeTable{Widths: sizeRange(8, 8, 1024), Heights: sizeRange(1, 1, 1024)}
+}
+
+// NewSizeTable returns table of the given legal widths and heights, in any order
+func NewSizeTable(widths, heights []int) (SizeTable, error) {
+	t := SizeTable{Widths: slices.Clone(widths), Heights: slices.Clone(heights)}
+	for _, sizes := range [][]int{t.Widths, t.Heights} {
+		if len(sizes) == 0 {
+			return SizeTable{}, FormatError("size table needs at least one width and height")
+		}
+		slices.Sort(sizes)
+		if sizes[0] <= 0 {
+			return SizeTable{}, FormatError(fmt.Sprintf("unsupported size in table: %d", sizes[0]))
+		}
+	}
+	return t, nil
+}
+
+// Legal reports whether texture of the given size is accepted by the console
+func (t SizeTable) Legal(width, height int) bool {
+	_, okWidth := slices.BinarySearch(t.Widths, width)
+	_, okHeight := slices.BinarySearch(t.Heights, height)
+	return okWidth && okHeight
+}
+
+// Check returns an error if texture of the given size isn't accepted by the console
+func (t SizeTable) Check(width, height int) error {
+	if t.Legal(width, height) {
+		return nil
+	}
+	nearestWidth, nearestHeight := t.Nearest(width, height)
+	return FormatError(fmt.Sprintf("size %dx%d isn't legal, the nearest legal size is %dx%d", width, height, nearestWidth, nearestHeight))
+}
+
+// Padded returns the smallest legal size, which fits texture of the given size
+func (t SizeTable) Padded(width, height int) (int, int, error) {
+	i, _ := slices.BinarySearch(t.Widths, width)
+	j, _ := slices.BinarySearch(t.Heights, height)
+	if i == len(t.Widths) || j == len(t.Heights) {
+		return 0, 0, FormatError(fmt.Sprintf("size %dx%d is larger than the largest legal size %dx%d",
+			width, height, t.Widths[len(t.Widths)-1], t.Heights[len(t.Heights)-1]))
+	}
+	return t.Widths[i], t.Heights[j], nil
+}
+
+// Nearest returns the legal size closest to the given one, choosing the larger size on ties
+func (t SizeTable) Nearest(width, height int) (int, int) {
+	return nearestSize(t.Widths, width), nearestSize(t.Heights, height)
+}
+
+// nearestSize returns the value of sorted sizes closest to size
+func nearestSize(sizes []int, size int) int {
+	i, _ := slices.BinarySearch(sizes, size)
+	if i == len(sizes) {
+		return sizes[i-1]
+	}
+	if i > 0 && size-sizes[i-1] < sizes[i]-size {
+		return sizes[i-1]
+	}
+	return sizes[i]
+}
@@ -0,0 +1,61 @@
+package zbm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizeTable(t *testing.T) {
+	t.Parallel()
+	table, err := NewSizeTable([]int{64, 16, 32}, []int{8, 16})
+	require.NoError(t, err)
+	require.Equal(t, []int{16, 32, 64}, table.Widths)
+
+	cases := []struct {
+		name          string
+		width, height int
+		legal         bool
+		nearest       [2]int
+		padded        [2]int
+		paddedError   string
+	}{
+		{"legal", 32, 16, true, [2]int{32, 16}, [2]int{32, 16}, ""},
+		{"between", 20, 12, false, [2]int{16, 16}, [2]int{32, 16}, ""},
+		{"tie", 24, 12, false, [2]int{32, 16}, [2]int{32, 16}, ""},
+		{"small", 1, 1, false, [2]int{16, 8}, [2]int{16, 8}, ""},
+		{"large", 100, 8, false, [2]int{64, 8}, [2]int{}, "gamewave zbm error:size 100x8 is larger than the largest legal size 64x16"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.legal, table.Legal(tt.width, tt.height))
+			width, height := table.Nearest(tt.width, tt.height)
+			require.Equal(t, tt.nearest, [2]int{width, height})
+			if tt.legal {
+				require.NoError(t, table.Check(tt.width, tt.height))
+			} else {
+				require.Error(t, table.Check(tt.width, tt.height))
+			}
+
+			width, height, err := table.Padded(tt.width, tt.height)
+			if tt.paddedError != "" {
+				require.EqualError(t, err, tt.paddedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.padded, [2]int{width, height})
+		})
+	}
+
+	require.EqualError(t, table.Check(20, 12), "gamewave zbm error:size 20x12 isn't legal, the nearest legal size is 16x16")
+	_, err = NewSizeTable(nil, []int{8})
+	require.EqualError(t, err, "gamewave zbm error:size table needs at least one width and height")
+	_, err = NewSizeTable([]int{8}, []int{0, 8})
+	require.EqualError(t, err, "gamewave zbm error:unsupported size in table: 0")
+
+	defaults := DefaultSizeTable()
+	require.True(t, defaults.Legal(8, 3))
+	require.False(t, defaults.Legal(12, 3))
+	require.False(t, defaults.Legal(1032, 8))
+}
@@ -0,0 +1,100 @@
+package zbm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"image"
+	"io"
+)
+
+// maxSize is the largest width or height of a texture accepted by Sniff
+const maxSize = 4096
+
+// Sniff reports whether r looks like a zbm file, checking invariants of the header
+// and the start of zlib stream following it. Only the beginning of r is read,
+// and its position is restored afterwards
+func Sniff(r io.ReadSeeker) (bool, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+	buf := make([]byte, HeaderSize+2)
+	_, err = io.ReadFull(r, buf)
+	if _, seekErr := r.Seek(start, io.SeekStart); seekErr != nil {
+		return false, seekErr
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return sniffBytes(buf), nil
+}
+
+// sniffBytes reports whether the header and two following bytes in buf look like a zbm file
+func sniffBytes(buf []byte) bool {
+	h, err := ReadHeader(bytes.NewReader(buf))
+	if err != nil {
+		return false
+	}
+	return plausible(h) && isZlibHeader(buf[HeaderSize], buf[HeaderSize+1])
+}
+
+// peeker is implemented by readers passed to formats registered by image.RegisterFormat
+type peeker interface {
+	io.Reader
+	Peek(n int) ([]byte, error)
+}
+
+// sniffReader returns reader of the same data as r, or an error if r doesn't pass Sniff checks.
+// Magic string of the registered format matches only a few bytes of the header, so the registered
+// decoders check the whole header, and don't claim other files starting similarly
+func sniffReader(r io.Reader) (io.Reader, error) {
+	p, ok := r.(peeker)
+	if !ok {
+		p = bufio.NewReader(r)
+	}
+	buf, err := p.Peek(HeaderSize + 2)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if len(buf) < HeaderSize+2 || !sniffBytes(buf) {
+		return nil, FormatError("header doesn't look like a texture")
+	}
+	return p, nil
+}
+
+// decodeSniffed decodes image registered by image.RegisterFormat, if r passes Sniff checks
+func decodeSniffed(r io.Reader) (image.Image, error) {
+	r, err := sniffReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(r)
+}
+
+// decodeConfigSniffed decodes config of image registered by image.RegisterFormat, if r passes Sniff checks
+func decodeConfigSniffed(r io.Reader) (image.Config, error) {
+	r, err := sniffReader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return DecodeConfig(r)
+}
+
+// plausible reports whether h looks like a header of a texture
+func plausible(h Header) bool {
+	if h.Type != 1 || h.OSD > 1 || h.check() != nil || h.Width > maxSize || h.Height > maxSize {
+		return false
+	}
+	minimum, padded := h.dataSize()
+	return h.SizePacked > 2 && (int(h.SizeUnpacked) == minimum || int(h.SizeUnpacked) == padded)
+}
+
+// isZlibHeader reports whether cmf and flg bytes start a zlib stream packed with deflate
+func isZlibHeader(cmf, flg byte) bool {
+	return cmf&0xF == 8 && cmf>>4 <= 7 && (uint16(cmf)<<8|uint16(flg))%31 == 0 && flg&0x20 == 0
+}
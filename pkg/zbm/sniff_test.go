@@ -0,0 +1,102 @@
+package zbm
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSniff(t *testing.T) {
+	t.Parallel()
+	valid := texture(t, FormatCrCbYA3364, 2, 2, 1, 0x12345678)
+	modified := func(offset int, value byte) []byte {
+		data := bytes.Clone(valid)
+		data[offset] = value
+		return data
+	}
+	pngData := bytes.Buffer{}
+	require.NoError(t, png.Encode(&pngData, image.NewGray(image.Rect(0, 0, 1, 1))))
+
+	cases := []struct {
+		name     string
+		data     []byte
+		expected bool
+	}{
+		{"valid", valid, true},
+		{"padded", texture(t, FormatCrCbYA3364, 2, 3, 1, 0, 0), true},
+		{"png", pngData.Bytes(), false},
+		{"empty", nil, false},
+		{"only header", valid[:HeaderSize], false},
+		{"type", modified(0x0, 2), false},
+		{"osd", modified(0x4, 2), false},
+		{"unknown format", modified(0x8, 9), false},
+		{"bytes per pixel", modified(0xC, 4), false},
+		{"width", modified(0x10, 3), false},
+		{"too large", modified(0x13, 1), false},
+		{"unpacked size", modified(0x28, 8), false},
+		{"not zlib", modified(HeaderSize, 0x79), false},
+	}
+	for _, tt := range cases {
+		data := tt.data
+		expected := tt.expected
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			r := bytes.NewReader(append([]byte("xx"), data...))
+			_, err := r.Seek(2, io.SeekStart)
+			require.NoError(t, err)
+			ok, err := Sniff(r)
+			require.NoError(t, err)
+			require.Equal(t, expected, ok)
+			// position is restored
+			require.Equal(t, len(data), r.Len())
+		})
+	}
+}
+
+func TestImageDecode(t *testing.T) {
+	t.Parallel()
+	// callers of older versions register the format themselves, which does nothing now
+	RegisterFormat()
+	buf := bytes.Buffer{}
+	require.NoError(t, Encode(&buf, image.NewGray(image.Rect(0, 0, 3, 5))))
+	img, format, err := image.Decode(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, FormatName, format)
+	require.Equal(t, image.Rect(0, 0, 3, 5), img.Bounds())
+
+	// other formats aren't claimed
+	pngData := bytes.Buffer{}
+	require.NoError(t, png.Encode(&pngData, img))
+	_, format, err = image.DecodeConfig(&pngData)
+	require.NoError(t, err)
+	require.Equal(t, "png", format)
+}
+
+func TestImageDecodeSniffed(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		// the magic string matches, but the size of pixel data doesn't
+		{"wrong unpacked size", textureData(t, FormatCrCbYA3364, 2, 2, 2, make([]byte, 12))},
+		{"bad zlib check", func() []byte {
+			data := texture(t, FormatCrCbYA3364, 2, 2, 1, 0)
+			data[HeaderSize+1]++
+			return data
+		}()},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, _, err := image.Decode(bytes.NewReader(tt.data))
+			require.EqualError(t, err, "gamewave zbm error:header doesn't look like a texture")
+			_, _, err = image.DecodeConfig(bytes.NewReader(tt.data))
+			require.EqualError(t, err, "gamewave zbm error:header doesn't look like a texture")
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package zbm
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+
+	"github.com/namgo/GameWaveFans/pkg/common"
+)
+
+// Decoder reads pixels of a texture row by row, unpacking them while reading,
+// so the whole pixel data doesn't have to be kept in memory. Rows of the first level are read
+// first, NextLevel moves to the following levels
+type Decoder struct {
+	h  Header
+	zr io.ReadCloser
+	r  *bufio.Reader
+	// number of bytes of pixel data read
+	read int
+	buf  [4]byte
+	// the current word and the number of pixels left in it
+	word uint32
+	left int
+
+	// alpha options of decoded colors
+	o Options
+
+	// the current level, number of bytes read before it, its size, and the current row
+	level      int
+	levelStart int
+	width      int
+	height     int
+	y          int
+	row        []color.NRGBA
+	raw        []uint16
+	planes     []components
+}
+
+// NewDecoder reads header of a texture from r, and returns decoder of its pixels
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	h, err := ReadHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if err = h.check(); err != nil {
+		return nil, err
+	}
+	if size, _ := h.dataSize(); int(h.SizeUnpacked) < size {
+		return nil, FormatError(fmt.Sprintf("%s pixel data too short: got %d bytes, expected %d", h.FormatID, h.SizeUnpacked, size))
+	}
+
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	d := &Decoder{h: h, zr: zr, r: bufio.NewReader(zr)}
+	d.row = make([]color.NRGBA, h.Width)
+	d.raw = make([]uint16, h.Width)
+	d.planes = make([]components, h.Width)
+	d.startLevel(0)
+	return d, nil
+}
+
+// startLevel prepares reading of the given level, which starts with a new word
+func (d *Decoder) startLevel(level int) {
+	d.level = level
+	d.levelStart = d.read
+	d.width, d.height = d.h.LevelSize(level)
+	d.y = 0
+	d.left = 0
+}
+
+// Level returns the current level, and its width and height
+func (d *Decoder) Level() (level, width, height int) {
+	return d.level, d.width, d.height
+}
+
+// NextLevel skips rows left in the current level, and moves to the next one.
+// It returns io.EOF if the current level is the last one
+func (d *Decoder) NextLevel() error {
+	if d.level+1 >= d.h.LevelCount() {
+		return io.EOF
+	}
+	size := (d.h.FormatID.imageSize(d.width, d.height) + 3) / 4 * 4
+	n, err := io.CopyN(io.Discard, d.r, int64(size-(d.read-d.levelStart)))
+	d.read += int(n)
+	if err != nil {
+		return d.sizeError(err)
+	}
+	d.startLevel(d.level + 1)
+	return nil
+}
+
+// SetOptions sets alpha mode of colors returned by NextRow, other options are ignored.
+// Raw rows are returned as stored
+func (d *Decoder) SetOptions(o *Options) {
+	d.o = *o
+}
+
+// Header returns header of the texture
+func (d *Decoder) Header() Header {
+	return d.h
+}
+
+// next returns the next value of given size in bits
+func (d *Decoder) next(bits int) (uint32, error) {
+	if d.left == 0 {
+		n, err := io.ReadFull(d.r, d.buf[:])
+		d.read += n
+		if err == io.ErrUnexpectedEOF {
+			// incomplete last word, older versions of this package didn't write the low half
+			// with the last pixel of odd count, it's decoded as zero
+			clear(d.buf[n:])
+		} else if err != nil {
+			return 0, d.sizeError(err)
+		}
+		d.word = binary.BigEndian.Uint32(d.buf[:])
+		d.left = 32 / bits
+	}
+	value := d.word & uint32(uint64(1)<<bits-1)
+	d.word = uint32(uint64(d.word) >> bits)
+	d.left--
+	return value, nil
+}
+
+// sizeError returns error reported when pixel data ended with err
+func (d *Decoder) sizeError(err error) error {
+	if err == io.EOF {
+		return FormatError(fmt.Sprintf("unpacked size mismatch: got %d, expected %d\n", d.read, d.h.SizeUnpacked))
+	}
+	return err
+}
+
+// nextComponents returns components of the next pixel
+func (d *Decoder) nextComponents() (components, error) {
+	value, err := d.next(d.h.FormatID.BitsPerPixel())
+	if err != nil {
+		return components{}, err
+	}
+	return components3364(uint16(value)), nil
+}
+
+// nextRowStart returns io.EOF if all rows of the current level were read
+func (d *Decoder) nextRowStart() error {
+	if d.y == d.height {
+		return io.EOF
+	}
+	d.y++
+	return nil
+}
+
+// nextRowEnd checks size of pixel data after the last row of the last level
+func (d *Decoder) nextRowEnd() error {
+	if d.y < d.height || d.level < d.h.LevelCount()-1 {
+		return nil
+	}
+	n, err := io.Copy(io.Discard, d.r)
+	d.read += int(n)
+	if err != nil {
+		return err
+	}
+	if d.read != int(d.h.SizeUnpacked) {
+		return d.sizeError(io.EOF)
+	}
+	return d.zr.Close()
+}
+
+// NextRow returns colors of pixels of the next row of the current level, or io.EOF
+// after the last one. The returned slice is reused by following calls
+func (d *Decoder) NextRow() ([]color.NRGBA, error) {
+	if err := d.nextRowStart(); err != nil {
+		return nil, err
+	}
+	row := d.row[:d.width]
+	for x := range row {
+		c, err := d.nextComponents()
+		if err != nil {
+			return nil, err
+		}
+		row[x] = d.o.decodeAlpha(c.color())
+	}
+	return row, d.nextRowEnd()
+}
+
+// nextPlanesRow returns components of pixels of the next row of the current level,
+// or io.EOF after the last one. The returned slice is reused by following calls
+func (d *Decoder) nextPlanesRow() ([]components, error) {
+	if err := d.nextRowStart(); err != nil {
+		return nil, err
+	}
+	row := d.planes[:d.width]
+	for x := range row {
+		c, err := d.nextComponents()
+		if err != nil {
+			return nil, err
+		}
+		row[x] = c
+	}
+	return row, d.nextRowEnd()
+}
+
+// NextRawRow returns 16-bit words of the next row of the current level as stored, or io.EOF
+// after the last one. The returned slice is reused by following calls
+func (d *Decoder) NextRawRow() ([]uint16, error) {
+	if err := d.nextRowStart(); err != nil {
+		return nil, err
+	}
+	row := d.raw[:d.width]
+	for x := range row {
+		value, err := d.next(16)
+		if err != nil {
+			return nil, err
+		}
+		row[x] = uint16(value)
+	}
+	return row, d.nextRowEnd()
+}
+
+// Encoder writes CrCbYA 3364 pixels of a texture row by row, packing them while writing.
+// Only the packed data is kept in memory, the texture is written by Close, when its size is known.
+// Rows of all levels are written one after another
+type Encoder struct {
+	w      io.Writer
+	h      Header
+	packed bytes.Buffer
+	// zw packs data with compression, it's created by the first write
+	zw          io.WriteCloser
+	compression common.Compression
+	// number of bytes of pixel data and number of pixels written
+	written int
+	pixels  int
+	buf     [4]byte
+	// the first pixel of a word
+	pending    uint16
+	hasPending bool
+	// the current level, and the number of its rows written
+	level int
+	y     int
+}
+
+// NewEncoder returns encoder writing texture with header h to w. All fields of h are kept,
+// except for sizes of data
+func NewEncoder(w io.Writer, h Header) (*Encoder, error) {
+	if err := h.check(); err != nil {
+		return nil, err
+	}
+	return &Encoder{w: w, h: h, compression: common.DefaultCompression}, nil
+}
+
+// SetOptions sets compression of pixel data, other options are ignored, as rows are
+// already converted. It has to be called before writing rows
+func (e *Encoder) SetOptions(o *Options) {
+	if o.Compression != nil {
+		e.compression = *o.Compression
+	}
+}
+
+func (e *Encoder) write(data []byte) error {
+	if e.zw == nil {
+		zw, err := common.NewZlibWriter(&e.packed, e.compression)
+		if err != nil {
+			return err
+		}
+		e.zw = zw
+	}
+	n, err := e.zw.Write(data)
+	e.written += n
+	return err
+}
+
+// pixelCount returns the number of pixels of all levels
+func (e *Encoder) pixelCount() int {
+	count := 0
+	for level := 0; level < e.h.LevelCount(); level++ {
+		width, height := e.h.LevelSize(level)
+		count += width * height
+	}
+	return count
+}
+
+// WriteRawRow writes the next row of CrCbYA 3364 words
+func (e *Encoder) WriteRawRow(row []uint16) error {
+	width, height := e.h.LevelSize(e.level)
+	if e.y == height {
+		if e.level+1 >= e.h.LevelCount() {
+			return FormatError(fmt.Sprintf("too many rows for %dx%d texture", e.h.Width, e.h.Height))
+		}
+		// levels start with a new word
+		if err := e.flush(); err != nil {
+			return err
+		}
+		e.level++
+		e.y = 0
+		width, _ = e.h.LevelSize(e.level)
+	}
+	if len(row) != width {
+		return FormatError(fmt.Sprintf("got row of %d pixels for %dx%d texture", len(row), e.h.Width, e.h.Height))
+	}
+	e.pixels += len(row)
+	e.y++
+
+	// swap every two pixels, endianness changes a bit
+	for _, pixel := range row {
+		if !e.hasPending {
+			e.pending, e.hasPending = pixel, true
+			continue
+		}
+		binary.BigEndian.PutUint32(e.buf[:], uint32(pixel)<<16|uint32(e.pending))
+		e.hasPending = false
+		if err := e.write(e.buf[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush writes the last pixel of odd count in a word of its own
+func (e *Encoder) flush() error {
+	if !e.hasPending {
+		return nil
+	}
+	binary.BigEndian.PutUint32(e.buf[:], uint32(e.pending))
+	e.hasPending = false
+	return e.write(e.buf[:])
+}
+
+// Close writes the texture, after all rows were written
+func (e *Encoder) Close() error {
+	if e.pixels != e.pixelCount() {
+		return FormatError(fmt.Sprintf("got %d pixels for %dx%d texture", e.pixels, e.h.Width, e.h.Height))
+	}
+	if err := e.flush(); err != nil {
+		return err
+	}
+	// textures have at least one pixel, so the writer was created
+	if err := e.zw.Close(); err != nil {
+		return err
+	}
+
+	e.h.SizePacked = uint32(e.packed.Len())
+	e.h.SizeUnpacked = uint32(e.written)
+	if err := WriteHeader(e.w, e.h); err != nil {
+		return err
+	}
+	_, err := e.w.Write(e.packed.Bytes())
+	return err
+}
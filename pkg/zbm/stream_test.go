@@ -0,0 +1,98 @@
+package zbm
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"testing"
+
+	"github.com/namgo/GameWaveFans/pkg/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+	// rows of odd width share words
+	rows := [][]uint16{{1, 2, 3}, {4, 5, 6}}
+	buf := bytes.Buffer{}
+	e, err := NewEncoder(&buf, NewHeader(3, 2))
+	require.NoError(t, err)
+	require.EqualError(t, e.WriteRawRow([]uint16{1}), "gamewave zbm error:got row of 1 pixels for 3x2 texture")
+	require.NoError(t, e.WriteRawRow(rows[0]))
+	require.EqualError(t, e.Close(), "gamewave zbm error:got 3 pixels for 3x2 texture")
+	require.NoError(t, e.WriteRawRow(rows[1]))
+	require.EqualError(t, e.WriteRawRow(rows[1]), "gamewave zbm error:too many rows for 3x2 texture")
+	require.NoError(t, e.Close())
+	require.Equal(t, texture(t, FormatCrCbYA3364, 2, 3, 2, 0x20001, 0x40003, 0x60005), buf.Bytes())
+
+	d, err := NewDecoder(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, uint32(12), d.Header().SizeUnpacked)
+	for _, expected := range rows {
+		row, err := d.NextRawRow()
+		require.NoError(t, err)
+		require.Equal(t, expected, row)
+	}
+	_, err = d.NextRawRow()
+	require.ErrorIs(t, err, io.EOF)
+
+	h := NewHeader(1, 1)
+	h.FormatID = 3
+	_, err = NewEncoder(&buf, h)
+	require.EqualError(t, err, "gamewave zbm error:unsupported pixel format 3")
+}
+
+func TestDecoderSize(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name         string
+		sizeUnpacked uint32
+		expected     string
+	}{
+		{"data too long", 4, "gamewave zbm error:unpacked size mismatch: got 8, expected 4\n"},
+		{"data too short", 12, "gamewave zbm error:unpacked size mismatch: got 8, expected 12\n"},
+	}
+	for _, tt := range cases {
+		sizeUnpacked := tt.sizeUnpacked
+		expected := tt.expected
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			data := texture(t, FormatCrCbYA3364, 2, 2, 1, 0, 0)
+			// SizeUnpacked is at 0x28
+			data[0x28] = byte(sizeUnpacked)
+			d, err := NewDecoder(bytes.NewReader(data))
+			require.NoError(t, err)
+			_, err = d.NextRow()
+			require.EqualError(t, err, expected)
+		})
+	}
+}
+
+func TestEncoderCompression(t *testing.T) {
+	t.Parallel()
+	m := NewCrCbYA(image.Rect(0, 0, 64, 32))
+	for i := range m.Pix {
+		m.Pix[i] = uint16(0xF000 | i%64<<6 | i/256)
+	}
+	sizes := map[string]int{}
+	for _, tt := range []struct {
+		name        string
+		compression *common.Compression
+	}{
+		{"default", nil},
+		{"fastest", &common.Compression{Level: 1}},
+		{"exhaustive", &common.Compression{Iterations: 3}},
+	} {
+		buf := bytes.Buffer{}
+		require.NoError(t, EncodeOptions(&buf, m, &Options{Compression: tt.compression}))
+		h, pixels, err := DecodeRaw(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err, tt.name)
+		require.Equal(t, m.Pix, pixels, tt.name)
+		require.Equal(t, int(h.SizePacked), buf.Len()-HeaderSize, tt.name)
+		sizes[tt.name] = buf.Len()
+	}
+	require.Less(t, sizes["exhaustive"], sizes["default"])
+
+	err := EncodeOptions(io.Discard, m, &Options{Compression: &common.Compression{Level: 12}})
+	require.EqualError(t, err, "unsupported compression level 12, expected 0-9")
+}
@@ -0,0 +1,90 @@
+package zbm
+
+import (
+	"fmt"
+	"image"
+	"io"
+)
+
+/*
+Textures with more than one level weren't found in official games, the layout of their levels
+is assumed: each level is half the size of the previous one, and pixels of every level start
+with a new word
+*/
+
+// Texture is a decoded texture with all its levels
+type Texture struct {
+	Header Header
+	// Levels holds images of all levels, starting with the full size one
+	Levels []image.Image
+}
+
+// DecodeTexture reads a texture with all its levels from r, with colors decoded using alpha mode of o,
+// or AlphaFull if o is nil
+func DecodeTexture(r io.Reader, o *Options) (*Texture, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	if o != nil {
+		d.SetOptions(o)
+	}
+	t := &Texture{Header: d.Header()}
+	for {
+		_, width, height := d.Level()
+		m := image.NewNRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			row, err := d.NextRow()
+			if err != nil {
+				return nil, err
+			}
+			for x, c := range row {
+				m.SetNRGBA(x, y, c)
+			}
+		}
+		t.Levels = append(t.Levels, m)
+
+		if err = d.NextLevel(); err == io.EOF {
+			return t, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// EncodeTexture encodes images of all levels to .zbm file, with the given options, or the default
+// ones if o is nil. Each level has to be half the size of the previous one, and at least 1x1
+func EncodeTexture(w io.Writer, levels []image.Image, o *Options) error {
+	if len(levels) == 0 {
+		return FormatError("no levels to encode")
+	}
+	b := levels[0].Bounds()
+	h := NewHeader(b.Dx(), b.Dy())
+	h.Levels = uint32(len(levels))
+	if err := h.check(); err != nil {
+		return err
+	}
+	for level, m := range levels {
+		width, height := h.LevelSize(level)
+		if size := m.Bounds().Size(); size.X != width || size.Y != height {
+			return FormatError(fmt.Sprintf("level %d is %dx%d, expected %dx%d", level, size.X, size.Y, width, height))
+		}
+	}
+
+	e, err := NewEncoder(w, h)
+	if err != nil {
+		return err
+	}
+	if o != nil {
+		e.SetOptions(o)
+	}
+	for _, m := range levels {
+		nextRow := imageRows(m, o)
+		for y := 0; y < m.Bounds().Dy(); y++ {
+			if err = e.WriteRawRow(nextRow()); err != nil {
+				return err
+			}
+		}
+	}
+	return e.Close()
+}
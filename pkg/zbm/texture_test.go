@@ -0,0 +1,86 @@
+package zbm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTexture(t *testing.T) {
+	t.Parallel()
+	// levels of odd sizes start with a new word
+	levels := []image.Image{NewCrCbYA(image.Rect(0, 0, 5, 3)), NewCrCbYA(image.Rect(0, 0, 2, 1)), NewCrCbYA(image.Rect(0, 0, 1, 1))}
+	for level, m := range levels {
+		native := m.(*CrCbYA)
+		for i := range native.Pix {
+			native.Pix[i] = uint16(0xF000 | level<<8 | i)
+		}
+	}
+	buf := bytes.Buffer{}
+	require.NoError(t, EncodeTexture(&buf, levels, nil))
+
+	tex, err := DecodeTexture(bytes.NewReader(buf.Bytes()), nil)
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), tex.Header.Levels)
+	require.Equal(t, uint32(32+4+4), tex.Header.SizeUnpacked)
+	require.Len(t, tex.Levels, len(levels))
+	for level, m := range levels {
+		require.Equal(t, m.Bounds(), tex.Levels[level].Bounds())
+		native := m.(*CrCbYA)
+		for i, word := range native.Pix {
+			x, y := i%native.Rect.Dx(), i/native.Rect.Dx()
+			require.Equal(t, convertCrCbYA3364(word), tex.Levels[level].At(x, y), "level %d pixel %d", level, i)
+		}
+	}
+
+	// the first level of a texture is decoded by image.Decode
+	m, _, err := image.Decode(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, tex.Levels[0], m)
+
+	// rows of skipped levels aren't read
+	d, err := NewDecoder(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.NoError(t, d.NextLevel())
+	require.NoError(t, d.NextLevel())
+	level, width, height := d.Level()
+	require.Equal(t, []int{2, 1, 1}, []int{level, width, height})
+	row, err := d.NextRawRow()
+	require.NoError(t, err)
+	require.Equal(t, []uint16{0xF200}, row)
+	_, err = d.NextRawRow()
+	require.ErrorIs(t, err, io.EOF)
+	require.ErrorIs(t, d.NextLevel(), io.EOF)
+}
+
+func TestEncodeTextureErrors(t *testing.T) {
+	t.Parallel()
+	rgba := func(width, height int) image.Image {
+		m := image.NewRGBA(image.Rect(0, 0, width, height))
+		m.Set(0, 0, color.White)
+		return m
+	}
+	cases := []struct {
+		name     string
+		levels   []image.Image
+		expected string
+	}{
+		{"no levels", nil, "gamewave zbm error:no levels to encode"},
+		{"wrong size", []image.Image{rgba(4, 4), rgba(2, 1)}, "gamewave zbm error:level 1 is 2x1, expected 2x2"},
+		{"too many levels", make([]image.Image, MaxLevels+1), "gamewave zbm error:unsupported number of levels 17"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			levels := tt.levels
+			if len(levels) > 0 && levels[0] == nil {
+				levels = append([]image.Image{rgba(1, 1)}, levels[1:]...)
+			}
+			require.EqualError(t, EncodeTexture(io.Discard, levels, nil), tt.expected)
+		})
+	}
+}
@@ -1,13 +1,10 @@
 package zbm
 
 import (
-	"encoding/binary"
 	"image"
 	"image/color"
 	"io"
 	"math"
-
-	"github.com/namgo/GameWaveFans/pkg/common"
 )
 
 /*
@@ -33,98 +30,59 @@ func convertColorToCrCbYA(c color.Color) uint16 {
 	return col
 }
 
-func convertImage(m image.Image) []byte {
-	pixelBuffer := make([]uint16, m.Bounds().Dx()*m.Bounds().Dy())
-
-	for y := 0; y < m.Bounds().Dy(); y++ {
-		for x := 0; x < m.Bounds().Dx(); x++ {
-			pixelBuffer[x+(y*m.Bounds().Dx())] = convertColorToCrCbYA(m.At(x, y))
-		}
+// imageRows returns a function returning rows of m converted to CrCbYA 3364 words
+func imageRows(m image.Image, o *Options) func() []uint16 {
+	if o == nil {
+		o = &Options{}
 	}
-
-	data := make([]byte, len(pixelBuffer)*2)
-
-	// swap every two pixels, endianness changes a bit
-	for i := 0; i < len(pixelBuffer)-1; i += 2 {
-		pixelData := make([]byte, 2)
-		pixelData2 := make([]byte, 2)
-		binary.BigEndian.PutUint16(pixelData, pixelBuffer[i])
-		binary.BigEndian.PutUint16(pixelData2, pixelBuffer[i+1])
-
-		data[i*2] = pixelData2[0]
-		data[i*2+1] = pixelData2[1]
-		data[i*2+2] = pixelData[0]
-		data[i*2+3] = pixelData[1]
+	// CrCbYA images are copied without conversion, only their alpha is changed
+	if native, ok := m.(*CrCbYA); ok {
+		b := m.Bounds()
+		y := b.Min.Y
+		row := make([]uint16, b.Dx())
+		return func() []uint16 {
+			i := native.PixOffset(b.Min.X, y)
+			y++
+			for x, word := range native.Pix[i : i+b.Dx()] {
+				row[x] = o.encodeAlphaWord(word)
+			}
+			return row
+		}
 	}
-	return data
+	return newRowQuantizer(m, o).nextRow
 }
 
-// write header of zbm file, before packed data is written
-func writeHeader(w io.Writer, im image.Image, unpackedSize, packedSize uint32) error {
-	if _, err := common.WriteUint32(w, 1); err != nil {
-		return err
-	}
-
-	// TEXTURE_OSD
-	if _, err := common.WriteUint32(w, 1); err != nil {
-		return err
-	}
-	// 3364 format
-	if _, err := common.WriteUint32(w, 4); err != nil {
-		return err
-	}
-	// BPP
-	if _, err := common.WriteUint32(w, 2); err != nil {
-		return err
+func convertImage(m image.Image, o *Options) []uint16 {
+	b := m.Bounds()
+	pixelBuffer := make([]uint16, 0, b.Dx()*b.Dy())
+	nextRow := imageRows(m, o)
+	for y := 0; y < b.Dy(); y++ {
+		pixelBuffer = append(pixelBuffer, nextRow()...)
 	}
-	// width
-	if _, err := common.WriteUint32(w, uint32(im.Bounds().Dx())); err != nil {
-		return err
-	}
-	// height
-	if _, err := common.WriteUint32(w, uint32(im.Bounds().Dy())); err != nil {
-		return err
-	}
-
-	if _, err := common.WriteUint32(w, 0); err != nil {
-		return err
-	}
-	if _, err := common.WriteUint32(w, 0); err != nil {
-		return err
-	}
-	if _, err := common.WriteUint32(w, 1); err != nil {
-		return err
-	}
-
-	if _, err := common.WriteUint32(w, packedSize); err != nil {
-		return err
-	}
-	if _, err := common.WriteUint32(w, unpackedSize); err != nil {
-		return err
-	}
-
-	_, err := common.WriteUint32(w, 0)
-	return err
+	return pixelBuffer
 }
 
 // Encode encodes image.Image to .zbm file
 func Encode(w io.Writer, m image.Image) error {
-	//convert data
-	convertedData := convertImage(m)
+	return EncodeOptions(w, m, nil)
+}
 
-	// pack data
-	packedData, err := common.WriteZlibToBuffer(convertedData)
+// EncodeOptions encodes image.Image to .zbm file like Encode, with the given options,
+// or the default ones if o is nil
+func EncodeOptions(w io.Writer, m image.Image, o *Options) error {
+	b := m.Bounds()
+	e, err := NewEncoder(w, NewHeader(b.Dx(), b.Dy()))
 	if err != nil {
 		return err
 	}
-
-	// write header
-	err = writeHeader(w, m, uint32(len(convertedData)), uint32(len(packedData)))
-	if err != nil {
-		return err
+	if o != nil {
+		e.SetOptions(o)
 	}
-
-	// write data
-	_, err = w.Write(packedData)
-	return err
+	nextRow := imageRows(m, o)
+	for y := 0; y < b.Dy(); y++ {
+		if err = e.WriteRawRow(nextRow()); err != nil {
+			return err
+		}
+	}
+	return e.Close()
 }
@@ -22,6 +22,11 @@ func convertData(d []int) []byte {
 
 // Encode creates .zwf file from an audio buffer
 func Encode(w io.Writer, buf *audio.IntBuffer) error {
+	return EncodeCompression(w, buf, common.DefaultCompression)
+}
+
+// EncodeCompression creates .zwf file from an audio buffer, with samples packed using compression c
+func EncodeCompression(w io.Writer, buf *audio.IntBuffer, c common.Compression) error {
 	sampleCount := make([]byte, 4)
 	binary.LittleEndian.PutUint32(sampleCount, uint32(len(buf.Data)))
 
@@ -29,7 +34,7 @@ func Encode(w io.Writer, buf *audio.IntBuffer) error {
 	binary.LittleEndian.PutUint32(unpackedSize, uint32(len(buf.Data)*2))
 
 	data := convertData(buf.Data)
-	packedData, err := common.WriteZlibToBuffer(data)
+	packedData, err := common.WriteZlibToBufferCompression(data, c)
 	if err != nil {
 		return err
 	}